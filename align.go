@@ -0,0 +1,20 @@
+package main
+
+import (
+	"path"
+	"strings"
+)
+
+// Archives whose stored entries tools expect to be aligned.
+var alignedArchiveTypes = []string{".apk", ".aab", ".apex", ".xapk"}
+
+func isAlignedArchive(filename string) bool {
+	ext := strings.ToLower(path.Ext(filename))
+	for _, alignedExt := range alignedArchiveTypes {
+		if ext == alignedExt {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"archive/zip"
+	"cmp"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+)
+
+// How many of the largest entries the analysis shows.
+const analyzeLargest = 10
+
+// Return the entries which fit in a part of the split size, and those
+// which never do, so the parts the others take can still be counted.
+// Cutting up large entries makes every one of them fit.
+func fittingEntries(files []*zip.FileHeader,
+	config Config) ([]*zip.FileHeader, []*zip.FileHeader) {
+
+	overhead := partOverhead(config)
+	if config.splitLarge || config.splitSize <= overhead {
+		return files, nil
+	}
+	capacity := config.splitSize - overhead
+
+	var fitting, never []*zip.FileHeader
+	for _, file := range files {
+		if entrySize(file, config) > capacity {
+			never = append(never, file)
+		} else {
+			fitting = append(fitting, file)
+		}
+	}
+
+	return fitting, never
+}
+
+// Show what the source holds and how it would be split, without
+// writing anything.
+func printAnalysis(files []*zip.FileHeader, buckets []*Bucket,
+	never []*zip.FileHeader, config Config) {
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	var compressed, uncompressed uint64
+	for _, file := range files {
+		compressed += file.CompressedSize64
+		uncompressed += file.UncompressedSize64
+	}
+	fmt.Fprintf(w, "Entries:\t%d\n", len(files))
+	fmt.Fprintf(w, "Compressed:\t%s\n", numberToHuman(compressed))
+	fmt.Fprintf(w, "Uncompressed:\t%s\n", numberToHuman(uncompressed))
+
+	parts := fmt.Sprint(len(buckets))
+	if len(never) > 0 {
+		parts += fmt.Sprintf(", leaving out %d entries", len(never))
+	}
+	fmt.Fprintf(w, "Parts of %s:\t%s\n", numberToHuman(config.splitSize), parts)
+
+	largest := slices.Clone(files)
+	slices.SortStableFunc(largest, func(a, b *zip.FileHeader) int {
+		return cmp.Compare(b.CompressedSize64, a.CompressedSize64)
+	})
+	if len(largest) > analyzeLargest {
+		largest = largest[:analyzeLargest]
+	}
+	fmt.Fprintln(w, "Largest entries:")
+	for _, file := range largest {
+		fmt.Fprintf(w, "  %12d\t%s\n", file.CompressedSize64, file.Name)
+	}
+
+	if len(never) > 0 {
+		fmt.Fprintln(w, "Can never fit:")
+		for _, file := range never {
+			fmt.Fprintf(w, "  %12d\t%s\n", file.CompressedSize64, file.Name)
+		}
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/sink"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Add the entries of another archive to an existing split set, using
+// the room left in its parts before adding new ones.
+func appendCommand(args []string) {
+	flags := flag.NewFlagSet("append", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Manifest of the split set to add to.")
+
+	splitSizeString := flags.String(
+		"s",
+		"",
+		"Maximum size per part, by default the one the set was\n"+
+			"split with.")
+
+	nameTemplate := flags.String(
+		"out",
+		"",
+		"Output name template for new parts, by default the one\n"+
+			"the set was split with.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Show some information about the process.")
+
+	flags.Parse(args)
+
+	if *manifestName == "" || flags.NArg() != 1 {
+		fatal(errors.New(
+			"Usage: zipsplit append -manifest manifest.json archive.zip"))
+	}
+
+	m, err := manifest.Load(*manifestName)
+	if err != nil {
+		fatal(err)
+	}
+
+	config := Config{
+		sourceArchive: flags.Arg(0),
+		nameTemplate:  m.NameTemplate,
+		splitSize:     m.SplitSize,
+		measure:       m.Measure,
+		hash:          m.Hash,
+		onCorrupt:     corruptAbort,
+		unsafePaths:   unsafeWarn,
+		format:        formatZip,
+		retries:       5,
+		partSink:      sink.File{},
+		overhead:      split.DefaultOverhead,
+		verbose:       *verbose}
+
+	if *splitSizeString != "" {
+		var err error
+		if config.splitSize, err = humanToNumber(*splitSizeString); err != nil {
+			fatal(err)
+		}
+	}
+	if config.splitSize == 0 {
+		fatal(errors.New("Unknown split size, please supply one."))
+	}
+	if *nameTemplate != "" {
+		config.nameTemplate = *nameTemplate
+	}
+	if config.nameTemplate == "" {
+		config.nameTemplate = "out-%03d.zip"
+	}
+	if isNameTemplate(config.nameTemplate) {
+		fatal(errors.New("Parts named with {variables} cannot be " +
+			"added to, name the new ones with -out."))
+	}
+
+	if err := appendToSet(m, config); err != nil {
+		fatal(err)
+	}
+
+	recordPartSums(m)
+	if err := m.Save(*manifestName); err != nil {
+		fatal(err)
+	}
+}
+
+func appendToSet(m *manifest.Manifest, config Config) error {
+	sourceReader, sourceCloser, err := openSource(config)
+	if err != nil {
+		return err
+	}
+	defer sourceCloser.Close()
+	defer closeStdin()
+
+	contents, err := getZipContents(sourceReader, config)
+	if err != nil {
+		return err
+	}
+	files, err := checkPaths(contents.files, config)
+	if err != nil {
+		return err
+	}
+	checkXattrs(files, config)
+	split.EmptyDirectories(files)
+
+	existing := make(map[string]bool)
+	for _, part := range m.Parts {
+		for _, entry := range part.Entries {
+			existing[entry.Name] = true
+		}
+	}
+
+	overhead := partOverhead(config)
+	if config.splitSize <= overhead {
+		return errors.New("Split size too small.")
+	}
+	capacity := config.splitSize - overhead
+
+	var items []pack.Item
+	for _, file := range files {
+		if existing[file.Name] && strings.HasSuffix(file.Name, "/") {
+			continue
+		}
+		if existing[file.Name] {
+			return fmt.Errorf("%s is already in the split set.", file.Name)
+		}
+
+		item := zipItem{file, entrySize(file, config)}
+		if item.Size() > capacity {
+			return withStatus(exitTooLarge, fmt.Errorf(
+				"Can never fit %s (%s).", file.Name,
+				numberToHuman(file.CompressedSize64)))
+		}
+		items = append(items, item)
+	}
+
+	// The parts as they are now, as bins holding their current size.
+	var bins []*pack.Bin
+	for _, part := range m.Parts {
+		info, err := os.Stat(part.Name)
+		if err != nil {
+			return err
+		}
+		size := uint64(info.Size())
+		bins = append(bins, &pack.Bin{Size: size - min(size, overhead)})
+	}
+
+	bins, err = pack.Fill(bins, items, capacity)
+	if err != nil {
+		return err
+	}
+
+	source := split.NewSource(sourceReader)
+
+	newZipName, err := split.Namer(config.nameTemplate,
+		nextPartNumber(m.Parts, config.nameTemplate))
+	if err != nil {
+		return err
+	}
+
+	existingParts := len(m.Parts)
+	for i, bin := range bins {
+		if len(bin.Items) == 0 {
+			continue
+		}
+
+		var added []*zip.File
+		var entries []manifest.Entry
+		for _, item := range bin.Items {
+			file := item.(zipItem).file
+			f, _ := source.Find(file)
+			added = append(added, f)
+			entry := manifestEntry(file)
+			entry.Digest = contents.digests[file]
+			entries = append(entries, entry)
+		}
+
+		if i < existingParts {
+			part := &m.Parts[i]
+			if config.verbose {
+				fmt.Fprintf(progress, "Adding %d entries to %s..", len(added), part.Name)
+			}
+			err := rewritePart(part.Name, nil, func(w *split.Writer) error {
+				for _, f := range added {
+					if err := split.CopyEntry(w, f, config.align); err != nil {
+						return err
+					}
+				}
+				return nil
+			}, config.splitSize, config)
+			if err != nil {
+				return err
+			}
+			if config.verbose {
+				fmt.Fprintln(progress, "done.")
+			}
+			part.Entries = append(part.Entries, entries...)
+		} else {
+			bucket := &Bucket{Part: split.Part{Name: newZipName()}}
+			if _, err := os.Lstat(bucket.Name); err == nil {
+				return fmt.Errorf("%s already exists, name the new parts "+
+					"with -out.", bucket.Name)
+			}
+			for _, item := range bin.Items {
+				bucket.Files = append(bucket.Files, item.(zipItem).file)
+			}
+			if err := bucket.makeZip(source, config); err != nil {
+				return err
+			}
+			m.Parts = append(m.Parts, manifest.Part{
+				Name:    bucket.Name,
+				Entries: entries})
+		}
+	}
+
+	m.Appended = append(m.Appended, config.sourceArchive)
+
+	return nil
+}
+
+// Return the number to give the first new part, after the highest one
+// of the parts named with the template. Parts deleted from the middle
+// of the set leave gaps, so counting them would reuse a name.
+func nextPartNumber(parts []manifest.Part, template string) int {
+	next := len(parts) + 1
+	for _, part := range parts {
+		var n int
+		if _, err := fmt.Sscanf(part.Name, template, &n); err != nil {
+			continue
+		}
+		if fmt.Sprintf(template, n) == part.Name {
+			next = max(next, n+1)
+		}
+	}
+
+	return next
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// Pack the items again into no more bins than they took, with as
+// little room per bin as that allows, so the parts come out about as
+// large as each other instead of all full but the last.
+func balanceBins(bins []*pack.Bin, items []pack.Item, capacity uint64,
+	strategy pack.Strategy, config Config) []*pack.Bin {
+
+	n := uint64(len(bins))
+	if n < 2 {
+		return bins
+	}
+
+	var total uint64
+	for _, bin := range bins {
+		total += bin.Size
+	}
+
+	// Sizes up to lo are known not to work, as some bin has to hold
+	// at least the average; hi is known to.
+	lo := (total+n-1)/n - 1
+	hi := capacity
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		packed, err := strategy(items, mid)
+		if err == nil && uint64(len(packed)) <= n {
+			bins, hi = packed, mid
+		} else {
+			lo = mid
+		}
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Balanced %d parts to at most %s of entries.\n",
+			len(bins), numberToHuman(hi))
+	}
+
+	return bins
+}
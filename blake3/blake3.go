@@ -0,0 +1,242 @@
+// Package blake3 implements the BLAKE3 hash with its default 256 bit
+// output, as described in the BLAKE3 paper.
+//
+// It follows the reference implementation, compressing one block at a
+// time without SIMD or threads.
+package blake3
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// The size of a BLAKE3 digest in bytes.
+const Size = 32
+
+// The block size of BLAKE3 in bytes.
+const BlockSize = 64
+
+const (
+	chunkLen = 1024
+
+	// Domain separation flags.
+	chunkStart = 1 << 0
+	chunkEnd   = 1 << 1
+	parent     = 1 << 2
+	root       = 1 << 3
+)
+
+var iv = [8]uint32{
+	0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a,
+	0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19}
+
+var permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func g(s *[16]uint32, a, b, c, d int, x, y uint32) {
+	s[a] += s[b] + x
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + y
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+// Compress a block into a chaining value, returning the whole state so
+// that the root can take all of it.
+func compress(cv *[8]uint32, block *[16]uint32, counter uint64,
+	blockLen, flags uint32) [16]uint32 {
+
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		iv[0], iv[1], iv[2], iv[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags}
+	m := *block
+
+	for round := range 7 {
+		g(&s, 0, 4, 8, 12, m[0], m[1])
+		g(&s, 1, 5, 9, 13, m[2], m[3])
+		g(&s, 2, 6, 10, 14, m[4], m[5])
+		g(&s, 3, 7, 11, 15, m[6], m[7])
+		g(&s, 0, 5, 10, 15, m[8], m[9])
+		g(&s, 1, 6, 11, 12, m[10], m[11])
+		g(&s, 2, 7, 8, 13, m[12], m[13])
+		g(&s, 3, 4, 9, 14, m[14], m[15])
+
+		if round < 6 {
+			var next [16]uint32
+			for i, j := range permutation {
+				next[i] = m[j]
+			}
+			m = next
+		}
+	}
+
+	for i := range 8 {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+
+	return s
+}
+
+func blockWords(block *[BlockSize]byte) [16]uint32 {
+	var words [16]uint32
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(block[4*i:])
+	}
+
+	return words
+}
+
+// The last compression of a node, kept apart so it can be done either
+// as the root or to get a chaining value.
+type output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *output) chainingValue() [8]uint32 {
+	s := compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+
+	return [8]uint32(s[:8])
+}
+
+func (o *output) rootDigest(b []byte) []byte {
+	s := compress(&o.cv, &o.block, 0, o.blockLen, o.flags|root)
+	for _, word := range s[:8] {
+		b = binary.LittleEndian.AppendUint32(b, word)
+	}
+
+	return b
+}
+
+func parentOutput(left, right [8]uint32) output {
+	o := output{cv: iv, blockLen: BlockSize, flags: parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+
+	return o
+}
+
+// The chunk being filled, of which the last block is only compressed
+// once it is known whether more follows.
+type chunkState struct {
+	cv         [8]uint32
+	counter    uint64
+	block      [BlockSize]byte
+	blockLen   int
+	compressed int
+}
+
+func newChunkState(counter uint64) chunkState {
+	return chunkState{cv: iv, counter: counter}
+}
+
+func (c *chunkState) len() int {
+	return c.compressed*BlockSize + c.blockLen
+}
+
+func (c *chunkState) startFlag() uint32 {
+	if c.compressed == 0 {
+		return chunkStart
+	}
+
+	return 0
+}
+
+func (c *chunkState) update(p []byte) {
+	for len(p) > 0 {
+		if c.blockLen == BlockSize {
+			words := blockWords(&c.block)
+			s := compress(&c.cv, &words, c.counter, BlockSize, c.startFlag())
+			c.cv = [8]uint32(s[:8])
+			c.compressed++
+			c.block = [BlockSize]byte{}
+			c.blockLen = 0
+		}
+
+		n := copy(c.block[c.blockLen:], p)
+		c.blockLen += n
+		p = p[n:]
+	}
+}
+
+func (c *chunkState) output() output {
+	return output{
+		cv:       c.cv,
+		block:    blockWords(&c.block),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | chunkEnd}
+}
+
+type digest struct {
+	chunk chunkState
+
+	// The chaining values of the complete subtrees to the left of the
+	// chunk, which merge whenever one of the same size is added.
+	stack [][8]uint32
+}
+
+// New returns a hash.Hash computing the BLAKE3 digest.
+func New() hash.Hash {
+	return &digest{chunk: newChunkState(0)}
+}
+
+func (d *digest) Size() int      { return Size }
+func (d *digest) BlockSize() int { return BlockSize }
+
+func (d *digest) Reset() {
+	d.chunk = newChunkState(0)
+	d.stack = d.stack[:0]
+}
+
+// Push the chaining value of a finished chunk, merging it with the
+// subtrees it completes, which is one for every trailing zero bit in
+// the number of chunks so far.
+func (d *digest) addChunk(cv [8]uint32, total uint64) {
+	for ; total&1 == 0; total >>= 1 {
+		top := d.stack[len(d.stack)-1]
+		d.stack = d.stack[:len(d.stack)-1]
+		o := parentOutput(top, cv)
+		cv = o.chainingValue()
+	}
+	d.stack = append(d.stack, cv)
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		// A full chunk is only finished when more follows, as the
+		// last one may be the root.
+		if d.chunk.len() == chunkLen {
+			o := d.chunk.output()
+			total := d.chunk.counter + 1
+			d.addChunk(o.chainingValue(), total)
+			d.chunk = newChunkState(total)
+		}
+
+		n := min(len(p), chunkLen-d.chunk.len())
+		d.chunk.update(p[:n])
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Sum appends the digest of what was written so far to b, without
+// changing the state.
+func (d *digest) Sum(b []byte) []byte {
+	o := d.chunk.output()
+	for i := len(d.stack) - 1; i >= 0; i-- {
+		o = parentOutput(d.stack[i], o.chainingValue())
+	}
+
+	return o.rootDigest(b)
+}
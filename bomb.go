@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"sort"
+)
+
+// Entries smaller than this are not worth warning about, whatever
+// their compression ratio.
+const bombMinSize = MByte
+
+// Look for signs of a zip bomb: entries with absurd compression
+// ratios, entries sharing their data and a total uncompressed size
+// far beyond the size of the archive. Findings are warnings unless
+// asked to abort on them.
+func checkBomb(r *zip.Reader, config Config) error {
+	var findings []string
+
+	type span struct {
+		name       string
+		start, end int64
+	}
+	var spans []span
+	var total uint64
+
+	for _, f := range r.File {
+		total += f.UncompressedSize64
+
+		if f.UncompressedSize64 >= bombMinSize && config.bombRatio > 0 &&
+			f.UncompressedSize64/max(f.CompressedSize64, 1) > config.bombRatio {
+			findings = append(findings, fmt.Sprintf(
+				"%s expands from %s to %s.", f.Name,
+				numberToHuman(f.CompressedSize64),
+				numberToHuman(f.UncompressedSize64)))
+		}
+
+		offset, err := f.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		spans = append(spans, span{
+			f.Name,
+			offset,
+			offset + int64(f.CompressedSize64)})
+	}
+
+	// Entries pointing into each others data are how the worst
+	// bombs get their size.
+	var size int64
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+	for i, s := range spans {
+		if i > 0 && s.start < spans[i-1].end {
+			findings = append(findings, fmt.Sprintf(
+				"%s overlaps %s.", s.name, spans[i-1].name))
+		}
+		size = max(size, s.end)
+	}
+
+	if config.maxExpansion > 0 && size > 0 &&
+		total/uint64(size) > config.maxExpansion {
+		findings = append(findings, fmt.Sprintf(
+			"Entries expand from %s to %s.",
+			numberToHuman(uint64(size)), numberToHuman(total)))
+	}
+
+	if len(findings) == 0 {
+		return nil
+	}
+	if config.abortOnBomb {
+		return fmt.Errorf("Possible zip bomb: %s", findings[0])
+	}
+	for _, finding := range findings {
+		warnings.Printf("Possible zip bomb: %s", finding)
+	}
+
+	return nil
+}
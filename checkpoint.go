@@ -0,0 +1,205 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/ascheepe/zipsplit/sink"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// How often to save where a streaming split got to.
+const checkpointInterval = 10 * time.Second
+
+// Where a streaming split got to, saved now and then and whenever a
+// part is finished or started, so an interrupted run can pick up in the
+// middle of the part it was writing.
+type checkpoint struct {
+	Source       string `json:"source"`
+	SplitSize    uint64 `json:"split_size"`
+	Measure      string `json:"measure,omitempty"`
+	NameTemplate string `json:"name_template"`
+
+	// Entries of the source done with, parts started, and where in
+	// the source and how full the last of those is.
+	Entries   int    `json:"entries"`
+	Parts     int    `json:"parts"`
+	PartStart int    `json:"part_start"`
+	PartSize  uint64 `json:"part_size"`
+
+	saved time.Time
+}
+
+// Load the checkpoint of an earlier run of the same split, or start a
+// new one.
+func loadCheckpoint(config Config) (*checkpoint, error) {
+	cp := &checkpoint{
+		Source:       config.sourceArchive,
+		SplitSize:    config.splitSize,
+		Measure:      config.measure,
+		NameTemplate: config.nameTemplate,
+		saved:        time.Now()}
+	if config.checkpoint == "" {
+		return cp, nil
+	}
+
+	data, err := os.ReadFile(config.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	saved := *cp
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %v", config.checkpoint, err)
+	}
+	if saved.Source != cp.Source || saved.SplitSize != cp.SplitSize ||
+		saved.Measure != cp.Measure ||
+		saved.NameTemplate != cp.NameTemplate {
+		return nil, fmt.Errorf("%s: checkpoint of another split.",
+			config.checkpoint)
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Resuming in part %d after %d entries.\n",
+			saved.Parts, saved.Entries)
+	}
+
+	return &saved, nil
+}
+
+// Save the checkpoint when it is time to, making sure what it records
+// has been written to the part first.
+func (cp *checkpoint) saveEvery(part *streamPart, config Config) error {
+	if config.checkpoint == "" ||
+		time.Since(cp.saved) < checkpointInterval {
+		return nil
+	}
+
+	if err := part.w.Flush(); err != nil {
+		return err
+	}
+	if err := syncWriter(part.dst); err != nil {
+		return err
+	}
+
+	return cp.save(config)
+}
+
+// Save the checkpoint now.
+func (cp *checkpoint) save(config Config) error {
+	if config.checkpoint == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	// Replace the old checkpoint in one go, so a crash while saving
+	// leaves either of them.
+	tmp := config.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, config.checkpoint); err != nil {
+		return err
+	}
+
+	cp.saved = time.Now()
+	return nil
+}
+
+// Remove the checkpoint of a finished split.
+func (cp *checkpoint) remove(config Config) error {
+	if config.checkpoint == "" {
+		return nil
+	}
+
+	err := os.Remove(config.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Get written data onto the disk, when the writer is a file.
+func syncWriter(w io.Writer) error {
+	if ph, ok := w.(*partHasher); ok {
+		w = ph.WriteCloser
+	}
+	if f, ok := w.(interface{ Sync() error }); ok {
+		return f.Sync()
+	}
+
+	return nil
+}
+
+// Start writing an interrupted part again, copying the entries it
+// already holds, with their headers from the source, from what was
+// written of it. Anything after the last of those is dropped. A part
+// which was finished before the checkpoint said so is left as it is,
+// returning no part to write to.
+func resumePart(name string, files []*zip.FileHeader,
+	config Config) (*streamPart, error) {
+
+	path, err := partPath(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	// The part was interrupted before it got its name.
+	partial := path + ".partial"
+	err = os.Rename(path+sink.TempSuffix, partial)
+	if errors.Is(err, os.ErrNotExist) {
+		if _, statErr := os.Stat(path); statErr == nil {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(partial)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	part, err := newStreamPart(name, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var offset int64
+	for _, file := range files {
+		fh, dataOffset, end, err := readLocalEntry(f, info.Size(), offset)
+		if err != nil || fh.Name != file.Name {
+			part.dst.Close()
+			return nil, fmt.Errorf("%s: cannot resume, %s is damaged.",
+				name, file.Name)
+		}
+
+		data := io.NewSectionReader(f, dataOffset, int64(fh.CompressedSize64))
+		if err := split.WriteEntry(part.w, file, data, config.align); err != nil {
+			part.dst.Close()
+			return nil, err
+		}
+		offset = end
+	}
+
+	return part, os.Remove(partial)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MD5 is only offered for the checksums of the parts, which tools like
+// md5sum check, not for the entries in the manifest.
+const checksumMD5 = "md5"
+
+// Return a new hash of the named algorithm for checksums of the parts.
+func newChecksum(name string) (hash.Hash, error) {
+	if name == checksumMD5 {
+		return md5.New(), nil
+	}
+
+	h, err := newHash(name)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid checksum %q.", name)
+	}
+
+	return h, nil
+}
+
+// Write a SHA256SUMS style file next to the parts, named after the
+// algorithm, which sha256sum -c and friends check the parts against.
+// With perPart every part also gets one of its own, like
+// out-001.zip.sha256.
+func makeChecksums(buckets []*Bucket, config Config) error {
+	var sums strings.Builder
+	var dir string
+
+	for _, bucket := range buckets {
+		name, err := partPath(bucket.Name, config)
+		if err != nil {
+			return err
+		}
+		h, err := newChecksum(config.checksum)
+		if err != nil {
+			return err
+		}
+		sum, err := hashFile(name, h)
+		if err != nil {
+			return err
+		}
+
+		line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(name))
+		sums.WriteString(line)
+		dir = filepath.Dir(name)
+
+		if config.checksumFiles {
+			err := os.WriteFile(name+"."+config.checksum, []byte(line), 0644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	sumsName := filepath.Join(dir, strings.ToUpper(config.checksum)+"SUMS")
+
+	return os.WriteFile(sumsName, []byte(sums.String()), 0644)
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Chunks of entries too large for a part are named after the entry
+// with this and their number appended.
+const chunkSuffix = ".part"
+
+// The most digits a chunk number, or a chunk count, takes up.
+const maxChunkDigits = 10
+
+// A piece of a large entry as seen by the packer.
+type chunkItem struct {
+	zipItem
+	chunk split.Chunk
+}
+
+// Describe a chunk in its entry comment, with what it takes to put
+// the original entry back together.
+func chunkComment(file *zip.FileHeader, n, count int) string {
+	return fmt.Sprintf("zipsplit-chunk %d/%d method %d crc32 %08x size %d",
+		n, count, file.Method, file.CRC32, file.UncompressedSize64)
+}
+
+// What a chunk comment says about the original entry.
+type chunkInfo struct {
+	n, count int
+	method   uint16
+	crc32    uint32
+	size     uint64
+}
+
+func parseChunkComment(comment string) (chunkInfo, bool) {
+	var info chunkInfo
+	_, err := fmt.Sscanf(comment,
+		"zipsplit-chunk %d/%d method %d crc32 %x size %d",
+		&info.n, &info.count, &info.method, &info.crc32, &info.size)
+
+	return info, err == nil && info.n >= 1 && info.n <= info.count
+}
+
+// Cut an entry too large for any part into chunks which fill a part
+// each, but for the last.
+func chunkItems(file *zip.FileHeader, capacity uint64,
+	config Config) ([]pack.Item, error) {
+
+	// Leave room for the longest name and comment a chunk can get,
+	// and for the data descriptor the zip.Writer adds.
+	digits := strings.Repeat("9", maxChunkDigits)
+	probe := &zip.FileHeader{
+		Name:    file.Name + chunkSuffix + digits,
+		Comment: chunkComment(file, 1, 1) + digits + digits}
+	overhead := entrySize(probe, config) + dataDescriptor64Len +
+		parentDirsSize(file, config)
+	if overhead >= capacity {
+		return nil, withStatus(exitTooLarge,
+			fmt.Errorf("Can never fit a chunk of %s.", file.Name))
+	}
+	length := capacity - overhead
+
+	count := int((file.CompressedSize64 + length - 1) / length)
+
+	var items []pack.Item
+	for i := range count {
+		offset := uint64(i) * length
+		chunk := split.Chunk{
+			Entry:  file.Name,
+			Offset: int64(offset),
+			Length: int64(min(length, file.CompressedSize64-offset)),
+			Header: file}
+
+		fh := &zip.FileHeader{
+			Name:           fmt.Sprintf("%s%s%d", file.Name, chunkSuffix, i+1),
+			Comment:        chunkComment(file, i+1, count),
+			Method:         zip.Store,
+			Modified:       file.Modified,
+			ExternalAttrs:  file.ExternalAttrs,
+			CreatorVersion: file.CreatorVersion,
+
+			CompressedSize64:   uint64(chunk.Length),
+			UncompressedSize64: uint64(chunk.Length)}
+
+		items = append(items, chunkItem{zipItem{fh, entrySize(fh, config) +
+			dataDescriptor64Len + parentDirsSize(fh, config)}, chunk})
+	}
+
+	return items, nil
+}
+
+// Put the entries cut into chunks back together from the parts.
+func joinCommand(args []string) {
+	flags := flag.NewFlagSet("join", flag.ExitOnError)
+
+	output := flags.String(
+		"o",
+		"",
+		"Write the entries put back together to this zip.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Show some information about the process.")
+
+	flags.Parse(args)
+
+	if *output == "" || flags.NArg() == 0 {
+		fatal(errors.New("Usage: zipsplit join -o joined.zip part..."))
+	}
+
+	if err := joinChunks(*output, flags.Args(), *verbose); err != nil {
+		fatal(err)
+	}
+}
+
+// A chunk found in a part.
+type foundChunk struct {
+	info chunkInfo
+	file *zip.File
+}
+
+// Return the entry a chunk belongs to, reporting false for entries
+// which are not chunks.
+func chunkOf(f *zip.File) (string, foundChunk, bool) {
+	info, ok := parseChunkComment(f.Comment)
+	i := strings.LastIndex(f.Name, chunkSuffix)
+	if !ok || i < 0 {
+		return "", foundChunk{}, false
+	}
+
+	return f.Name[:i], foundChunk{info, f}, true
+}
+
+// Collect the chunks in the parts by the entry they belong to.
+func findChunks(parts []*zip.ReadCloser) map[string][]foundChunk {
+	chunks := make(map[string][]foundChunk)
+
+	for _, part := range parts {
+		for _, f := range part.File {
+			if name, chunk, ok := chunkOf(f); ok {
+				chunks[name] = append(chunks[name], chunk)
+			}
+		}
+	}
+
+	return chunks
+}
+
+// Write the entries whose chunks are in the parts to a new zip.
+func joinChunks(output string, names []string, verbose bool) error {
+	var parts []*zip.ReadCloser
+	defer func() {
+		for _, part := range parts {
+			part.Close()
+		}
+	}()
+	for _, name := range names {
+		part, err := zip.OpenReader(name)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	chunks := findChunks(parts)
+	if len(chunks) == 0 {
+		return errors.New("No chunks found in the parts.")
+	}
+
+	var entries []string
+	for name := range chunks {
+		entries = append(entries, name)
+	}
+	sort.Strings(entries)
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	w := zip.NewWriter(f)
+
+	for _, name := range entries {
+		if verbose {
+			fmt.Fprintf(progress, "Joining %s..", name)
+		}
+		if err := joinEntry(w, name, chunks[name]); err != nil {
+			f.Close()
+			return err
+		}
+		if verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return checkJoined(output)
+}
+
+// Read back the joined entries, which checks their CRCs.
+func checkJoined(name string) error {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if _, err := checkEntry(f, ""); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Write an entry from all of its chunks.
+func joinEntry(w *zip.Writer, name string, chunks []foundChunk) error {
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].info.n < chunks[j].info.n
+	})
+
+	first := chunks[0]
+	for i, chunk := range chunks {
+		if chunk.info.n != i+1 || chunk.info.count != first.info.count ||
+			chunk.info.crc32 != first.info.crc32 {
+			return fmt.Errorf("%s: chunk %d is missing or doesn't belong.",
+				name, i+1)
+		}
+	}
+	if len(chunks) != first.info.count {
+		return fmt.Errorf("%s: has %d of %d chunks.", name, len(chunks),
+			first.info.count)
+	}
+
+	fh := &zip.FileHeader{
+		Name:               name,
+		Method:             first.info.method,
+		CRC32:              first.info.crc32,
+		UncompressedSize64: first.info.size,
+		Modified:           first.file.Modified,
+		ModifiedTime:       first.file.ModifiedTime,
+		ModifiedDate:       first.file.ModifiedDate,
+		ExternalAttrs:      first.file.ExternalAttrs,
+		CreatorVersion:     first.file.CreatorVersion,
+		ReaderVersion:      20}
+
+	var readers []io.Reader
+	for _, chunk := range chunks {
+		// Opening a stored chunk checks its own CRC on the way.
+		r, err := chunk.file.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		readers = append(readers, r)
+		fh.CompressedSize64 += chunk.file.UncompressedSize64
+	}
+
+	dst, err := w.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, io.MultiReader(readers...))
+
+	return err
+}
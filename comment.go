@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+)
+
+// The variables of a part comment template besides those of a name
+// template; {part} is {num} by another name.
+const commentPart = "part"
+
+// Check the variables of a part comment template.
+func checkCommentTemplate(template string) error {
+	for _, match := range nameVariable.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case nameNum, commentPart, nameTotal, nameSource, nameDate:
+		default:
+			return fmt.Errorf("Unknown variable %s in the comment.",
+				match[0])
+		}
+	}
+
+	return nil
+}
+
+// Fill in the variables of a part comment template, where {num} and
+// {total} can have a width like in a name template.
+func renderComment(template string, part, total int, config Config) string {
+	return nameVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		match := nameVariable.FindStringSubmatch(variable)
+		width, _ := strconv.Atoi(match[2])
+
+		switch match[1] {
+		case nameNum, commentPart:
+			return fmt.Sprintf("%0*d", width, part)
+		case nameTotal:
+			return fmt.Sprintf("%0*d", width, total)
+		case nameSource:
+			return path.Base(config.sourceArchive)
+		case nameDate:
+			return config.entryTime.Format(time.DateOnly)
+		}
+
+		return variable
+	})
+}
+
+// Return the part comment, after the comment of the source when it is
+// appended to that.
+func partComment(part, total int, config Config) string {
+	comment := renderComment(config.comment, part, total, config)
+	if config.appendComment && config.sourceComment != "" {
+		return config.sourceComment + "\n" + comment
+	}
+
+	return comment
+}
+
+// Return the most room the comment can take up in any part, before
+// the number of parts is known.
+func maxCommentSize(config Config) uint64 {
+	var size uint64
+	if config.sealKey != nil {
+		size += uint64(maxSealSize)
+	}
+	if config.comment == "" {
+		return size + uint64(len(config.sourceComment))
+	}
+
+	// Part numbers won't get anywhere near this long.
+	const widest = 99999999
+	return size + uint64(len(partComment(widest, widest, config)))
+}
+
+// Set the comment of every part, keeping the one of the source when
+// none is given.
+func setComments(buckets []*Bucket, config Config) {
+	if config.comment == "" {
+		for _, bucket := range buckets {
+			bucket.Comment = config.sourceComment
+		}
+		return
+	}
+
+	for i, bucket := range buckets {
+		bucket.Comment = partComment(i+1, len(buckets), config)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"archive/zip"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// Entries which have to end up in the same part, in this order.
+type zipGroup struct {
+	items []zipItem
+
+	// Whether the entries have to start the first part.
+	leading bool
+}
+
+func (group *zipGroup) Size() uint64 {
+	var size uint64
+	for _, item := range group.items {
+		size += item.Size()
+	}
+
+	return size
+}
+
+// Container formats like EPUB and OpenDocument require a stored
+// mimetype entry at the very start of the archive, so readers can
+// identify them from the first bytes.
+func isLeadingEntry(file *zip.FileHeader) bool {
+	return file.Name == "mimetype" && file.Method == zip.Store
+}
+
+// Java archives, which tools read from the start expecting the
+// manifest and the signature files first.
+func isJarArchive(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jar", ".war", ".ear":
+		return true
+	}
+
+	return false
+}
+
+// Report whether an entry is part of the signature of a signed jar.
+func isSignatureFile(name string) bool {
+	dir, base := path.Split(name)
+	if !strings.EqualFold(dir, "META-INF/") {
+		return false
+	}
+
+	switch strings.ToUpper(path.Ext(base)) {
+	case ".SF", ".RSA", ".DSA", ".EC":
+		return true
+	}
+
+	return strings.HasPrefix(strings.ToUpper(base), "SIG-")
+}
+
+// Return the place of an entry among the entries which have to lead
+// the first part, or -1 when it can go anywhere.
+func leadingRank(file *zip.FileHeader, config Config) int {
+	switch {
+	case isLeadingEntry(file):
+		return 0
+	case !isJarArchive(config.sourceArchive):
+		return -1
+	case strings.EqualFold(file.Name, "META-INF/"):
+		return 1
+	case strings.EqualFold(file.Name, "META-INF/MANIFEST.MF"):
+		return 2
+	case isSignatureFile(file.Name) &&
+		strings.EqualFold(path.Ext(file.Name), ".SF"):
+		return 3
+	case isSignatureFile(file.Name):
+		return 4
+	}
+
+	return -1
+}
+
+// Put the leading entries in the order they are expected in.
+func sortLeading(group *zipGroup, config Config) {
+	sort.SliceStable(group.items, func(i, j int) bool {
+		return leadingRank(group.items[i].file, config) <
+			leadingRank(group.items[j].file, config)
+	})
+}
+
+// Warn when a signed jar is split, as only the first part carries the
+// signature and the entries in the other parts end up unsigned.
+func checkSignedJar(files []*zip.FileHeader, buckets []*Bucket,
+	config Config) {
+
+	if !isJarArchive(config.sourceArchive) || len(buckets) < 2 {
+		return
+	}
+
+	for _, file := range files {
+		if isSignatureFile(file.Name) {
+			warnings.Printf("%s is signed, the entries outside part 1 "+
+				"will not be covered by its signature.",
+				path.Base(config.sourceArchive))
+			return
+		}
+	}
+}
+
+// Move the leading group to the front of its part, and that part to
+// the front of the split set.
+func moveLeadingFirst(bins []*pack.Bin) {
+	for i, bin := range bins {
+		for j, item := range bin.Items {
+			group, ok := item.(*zipGroup)
+			if !ok || !group.leading {
+				continue
+			}
+
+			copy(bin.Items[1:j+1], bin.Items[:j])
+			bin.Items[0] = group
+			copy(bins[1:i+1], bins[:i])
+			bins[0] = bin
+			return
+		}
+	}
+}
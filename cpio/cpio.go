@@ -0,0 +1,150 @@
+// Package cpio writes archives in the SVR4 "newc" cpio format, as used
+// for Linux initramfs images.
+package cpio
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// File type bits of Header.Mode.
+const (
+	ModeDir     = 0040000
+	ModeRegular = 0100000
+	ModeSymlink = 0120000
+)
+
+const (
+	headerLen = 110
+	trailer   = "TRAILER!!!"
+)
+
+var (
+	ErrWriteTooLong = errors.New("cpio: write too long")
+	ErrShortWrite   = errors.New("cpio: entry shorter than its header says")
+	ErrTooLarge     = errors.New("cpio: entry larger than newc can hold")
+)
+
+// MaxSize is the largest entry newc can hold, its size field having 32
+// bits.
+const MaxSize = math.MaxUint32
+
+// Header describes an entry of an archive. Mode holds the file type
+// bits as well as the permissions.
+type Header struct {
+	Name    string
+	Mode    uint32
+	UID     uint32
+	GID     uint32
+	ModTime time.Time
+	Size    int64
+}
+
+// Writer writes an archive entry by entry: WriteHeader starts one and
+// Write fills in its data.
+type Writer struct {
+	w         io.Writer
+	ino       uint32
+	remaining int64
+	padding   int64
+}
+
+// NewWriter returns a Writer writing an archive to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Round n up to the four byte boundary newc aligns everything to.
+func align(n int64) int64 {
+	return (n + 3) &^ 3
+}
+
+// Return how many bytes an entry with the given name and data size
+// takes up in an archive.
+func EntrySize(name string, size int64) int64 {
+	return align(headerLen+int64(len(name))+1) + align(size)
+}
+
+// The size of the trailer which ends every archive.
+var TrailerSize = EntrySize(trailer, 0)
+
+// Start a new entry, after which Write fills in its data. Entries
+// larger than MaxSize are refused with ErrTooLarge.
+func (cw *Writer) WriteHeader(hdr *Header) error {
+	if hdr.Size < 0 || hdr.Size > MaxSize {
+		return ErrTooLarge
+	}
+	if err := cw.finish(); err != nil {
+		return err
+	}
+
+	cw.ino++
+	nlink := 1
+	if hdr.Mode&0170000 == ModeDir {
+		nlink = 2
+	}
+
+	header := fmt.Sprintf("070701%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+		cw.ino,
+		hdr.Mode,
+		hdr.UID,
+		hdr.GID,
+		nlink,
+		uint32(hdr.ModTime.Unix()),
+		uint32(hdr.Size),
+		0, 0, 0, 0,
+		len(hdr.Name)+1,
+		0)
+
+	name := make([]byte, align(headerLen+int64(len(hdr.Name))+1)-headerLen)
+	copy(name, hdr.Name)
+
+	if _, err := io.WriteString(cw.w, header); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(name); err != nil {
+		return err
+	}
+
+	cw.remaining = hdr.Size
+	cw.padding = align(hdr.Size) - hdr.Size
+
+	return nil
+}
+
+// Write data of the current entry, no more than its header says.
+func (cw *Writer) Write(p []byte) (int, error) {
+	if int64(len(p)) > cw.remaining {
+		return 0, ErrWriteTooLong
+	}
+
+	n, err := cw.w.Write(p)
+	cw.remaining -= int64(n)
+
+	return n, err
+}
+
+// Pad out the data of the current entry.
+func (cw *Writer) finish() error {
+	if cw.remaining > 0 {
+		return ErrShortWrite
+	}
+
+	var zeros [3]byte
+	_, err := cw.w.Write(zeros[:cw.padding])
+	cw.padding = 0
+
+	return err
+}
+
+// Write the trailer, the underlying writer is not closed.
+func (cw *Writer) Close() error {
+	if err := cw.WriteHeader(&Header{Name: trailer}); err != nil {
+		return err
+	}
+
+	return cw.finish()
+}
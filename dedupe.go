@@ -0,0 +1,53 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+)
+
+// Drop the entries whose name another entry has, as the policy says,
+// keeping the order of the rest. Entries sharing a name are copied
+// apart by their headers, but only one of them ends up extracted.
+func dedupeEntries(files []*zip.File, config Config) []*zip.File {
+	counts := make(map[string]int)
+	for _, f := range files {
+		counts[f.Name]++
+	}
+
+	seen := make(map[string]int)
+	var kept []*zip.File
+	for _, f := range files {
+		n := counts[f.Name]
+		if n == 1 {
+			kept = append(kept, f)
+			continue
+		}
+		seen[f.Name]++
+
+		switch config.dedupe {
+		case dedupeKeepFirst:
+			if seen[f.Name] > 1 {
+				dropDuplicate(f, config)
+				continue
+			}
+		case dedupeKeepLast:
+			if seen[f.Name] < n {
+				dropDuplicate(f, config)
+				continue
+			}
+		default:
+			if seen[f.Name] == 1 {
+				warnings.Printf("%s is in the source %d times.", f.Name, n)
+			}
+		}
+		kept = append(kept, f)
+	}
+
+	return kept
+}
+
+func dropDuplicate(f *zip.File, config Config) {
+	if config.verbose {
+		fmt.Fprintf(progress, "Leaving out an entry also named %s.\n", f.Name)
+	}
+}
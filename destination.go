@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// A directory parts are written to and how much room it has.
+type destination struct {
+	dir      string
+	capacity uint64
+}
+
+// Parse a comma separated list of dir=size destinations.
+func parseDestinations(s string) ([]destination, error) {
+	var destinations []destination
+
+	for _, field := range strings.Split(s, ",") {
+		dir, size, ok := strings.Cut(field, "=")
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("Invalid destination %q.", field)
+		}
+
+		capacity, err := humanToNumber(size)
+		if err != nil || capacity == 0 {
+			return nil, fmt.Errorf("Invalid destination size %q.", size)
+		}
+		destinations = append(destinations, destination{dir, capacity})
+	}
+
+	return destinations, nil
+}
+
+// Fit the files into parts filling each destination in turn. Every
+// destination gets as many parts of the split size as it can hold,
+// followed by a smaller part using up what is left.
+func fitDestinations(files []*zip.FileHeader, destinations []destination,
+	config Config) ([]*Bucket, error) {
+
+	newZipName, err := split.Namer(config.nameTemplate, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := partOverhead(config)
+	if config.splitSize <= overhead {
+		return nil, errors.New("Split size too small.")
+	}
+
+	var capacities []uint64
+	var dirs []string
+	for _, dest := range destinations {
+		// Parts take up whole clusters.
+		room := roundDown(dest.capacity, config.clusterSize)
+		for room > overhead {
+			size := min(room, config.splitSize)
+			capacities = append(capacities, size-overhead)
+			dirs = append(dirs, dest.dir)
+			room -= size
+		}
+	}
+
+	items, leading, err := packItems(files, config.splitSize-overhead, config)
+	if err != nil {
+		return nil, err
+	}
+	items = pack.Decreasing(items)
+	if leading != nil {
+		items = append([]pack.Item{leading}, items...)
+	}
+
+	bins, err := pack.FirstFitSized(items, capacities)
+	if err != nil {
+		return nil, errors.New("The destinations are too small.")
+	}
+
+	var buckets []*Bucket
+	for i, bin := range bins {
+		if len(bin.Items) == 0 {
+			continue
+		}
+
+		bucket := binsToBuckets([]*pack.Bin{bin}, newZipName)[0]
+		bucket.Name = filepath.Join(dirs[i], bucket.Name)
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"bytes"
+	"io"
+)
+
+// Magic bytes of the archive formats we may be handed by mistake.
+var archiveMagic = []struct {
+	format string
+	offset int
+	magic  []byte
+}{
+	{"zip", 0, []byte("PK\x03\x04")},
+	{"zip", 0, []byte("PK\x05\x06")},
+	{"gzip", 0, []byte("\x1f\x8b")},
+	{"bzip2", 0, []byte("BZh")},
+	{"xz", 0, []byte("\xfd7zXZ\x00")},
+	{"zstd", 0, []byte("\x28\xb5\x2f\xfd")},
+	{"7z", 0, []byte("7z\xbc\xaf\x27\x1c")},
+	{"rar", 0, []byte("Rar!\x1a\x07")},
+	{"tar", 257, []byte("ustar")},
+}
+
+// Return the archive format the data starts like, or "" when it is
+// not recognized.
+func detectFormat(r io.ReaderAt) string {
+	head := make([]byte, 512)
+	n, _ := r.ReadAt(head, 0)
+	head = head[:n]
+
+	for _, m := range archiveMagic {
+		if len(head) >= m.offset+len(m.magic) &&
+			bytes.Equal(head[m.offset:m.offset+len(m.magic)], m.magic) {
+			return m.format
+		}
+	}
+
+	return ""
+}
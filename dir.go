@@ -0,0 +1,273 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Report whether the source is a local directory to split directly.
+func isSourceDir(name string) bool {
+	if isRemote(name) {
+		return false
+	}
+	info, err := os.Stat(name)
+
+	return err == nil && info.IsDir()
+}
+
+// The files of a directory being split, by their headers.
+type dirSource map[*zip.FileHeader]string
+
+// Walk a directory and make headers for everything in it, compressing
+// the files to nowhere to learn their sizes and CRCs. Compressing is
+// repeatable, so writing the parts later gives the same data without
+// keeping it around in between.
+func scanDir(config Config) (*sourceContents, dirSource, error) {
+	root := config.sourceArchive
+	contents := &sourceContents{
+		digests: make(map[*zip.FileHeader]string)}
+	paths := make(dirSource)
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Compressing %s to measure it..", root)
+	}
+
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry,
+		err error) error {
+
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !isSelected(filepath.ToSlash(rel), config) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			switch config.symlinks {
+			case symlinksSkip:
+				return nil
+			case symlinksFollow:
+				// Linked directories are left out, rather than
+				// walked into and maybe around in circles.
+				info, err = os.Stat(name)
+				if err != nil || !info.Mode().IsRegular() {
+					warnings.Printf("Leaving out %s, it does not link "+
+						"to a file.", name)
+					return nil
+				}
+			}
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() &&
+			info.Mode()&fs.ModeSymlink == 0 {
+			warnings.Printf("Leaving out %s, it is not a file, directory "+
+				"or symbolic link.", name)
+			return nil
+		}
+
+		fh, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		fh.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			fh.Name += "/"
+		}
+
+		digest, err := measureFile(fh, name, config.hash)
+		if err != nil {
+			return err
+		}
+
+		contents.files = append(contents.files, fh)
+		paths[fh] = name
+		if digest != "" {
+			contents.digests[fh] = digest
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if config.verbose {
+		fmt.Fprintln(progress, "done.")
+	}
+
+	return contents, paths, nil
+}
+
+// Open the data of an entry as it is stored: the target of a link, or
+// the contents of a file.
+func openDirEntry(fh *zip.FileHeader, name string) (io.ReadCloser, error) {
+	if strings.HasSuffix(fh.Name, "/") {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+	if fh.Mode()&fs.ModeSymlink != 0 {
+		// Like zip tools, store the target as the data of the link.
+		target, err := os.Readlink(name)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(strings.NewReader(target)), nil
+	}
+
+	return os.Open(name)
+}
+
+// Fill in the sizes and CRC of an entry, and pick whether to compress
+// it, returning its digest when asked for.
+func measureFile(fh *zip.FileHeader, name string,
+	hashName string) (string, error) {
+
+	r, err := openDirEntry(fh, name)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	sum := crc32.NewIEEE()
+	hashes := io.Writer(sum)
+
+	var digest hash.Hash
+	if hashName != "" {
+		if digest, err = newHash(hashName); err != nil {
+			return "", err
+		}
+		hashes = io.MultiWriter(sum, digest)
+	}
+
+	compressed := &countWriter{w: io.Discard}
+	fw, err := flate.NewWriter(compressed, flate.DefaultCompression)
+	if err != nil {
+		return "", err
+	}
+	size, err := io.Copy(io.MultiWriter(fw, hashes), r)
+	if err != nil {
+		return "", err
+	}
+	if err := fw.Close(); err != nil {
+		return "", err
+	}
+
+	fh.CRC32 = sum.Sum32()
+	fh.UncompressedSize64 = uint64(size)
+	fh.Method = zip.Deflate
+	fh.CompressedSize64 = uint64(compressed.count)
+	if fh.CompressedSize64 >= fh.UncompressedSize64 {
+		fh.Method = zip.Store
+		fh.CompressedSize64 = fh.UncompressedSize64
+	}
+	fh.Flags &^= hasDataDescriptorFlag
+
+	// Raw entries are written with the versions as given; say what
+	// the zip.Writer would for these.
+	fh.CreatorVersion = fh.CreatorVersion&0xff00 | 20
+	fh.ReaderVersion = 20
+
+	if digest == nil {
+		return "", nil
+	}
+
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// Counts what is written, to learn the compressed size of a file.
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
+
+// Compress a file again while reading it, the way it was measured.
+func compressDirEntry(fh *zip.FileHeader, name string) (io.ReadCloser,
+	error) {
+
+	r, err := openDirEntry(fh, name)
+	if err != nil {
+		return nil, err
+	}
+	if fh.Method == zip.Store {
+		return r, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+		fw, err := flate.NewWriter(pw, flate.DefaultCompression)
+		if err == nil {
+			_, err = io.Copy(fw, r)
+		}
+		if err == nil {
+			err = fw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return pr, nil
+}
+
+// Write the entries of the bucket from the directory, checking that
+// they did not change since they were measured.
+func (bucket *Bucket) copyFilesDir(paths dirSource, dst io.Writer,
+	config Config) error {
+
+	w := split.NewWriter(dst)
+	w.ForceZip64 = config.forceZip64
+	w.Password = config.outPassword
+	if bucket.Comment != "" {
+		if err := w.SetComment(bucket.Comment); err != nil {
+			return err
+		}
+	}
+
+	for _, fh := range bucket.Files {
+		name := paths[fh]
+		r, err := compressDirEntry(fh, name)
+		if err != nil {
+			return err
+		}
+
+		counted := &countReader{r: r}
+		err = split.WriteEntry(w, fh, counted, config.align)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		if uint64(counted.count) != fh.CompressedSize64 {
+			return fmt.Errorf("%s changed while splitting.", name)
+		}
+
+		emitEvent(event{
+			Event: "entry_copied",
+			Part:  bucket.Name,
+			Entry: fh.Name,
+			Size:  fh.CompressedSize64})
+	}
+
+	return w.Close()
+}
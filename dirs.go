@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"slices"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// What to do with the directory entries of the source.
+const (
+	dirsKeep         = "keep"
+	dirsDrop         = "drop"
+	dirsWithChildren = "with-children"
+	dirsRecreate     = "recreate"
+)
+
+func isDirEntry(file *zip.FileHeader) bool {
+	return strings.HasSuffix(file.Name, "/")
+}
+
+// Leave out the directory entries, when they are dropped.
+func dropDirs(files []*zip.FileHeader, config Config) []*zip.FileHeader {
+	if config.dirs != dirsDrop {
+		return files
+	}
+
+	var kept []*zip.FileHeader
+	for _, file := range files {
+		if !isDirEntry(file) {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept
+}
+
+// Return the directories an entry is in, from the top down, like a/
+// and a/b/ for a/b/c.
+func parentDirs(name string) []string {
+	var dirs []string
+	for i, c := range strings.TrimSuffix(name, "/") {
+		if c == '/' {
+			dirs = append(dirs, name[:i+1])
+		}
+	}
+
+	return dirs
+}
+
+// Return the room the directory entries an entry is in take up, which
+// recreating them can add to any part the entry ends up in.
+func parentDirsSize(file *zip.FileHeader, config Config) uint64 {
+	if config.dirs != dirsRecreate {
+		return 0
+	}
+
+	var size uint64
+	for _, dir := range parentDirs(file.Name) {
+		size += entrySize(dirHeader(dir, config), config)
+	}
+
+	return size
+}
+
+// Put every directory entry in a group with the first entry under it,
+// so they end up in the same part. Directories with nothing under them,
+// or only chunks, are left to go anywhere.
+func groupDirsWithChildren(items []pack.Item, capacity uint64) []pack.Item {
+	firstChild := make(map[string]int)
+	var dirs []int
+	for i, item := range items {
+		var files []*zip.FileHeader
+		switch item := item.(type) {
+		case zipItem:
+			if isDirEntry(item.file) {
+				dirs = append(dirs, i)
+				continue
+			}
+			files = append(files, item.file)
+		case *zipGroup:
+			for _, groupItem := range item.items {
+				files = append(files, groupItem.file)
+			}
+		}
+		for _, file := range files {
+			for _, dir := range parentDirs(file.Name) {
+				if _, ok := firstChild[dir]; !ok {
+					firstChild[dir] = i
+				}
+			}
+		}
+	}
+
+	// Parents go first, so directories come before what is in them.
+	slices.SortFunc(dirs, func(a, b int) int {
+		return strings.Compare(items[a].(zipItem).file.Name,
+			items[b].(zipItem).file.Name)
+	})
+
+	groups := make(map[int]*zipGroup)
+	moved := make(map[int]bool)
+	for _, i := range dirs {
+		dir := items[i].(zipItem)
+		j, ok := firstChild[dir.file.Name]
+		if !ok {
+			continue
+		}
+
+		group, ok := groups[j]
+		if !ok {
+			switch child := items[j].(type) {
+			case zipItem:
+				group = &zipGroup{items: []zipItem{child}}
+			case *zipGroup:
+				group = child
+			}
+		}
+		if group.Size()+dir.Size() > capacity {
+			continue
+		}
+
+		// After the directories already in the group.
+		at := 0
+		for at < len(group.items) && isDirEntry(group.items[at].file) {
+			at++
+		}
+		group.items = slices.Insert(group.items, at, dir)
+		groups[j] = group
+		moved[i] = true
+	}
+
+	var grouped []pack.Item
+	for i, item := range items {
+		switch {
+		case moved[i]:
+		case groups[i] != nil:
+			grouped = append(grouped, groups[i])
+		default:
+			grouped = append(grouped, item)
+		}
+	}
+
+	return grouped
+}
+
+// Give every part the directory entries of the directories its entries
+// are in, after any entries which have to lead, so extracting a part on
+// its own makes the whole tree.
+func recreateDirs(buckets []*Bucket, config Config) {
+	if config.dirs != dirsRecreate {
+		return
+	}
+
+	for _, bucket := range buckets {
+		has := make(map[string]bool)
+		for _, file := range bucket.Files {
+			has[file.Name] = true
+		}
+
+		var added []*zip.FileHeader
+		for _, file := range bucket.Files {
+			for _, dir := range parentDirs(file.Name) {
+				if has[dir] {
+					continue
+				}
+				has[dir] = true
+				fh := dirHeader(dir, config)
+				added = append(added, fh)
+				bucket.Size += entrySize(fh, config)
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		slices.SortFunc(added, func(a, b *zip.FileHeader) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		at := 0
+		for at < len(bucket.Files) && leadingRank(bucket.Files[at], config) >= 0 {
+			at++
+		}
+		bucket.Files = slices.Insert(bucket.Files, at, added...)
+		if bucket.Dirs == nil {
+			bucket.Dirs = make(map[*zip.FileHeader]bool)
+		}
+		for _, fh := range added {
+			bucket.Dirs[fh] = true
+		}
+	}
+}
+
+// Return the header of a directory entry made for a part, with its
+// time and mode treated like those of the entries.
+func dirHeader(name string, config Config) *zip.FileHeader {
+	fh := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: config.entryTime}
+	fh.SetMode(fs.ModeDir | 0755)
+
+	files := []*zip.FileHeader{fh}
+	if config.stripMetadata {
+		stripMetadata(files)
+	}
+	if config.reproducible {
+		normalizeEntries(files, config.entryTime)
+	}
+
+	return fh
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Show which entries go in which part, and how large the parts are
+// expected to be, for a dry run.
+func printLayout(buckets []*Bucket, config Config) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	overhead := partOverhead(config)
+	var total uint64
+	entries := 0
+
+	for _, bucket := range buckets {
+		size := bucket.Size + overhead
+		fmt.Fprintf(w, "%s\t%d entries\t%s\n", bucket.Name,
+			len(bucket.Files), numberToHuman(size))
+		for _, file := range bucket.Files {
+			fmt.Fprintf(w, "  %12d\t%s\n", file.CompressedSize64, file.Name)
+		}
+
+		total += size
+		entries += len(bucket.Files)
+	}
+
+	fmt.Fprintf(w, "%d parts\t%d entries\t%s\n", len(buckets), entries,
+		numberToHuman(total))
+	w.Flush()
+}
+
+// Tell which part every entry goes in, and when debugging how full
+// every part is expected to be.
+func printAssignments(buckets []*Bucket, config Config) {
+	overhead := partOverhead(config)
+
+	for _, bucket := range buckets {
+		debugf("%s: %d of %d bytes, %d for entries and %d overhead.",
+			bucket.Name, bucket.Size+overhead, config.splitSize,
+			bucket.Size, overhead)
+		for _, file := range bucket.Files {
+			fmt.Fprintf(progress, "%s -> %s\n", file.Name, bucket.Name)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Return the size of a part, or the room its entries take up once
+// extracted when parts are measured by that.
+func measurePart(f *os.File, config Config) (uint64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if config.measure != measureUncompressed {
+		return uint64(info.Size()), nil
+	}
+
+	r, err := zip.NewReader(f, info.Size())
+	if err != nil {
+		return 0, err
+	}
+
+	var size uint64
+	for _, file := range r.File {
+		size += entrySize(&file.FileHeader, config)
+	}
+
+	return size, nil
+}
+
+// Rewrite a part through a temporary file, keeping the entries for
+// which keep returns true and then having add write any new ones. The
+// part is only replaced once the new version is complete, and not at
+// all when it would grow beyond limit.
+func rewritePart(filename string, keep func(f *zip.File) bool,
+	add func(w *split.Writer) error, limit uint64, config Config) error {
+
+	old, err := zip.OpenReader(filename)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename),
+		filepath.Base(filename)+".tmp*")
+	if err != nil {
+		return err
+	}
+
+	dst := startPart(filename, tmp)
+	err = writeRewrittenPart(dst, &old.Reader, keep, add, config)
+	if err == nil && limit > 0 {
+		var size uint64
+		size, err = measurePart(tmp, config)
+		if err == nil && size > limit {
+			err = fmt.Errorf("%s would grow to %s, beyond the split size.",
+				filename, numberToHuman(size))
+		}
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	// Windows won't replace a file which is still open.
+	old.Close()
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return err
+	}
+	closePart(filename, dst)
+
+	return nil
+}
+
+func writeRewrittenPart(tmp io.Writer, old *zip.Reader,
+	keep func(f *zip.File) bool, add func(w *split.Writer) error,
+	config Config) error {
+
+	w := split.NewWriter(tmp)
+	if old.Comment != "" {
+		if err := w.SetComment(old.Comment); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range old.File {
+		if keep != nil && !keep(f) {
+			continue
+		}
+		if err := split.CopyEntry(w, f, config.align); err != nil {
+			return err
+		}
+	}
+
+	if add != nil {
+		if err := add(w); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
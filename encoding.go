@@ -0,0 +1,196 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// What entry names without the UTF-8 flag are taken to be in.
+const (
+	encodingKeep     = "keep"
+	encodingAuto     = "auto"
+	encodingCP437    = "cp437"
+	encodingShiftJIS = "shift-jis"
+	encodingGBK      = "gbk"
+)
+
+// The general purpose flag telling the name and comment are UTF-8.
+const utf8Flag = 0x800
+
+// The characters of the bytes 0x80 to 0xFF in code page 437, the
+// encoding zip names are in when the flag isn't set.
+const cp437High = "ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»░▒▓" +
+	"│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0"
+
+// The tables as runes, made when first needed.
+var (
+	loadTables    sync.Once
+	cp437Runes    []rune
+	shiftJISRunes [256][]rune
+	gbkRunes      [256][]rune
+)
+
+func loadEncodingTables() {
+	cp437Runes = []rune(cp437High)
+	for lead := range 256 {
+		if shiftJISTable[lead] != "" {
+			shiftJISRunes[lead] = []rune(shiftJISTable[lead])
+		}
+		if gbkTable[lead] != "" {
+			gbkRunes[lead] = []rune(gbkTable[lead])
+		}
+	}
+}
+
+// Return the name decoded from the encoding, and whether it is valid in
+// it. Every byte string is valid code page 437.
+func decodeName(name, encoding string) (string, bool) {
+	loadTables.Do(loadEncodingTables)
+
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c < 0x80:
+			b.WriteByte(c)
+			continue
+
+		case encoding == encodingCP437:
+			b.WriteRune(cp437Runes[c-0x80])
+			continue
+
+		// Half-width katakana are single bytes in Shift-JIS.
+		case encoding == encodingShiftJIS && c >= 0xa1 && c <= 0xdf:
+			b.WriteRune(0xff61 + rune(c-0xa1))
+			continue
+		}
+
+		row := gbkRunes[c]
+		if encoding == encodingShiftJIS {
+			row = shiftJISRunes[c]
+		}
+		if row == nil || i+1 == len(name) || name[i+1] < 0x40 ||
+			int(name[i+1]-0x40) >= len(row) {
+			return "", false
+		}
+		r := row[name[i+1]-0x40]
+		if r == utf8.RuneError {
+			return "", false
+		}
+		b.WriteRune(r)
+		i++
+	}
+
+	return b.String(), true
+}
+
+// Return the encoding names from a Windows machine were most likely
+// written in. Japanese names make sense as GBK as often as not, but
+// Chinese ones are full of half-width katakana as Shift-JIS, which
+// names hardly ever have. Names in neither are taken to be code page
+// 437, as the zip format says.
+func detectEncoding(names []string) string {
+	shiftJIS, gbk := true, true
+	score := 0
+	for _, name := range names {
+		if s, ok := decodeName(name, encodingShiftJIS); ok {
+			for _, r := range s {
+				switch {
+				case unicode.In(r, unicode.Hiragana, unicode.Katakana) &&
+					r < 0xff61:
+					score++
+				case r >= 0xff61 && r <= 0xff9f:
+					score--
+				}
+			}
+		} else {
+			shiftJIS = false
+		}
+		if _, ok := decodeName(name, encodingGBK); !ok {
+			gbk = false
+		}
+	}
+
+	switch {
+	case shiftJIS && (score > 0 || !gbk):
+		return encodingShiftJIS
+	case gbk:
+		return encodingGBK
+	case shiftJIS:
+		return encodingShiftJIS
+	}
+
+	return encodingCP437
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
+
+func setUTF8(file *zip.FileHeader) {
+	file.Flags |= utf8Flag
+	file.NonUTF8 = false
+}
+
+// Convert the names and comments of the entries without the UTF-8 flag
+// to UTF-8 and set the flag, unless they are kept as they are. Names
+// which are valid UTF-8 already are taken to be so when detecting the
+// encoding, as some tools never set the flag. The headers are shared
+// with the source reader, so the entries are written under their new
+// names.
+func convertNames(files []*zip.FileHeader, config Config) {
+	if config.encoding == encodingKeep {
+		return
+	}
+
+	var legacy []*zip.FileHeader
+	var names []string
+	for _, file := range files {
+		switch {
+		case file.Flags&utf8Flag != 0:
+		case isASCII(file.Name) && isASCII(file.Comment):
+		case config.encoding == encodingAuto &&
+			utf8.ValidString(file.Name) && utf8.ValidString(file.Comment):
+			setUTF8(file)
+		default:
+			legacy = append(legacy, file)
+			names = append(names, file.Name, file.Comment)
+		}
+	}
+	if len(legacy) == 0 {
+		return
+	}
+
+	encoding := config.encoding
+	if encoding == encodingAuto {
+		encoding = detectEncoding(names)
+	}
+	if config.verbose {
+		fmt.Fprintf(progress, "Converting %d entry names from %s.\n",
+			len(legacy), encoding)
+	}
+
+	for _, file := range legacy {
+		name, ok := decodeName(file.Name, encoding)
+		comment, commentOK := decodeName(file.Comment, encoding)
+		if !ok || !commentOK {
+			warnings.Printf("%q is not %s, keeping the name as it is.",
+				file.Name, encoding)
+			continue
+		}
+		file.Name = name
+		file.Comment = comment
+		setUTF8(file)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ascheepe/zipsplit/sink"
+)
+
+// Event formats.
+const eventsNDJSON = "ndjson"
+
+// Where events go, one JSON object per line, or nil when not asked
+// for.
+var events *json.Encoder
+
+// Parts may be written by several workers at once.
+var eventsMu sync.Mutex
+
+// Something which happened while splitting, for programs tracking a
+// run.
+type event struct {
+	Event   string    `json:"event"`
+	Time    time.Time `json:"time"`
+	Part    string    `json:"part,omitempty"`
+	Entry   string    `json:"entry,omitempty"`
+	Parts   int       `json:"parts,omitempty"`
+	Entries int       `json:"entries,omitempty"`
+	Size    uint64    `json:"size,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+}
+
+// Write events to stdout, moving the progress messages for people
+// out of the way to stderr.
+func enableEvents() {
+	events = json.NewEncoder(os.Stdout)
+	if progress == io.Writer(os.Stdout) {
+		progress = os.Stderr
+	}
+}
+
+func emitEvent(e event) {
+	if events == nil {
+		return
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+
+	e.Time = time.Now().UTC()
+	events.Encode(e)
+}
+
+// Counts and hashes what is written to a part, for its closing event
+// and the journal.
+type partHasher struct {
+	io.WriteCloser
+	name string
+	h    hash.Hash
+	crc  hash.Hash32
+	size uint64
+}
+
+// Start a part, returning its output wrapped so that it can be
+// reported on when closed.
+func startPart(name string, w io.WriteCloser) io.WriteCloser {
+	emitEvent(event{Event: "part_started", Part: name})
+	return &partHasher{WriteCloser: w, name: name, h: sha256.New(),
+		crc: crc32.NewIEEE()}
+}
+
+func (w *partHasher) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.h.Write(p[:n])
+	w.crc.Write(p[:n])
+	w.size += uint64(n)
+	meter.add(w.name, n)
+
+	return n, err
+}
+
+// Give up on a part, leaving nothing of it behind where its output
+// can, or else closing it as it is.
+func abortPart(w io.WriteCloser) error {
+	if ph, ok := w.(*partHasher); ok {
+		w = ph.WriteCloser
+	}
+	if a, ok := w.(sink.Aborter); ok {
+		return a.Abort()
+	}
+
+	return w.Close()
+}
+
+// Report a part as complete, with its size and checksum, and keep
+// those for the journal and the manifest.
+func closePart(name string, w io.WriteCloser) {
+	ph, ok := w.(*partHasher)
+	if !ok {
+		return
+	}
+
+	written := journalPart{
+		Name:   name,
+		Size:   ph.size,
+		CRC32:  ph.crc.Sum32(),
+		SHA256: hex.EncodeToString(ph.h.Sum(nil))}
+	eventsMu.Lock()
+	writtenParts = append(writtenParts, written)
+	eventsMu.Unlock()
+
+	emitEvent(event{
+		Event:  "part_closed",
+		Part:   name,
+		Size:   written.Size,
+		SHA256: written.SHA256})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// The size of a zip part without entries, just the end of central
+// directory record.
+const emptyZipSize = 22
+
+// Stands in for the data of an entry when only the size of the output
+// matters; the buffer is left as it is.
+type sizeOnlyReader struct{}
+
+func (sizeOnlyReader) Read(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Return the exact size of a zip part holding the given entries, by
+// writing it to nowhere.
+func measureZip(files []*zip.FileHeader, config Config) (uint64, error) {
+	w := split.NewWriter(io.Discard)
+	w.ForceZip64 = config.forceZip64
+	w.Password = config.outPassword
+
+	for _, file := range files {
+		data := io.LimitReader(sizeOnlyReader{}, int64(file.CompressedSize64))
+		if err := split.WriteEntry(w, file, data, config.align); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+
+	return uint64(w.Written()), nil
+}
+
+// Return the exact room an entry takes up in a zip part, leaving out
+// alignment which depends on where it ends up.
+func measureEntry(file *zip.FileHeader) uint64 {
+	size, err := measureZip([]*zip.FileHeader{file}, Config{})
+	if err != nil {
+		// The entry will fail to copy as well, let that report it.
+		return split.EntrySize(file, split.DefaultOverhead)
+	}
+
+	return size - emptyZipSize
+}
+
+// Measure the planned parts and move entries out of those which turn
+// out too large, which only happens when alignment or zip64 records
+// take more room than expected, into parts of their own.
+func exactFit(buckets []*Bucket, capacity uint64, newZipName func() string,
+	config Config) ([]*Bucket, error) {
+
+	var overflow []pack.Item
+
+	for _, bucket := range buckets {
+		for {
+			size, err := measureZip(bucket.Files, config)
+			if err != nil {
+				return nil, err
+			}
+			size += maxCommentSize(config)
+			if size <= config.splitSize {
+				bucket.Size = size
+				break
+			}
+
+			n := len(bucket.Files)
+			if n == 1 {
+				return nil, withStatus(exitTooLarge, fmt.Errorf(
+					"Can never fit %s (%s).", bucket.Files[0].Name,
+					numberToHuman(size)))
+			}
+
+			last := bucket.Files[n-1]
+			bucket.Files = bucket.Files[:n-1]
+			overflow = append(overflow, zipItem{last, entrySize(last, config)})
+		}
+	}
+
+	if len(overflow) == 0 {
+		return buckets, nil
+	}
+
+	bins, err := packStrategy(config)(overflow, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	more, err := exactFit(binsToBuckets(bins, newZipName), capacity,
+		newZipName, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(buckets, more...), nil
+}
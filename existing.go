@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/sink"
+)
+
+// The parts kept when resuming whose size and CRC32 are known, for the
+// manifest.
+var keptParts []journalPart
+
+// Return the parts to write. A part which is already there is an
+// error, unless existing parts are skipped or the split is resumed,
+// when it is left as it is as long as it has the entries planned for
+// it. Resuming writes it again otherwise. Parts named by their
+// contents are the same when their name is, and remote parts can't be
+// looked for.
+func partsToWrite(buckets []*Bucket, config Config) ([]*Bucket, error) {
+	if _, ok := config.partSink.(sink.File); !ok || config.overwrite {
+		return buckets, nil
+	}
+
+	earlier, err := earlierParts(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var toWrite []*Bucket
+	for _, bucket := range buckets {
+		if namedByContents(bucket.Name) {
+			toWrite = append(toWrite, bucket)
+			continue
+		}
+
+		name, err := partPath(bucket.Name, config)
+		if err != nil {
+			return nil, err
+		}
+
+		// What an interrupted run left of the part it was writing.
+		if config.resume {
+			err := os.Remove(name + sink.TempSuffix)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+		}
+
+		_, err = os.Stat(name)
+		if errors.Is(err, os.ErrNotExist) {
+			toWrite = append(toWrite, bucket)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !config.skipExisting && !config.resume {
+			return nil, fmt.Errorf("%s already exists, overwrite it with "+
+				"-force or keep it with -skip-existing.", name)
+		}
+
+		if part, ok := earlier[bucket.Name]; ok && sameEntries(part, bucket) {
+			err = keepWritten(name, part)
+		} else {
+			err = bucket.quickVerify(config)
+		}
+		if err != nil && config.resume {
+			if config.verbose {
+				fmt.Fprintf(progress, "Writing %s again: %v\n", bucket.Name, err)
+			}
+			toWrite = append(toWrite, bucket)
+			continue
+		}
+		if err != nil {
+			warnings.Print(err)
+			return nil, fmt.Errorf("%s is not the part planned, overwrite "+
+				"it with -force.", name)
+		}
+		if config.verbose {
+			fmt.Fprintf(progress, "Keeping %s.\n", bucket.Name)
+		}
+	}
+
+	if config.resume && config.verbose {
+		fmt.Fprintf(progress, "Resuming with %d of %d parts left to write.\n",
+			len(toWrite), len(buckets))
+	}
+
+	return toWrite, nil
+}
+
+// Return the parts of the manifest of an earlier run by name, when
+// resuming and there is one.
+func earlierParts(config Config) (map[string]manifest.Part, error) {
+	if !config.resume || config.manifestName == "" {
+		return nil, nil
+	}
+
+	m, err := manifest.Load(config.manifestName)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", config.manifestName, err)
+	}
+
+	parts := make(map[string]manifest.Part)
+	for _, part := range m.Parts {
+		parts[part.Name] = part
+	}
+
+	return parts, nil
+}
+
+// Report whether a part of an earlier manifest has the entries planned
+// for a bucket, in the same order.
+func sameEntries(part manifest.Part, bucket *Bucket) bool {
+	if len(part.Entries) != len(bucket.Files) {
+		return false
+	}
+	for i, file := range bucket.Files {
+		entry := part.Entries[i]
+		if entry.Name != file.Name || entry.CRC32 != file.CRC32 ||
+			entry.CompressedSize != file.CompressedSize64 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check a part against the size and CRC32 the earlier manifest has for
+// it, keeping those for this run's manifest when they match.
+func keepWritten(name string, part manifest.Part) error {
+	if part.Size == 0 {
+		return errors.New("the manifest has no size for it")
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	if _, err := hashFile(name, crc); err != nil {
+		return err
+	}
+	if uint64(info.Size()) != part.Size || crc.Sum32() != part.CRC32 {
+		return errors.New("it differs from the manifest")
+	}
+
+	keptParts = append(keptParts, journalPart{
+		Name:  part.Name,
+		Size:  part.Size,
+		CRC32: part.CRC32})
+
+	return nil
+}
+
+// Point to -force when a part can't be created as it is already there.
+func explainExisting(name string, err error) error {
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists, overwrite it with -force.", name)
+	}
+
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Limits of the filesystems parts are commonly copied to.
+type filesystem struct {
+	// The largest file it can hold, or 0 when that is no concern.
+	maxFileSize uint64
+
+	// The cluster size it is usually formatted with.
+	clusterSize uint64
+}
+
+var filesystems = map[string]filesystem{
+	"fat32": {4*GByte - 1, 32 * KByte},
+	"exfat": {0, 128 * KByte},
+}
+
+// Adjust the split size to what fits on the named filesystem: below
+// its file size limit and a whole number of clusters, so a part takes
+// up no more room on disk than the split size.
+func applyFilesystem(name string, clusterSize uint64,
+	config *Config) error {
+
+	fs, ok := filesystems[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("Unknown filesystem %q.", name)
+	}
+	if clusterSize == 0 {
+		clusterSize = fs.clusterSize
+	}
+
+	if fs.maxFileSize != 0 && config.splitSize > fs.maxFileSize {
+		config.splitSize = fs.maxFileSize
+	}
+	config.splitSize = roundDown(config.splitSize, clusterSize)
+	if config.splitSize == 0 {
+		return fmt.Errorf("Split size smaller than a cluster (%s).",
+			numberToHuman(clusterSize))
+	}
+
+	config.maxFileSize = fs.maxFileSize
+	config.clusterSize = clusterSize
+
+	return nil
+}
+
+// Round n up to a multiple of unit, unless unit is 0.
+func roundUp(n, unit uint64) uint64 {
+	if unit == 0 || n%unit == 0 {
+		return n
+	}
+
+	return n + unit - n%unit
+}
+
+// Round n down to a multiple of unit, unless unit is 0.
+func roundDown(n, unit uint64) uint64 {
+	if unit == 0 {
+		return n
+	}
+
+	return n - n%unit
+}
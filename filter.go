@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Glob patterns for a flag which can be given more than once.
+type patternList []string
+
+func (l *patternList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *patternList) Set(pattern string) error {
+	if !validPattern(pattern) {
+		return fmt.Errorf("Invalid pattern %q.", pattern)
+	}
+	*l = append(*l, pattern)
+
+	return nil
+}
+
+// Add the patterns in a file, one per line, leaving out empty lines
+// and comments starting with #.
+func (l *patternList) load(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		if err := l.Set(pattern); err != nil {
+			return fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func validPattern(pattern string) bool {
+	for _, component := range strings.Split(pattern, "/") {
+		if _, err := path.Match(component, ""); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Report whether an entry name matches a glob pattern. A pattern
+// without a slash matches the last component of the name, like *.log,
+// and otherwise the whole name, where ** matches any number of
+// components, like docs/**.
+func matchGlob(pattern, name string) bool {
+	name = strings.TrimSuffix(name, "/")
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+
+	return matchComponents(strings.Split(pattern, "/"),
+		strings.Split(name, "/"))
+}
+
+func matchComponents(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchComponents(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if matched, _ := path.Match(pattern[0], name[0]); !matched {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Report whether an entry is to be split, being included when there
+// are include patterns and not excluded.
+func isSelected(name string, config Config) bool {
+	if len(config.include) > 0 && !matchAny(config.include, name) {
+		return false
+	}
+
+	return !matchAny(config.exclude, name)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// Tell which parts hold the entries matching a pattern, without
+// extracting anything. Patterns match like those of -include.
+func findCommand(args []string) {
+	flags := flag.NewFlagSet("find", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Read the entries from this manifest instead of the parts.")
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || *manifestName == "" && flags.NArg() < 2 {
+		fatal(errors.New(
+			"Usage: zipsplit find pattern [-manifest manifest.json] [part...]"))
+	}
+	pattern := flags.Arg(0)
+
+	// Flags may also follow the pattern.
+	flags.Parse(flags.Args()[1:])
+
+	if !validPattern(pattern) {
+		fatalf("Invalid pattern %q.", pattern)
+	}
+
+	entries, err := setEntries(*manifestName, flags.Args())
+	if err != nil {
+		fatal(err)
+	}
+
+	found := false
+	for _, entry := range entries {
+		if matchGlob(pattern, entry.Name) {
+			fmt.Printf("%s\t%s\n", entry.part, entry.Name)
+			found = true
+		}
+	}
+
+	// Like grep, let scripts tell whether anything matched.
+	if !found {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/cpio"
+	"github.com/ascheepe/zipsplit/split"
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// Formats the parts can be written in.
+const (
+	formatZip  = "zip"
+	formatCpio = "cpio"
+)
+
+// What the size of a part is measured by.
+const (
+	measureCompressed   = "compressed"
+	measureUncompressed = "uncompressed"
+)
+
+// Return the room an entry takes up in a part.
+func entrySize(file *zip.FileHeader, config Config) uint64 {
+	if config.measure == measureUncompressed {
+		return extractedSize(file, config)
+	}
+
+	if config.format == formatCpio {
+		return uint64(cpio.EntrySize(cpioName(file),
+			int64(file.UncompressedSize64)))
+	}
+
+	if config.exactSize {
+		return measureEntry(file) + split.MaxAlignmentPadding(file, config.align)
+	}
+
+	size := split.EntrySize(file, entryOverhead(config)) + split.MaxAlignmentPadding(file, config.align)
+	if config.outPassword != "" && !strings.HasSuffix(file.Name, "/") {
+		size += zipcrypt.AESOverhead
+	}
+
+	return size
+}
+
+// Return the room an entry takes up once extracted, in whole clusters
+// when the filesystem is known, of which a directory takes one.
+func extractedSize(file *zip.FileHeader, config Config) uint64 {
+	if strings.HasSuffix(file.Name, "/") {
+		return config.clusterSize
+	}
+
+	return roundUp(file.UncompressedSize64, config.clusterSize)
+}
+
+// Return the overhead to assume for the entries of a part, which in a
+// part past 4GiB can start too far in for a 32 bit offset.
+func entryOverhead(config Config) split.Overhead {
+	overhead := config.overhead
+	overhead.LargeOffsets = config.splitSize > math.MaxUint32
+
+	return overhead
+}
+
+// Show how the room taken by the parts and their entries is worked
+// out.
+func debugSizes(files []*zip.FileHeader, config Config) {
+	if logLevel < levelDebug {
+		return
+	}
+
+	comments := maxCommentSize(config)
+	switch {
+	case config.measure == measureUncompressed:
+		debugf("Parts are measured by the room their entries take up " +
+			"once extracted.")
+	case config.format == formatCpio:
+		debugf("Every part takes %d bytes for the cpio trailer.",
+			cpio.TrailerSize)
+	case config.exactSize:
+		debugf("Every part takes %d bytes for an empty zip and %d for "+
+			"its comment.", partOverhead(config)-comments, comments)
+	default:
+		debugf("Every part takes %d bytes for the end of central "+
+			"directory and %d for its comment.", endSize(config),
+			comments)
+	}
+
+	for _, file := range files {
+		debugf("%s: %s", file.Name, explainEntrySize(file, config))
+	}
+}
+
+// Spell out the sum entrySize makes for an entry.
+func explainEntrySize(file *zip.FileHeader, config Config) string {
+	if config.measure == measureUncompressed {
+		return fmt.Sprintf("%d = %d uncompressed in clusters of %d",
+			entrySize(file, config), file.UncompressedSize64,
+			config.clusterSize)
+	}
+	if config.format == formatCpio {
+		return fmt.Sprintf("%d bytes as a cpio entry",
+			entrySize(file, config))
+	}
+
+	padding := split.MaxAlignmentPadding(file, config.align)
+	if config.exactSize {
+		return fmt.Sprintf("%d = %d measured + %d alignment padding",
+			entrySize(file, config), measureEntry(file), padding)
+	}
+
+	var encryption uint64
+	if config.outPassword != "" && !strings.HasSuffix(file.Name, "/") {
+		encryption = zipcrypt.AESOverhead
+	}
+
+	overhead := entryOverhead(config)
+	return fmt.Sprintf("%d = %d data + %d local header + %d central "+
+		"header + %d extra + 2*%d name + 2*%d extra field + %d comment + "+
+		"%d zip64 + %d data descriptor + %d alignment padding + "+
+		"%d encryption",
+		entrySize(file, config), file.CompressedSize64, overhead.Local,
+		overhead.Central, overhead.Extra, len(file.Name), len(file.Extra),
+		len(file.Comment), split.Zip64Size(file, overhead.LargeOffsets),
+		split.DataDescriptorSize(file), padding, encryption)
+}
+
+// Check that an entry isn't too large for a cpio part, whose size
+// fields have 32 bits.
+func checkCpioSize(file *zip.FileHeader) error {
+	if file.UncompressedSize64 > cpio.MaxSize {
+		return fmt.Errorf("%s is too large for a cpio part (%s).",
+			file.Name, numberToHuman(file.UncompressedSize64))
+	}
+
+	return nil
+}
+
+// Return the room every part needs besides its entries.
+func partOverhead(config Config) uint64 {
+	if config.measure == measureUncompressed {
+		return 0
+	}
+
+	if config.format == formatCpio {
+		return uint64(cpio.TrailerSize)
+	}
+
+	if config.exactSize {
+		size := uint64(emptyZipSize)
+		if config.forceZip64 {
+			size += split.EndOfDirectory64Len
+		}
+		return size + maxCommentSize(config)
+	}
+
+	return endSize(config) + maxCommentSize(config)
+}
+
+// Return the room the records ending a zip part take up, besides its
+// comment.
+func endSize(config Config) uint64 {
+	if config.forceZip64 {
+		return split.EndOfDirectoryLen + split.EndOfDirectory64Len
+	}
+
+	return split.EndSize(config.splitSize)
+}
+
+func cpioName(file *zip.FileHeader) string {
+	return strings.TrimSuffix(file.Name, "/")
+}
+
+// Convert the mode of a zip entry to the unix mode cpio stores.
+func cpioMode(file *zip.FileHeader) uint32 {
+	mode := file.Mode()
+	perm := uint32(mode.Perm())
+
+	switch {
+	case mode&fs.ModeDir != 0:
+		return cpio.ModeDir | perm
+	case mode&fs.ModeSymlink != 0:
+		return cpio.ModeSymlink | perm
+	default:
+		return cpio.ModeRegular | perm
+	}
+}
+
+// Write the bucket as a cpio archive; unlike zip parts the entries
+// have to be decompressed.
+func (bucket *Bucket) copyFilesCpio(source *split.Source,
+	destination io.Writer) error {
+
+	w := cpio.NewWriter(destination)
+
+	for _, bucketFile := range bucket.Files {
+		sourceFile, ok := source.Find(bucketFile)
+		if !ok {
+			continue
+		}
+
+		// Find out whether the data can be read before writing its
+		// header, so a part never ends with a header without data.
+		if err := checkCpioSize(bucketFile); err != nil {
+			return err
+		}
+		if zipcrypt.IsEncrypted(&sourceFile.FileHeader) {
+			return fmt.Errorf("%s is encrypted, give its password with "+
+				"-password.", sourceFile.Name)
+		}
+		r, err := sourceFile.Open()
+		if err != nil {
+			return err
+		}
+
+		err = w.WriteHeader(&cpio.Header{
+			Name:    cpioName(bucketFile),
+			Mode:    cpioMode(bucketFile),
+			ModTime: sourceFile.Modified,
+			Size:    int64(sourceFile.UncompressedSize64)})
+		if err != nil {
+			r.Close()
+			return err
+		}
+		_, err = io.Copy(w, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+		emitEvent(event{
+			Event: "entry_copied",
+			Part:  bucket.Name,
+			Entry: sourceFile.Name,
+			Size:  sourceFile.UncompressedSize64})
+	}
+
+	return w.Close()
+}
+
+// Report whether a flag was given on the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
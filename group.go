@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"path"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// What -group-by keeps together.
+const (
+	groupDir  = "dir"
+	groupExt  = "ext"
+	groupMime = "mime"
+)
+
+// Return the directory an entry is grouped under, its first depth
+// directories, or "" for entries at the top.
+func groupPrefix(name string, depth int) string {
+	dir := path.Dir(strings.TrimSuffix(name, "/"))
+	if dir == "." || dir == "/" {
+		return ""
+	}
+
+	parts := strings.Split(dir, "/")
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// Gather the items under the same directory into groups which are
+// kept in one part. Directories too large for a part are left for
+// the packer to spread, as are the entries at the top.
+func groupByDir(items []pack.Item, capacity uint64,
+	config Config) []pack.Item {
+
+	var prefixes []string
+	groups := make(map[string]*zipGroup)
+	var grouped []pack.Item
+
+	for _, item := range items {
+		zi, ok := item.(zipItem)
+		if !ok {
+			grouped = append(grouped, item)
+			continue
+		}
+		prefix := groupPrefix(zi.file.Name, config.groupDepth)
+		if prefix == "" {
+			grouped = append(grouped, item)
+			continue
+		}
+
+		group, ok := groups[prefix]
+		if !ok {
+			group = &zipGroup{}
+			groups[prefix] = group
+			prefixes = append(prefixes, prefix)
+		}
+		group.items = append(group.items, zi)
+	}
+
+	for _, prefix := range prefixes {
+		group := groups[prefix]
+		switch {
+		case group.Size() > capacity:
+			if config.verbose {
+				fmt.Fprintf(progress, "%s/ does not fit in one part.\n",
+					prefix)
+			}
+			for _, item := range group.items {
+				grouped = append(grouped, item)
+			}
+		case len(group.items) == 1:
+			grouped = append(grouped, group.items[0])
+		default:
+			grouped = append(grouped, group)
+		}
+	}
+
+	return grouped
+}
+
+// Return the type an entry is grouped under: its extension, or the
+// top level of its MIME type like image or text. Directories and
+// entries of no known type have none.
+func typeKey(name, groupBy string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" || strings.HasSuffix(name, "/") {
+		return ""
+	}
+	if groupBy == groupExt {
+		return ext
+	}
+
+	kind, _, _ := strings.Cut(mime.TypeByExtension(ext), "/")
+	return kind
+}
+
+// Gather the items of the same type into groups which are kept in one
+// part. The entries of a type too large for a part are packed into as
+// few groups as fit, so they still share as few parts as they can.
+// Entries without a type are left for the packer to spread.
+func groupByType(items []pack.Item, capacity uint64,
+	config Config) []pack.Item {
+
+	var kinds []string
+	byKind := make(map[string][]pack.Item)
+	var grouped []pack.Item
+
+	for _, item := range items {
+		var kind string
+		if zi, ok := item.(zipItem); ok {
+			kind = typeKey(zi.file.Name, config.groupBy)
+		}
+		if kind == "" {
+			grouped = append(grouped, item)
+			continue
+		}
+
+		if _, ok := byKind[kind]; !ok {
+			kinds = append(kinds, kind)
+		}
+		byKind[kind] = append(byKind[kind], item)
+	}
+
+	for _, kind := range kinds {
+		bins, err := pack.FirstFitDecreasing(byKind[kind], capacity)
+		if err != nil {
+			grouped = append(grouped, byKind[kind]...)
+			continue
+		}
+		if config.verbose && len(bins) > 1 {
+			fmt.Fprintf(progress, "%s entries do not fit in one part.\n",
+				kind)
+		}
+
+		for _, bin := range bins {
+			in := make(map[pack.Item]bool)
+			for _, item := range bin.Items {
+				in[item] = true
+			}
+
+			// In the order of the source rather than by size.
+			group := &zipGroup{}
+			for _, item := range byKind[kind] {
+				if in[item] {
+					group.items = append(group.items, item.(zipItem))
+				}
+			}
+			if len(group.items) == 1 {
+				grouped = append(grouped, group.items[0])
+			} else {
+				grouped = append(grouped, group)
+			}
+		}
+	}
+
+	return grouped
+}
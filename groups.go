@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// A pattern of a -groups file, and the group the entries matching it
+// are in.
+type groupRule struct {
+	group   string
+	pattern string
+}
+
+// Load the groups from a file with a group name and a glob pattern on
+// every line, leaving out empty lines and comments starting with #. A
+// group can have several lines; an entry is in the group of the first
+// pattern it matches.
+func loadGroups(name string) ([]groupRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []groupRule
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		i := strings.IndexAny(text, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("%s:%d: expected a group and a pattern.",
+				name, line)
+		}
+		group, pattern := text[:i], strings.TrimSpace(text[i:])
+		if !validPattern(pattern) {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q.", name,
+				line, pattern)
+		}
+		rules = append(rules, groupRule{group, pattern})
+	}
+
+	return rules, scanner.Err()
+}
+
+// Return the group an entry is in, or "" when it is in none.
+func groupOf(name string, rules []groupRule) string {
+	for _, rule := range rules {
+		if matchGlob(rule.pattern, name) {
+			return rule.group
+		}
+	}
+
+	return ""
+}
+
+// Gather the items of every group of the -groups file into a group of
+// their own, which has to fit in one part.
+func groupByRules(items []pack.Item, capacity uint64,
+	config Config) ([]pack.Item, error) {
+
+	var names []string
+	groups := make(map[string]*zipGroup)
+	var grouped []pack.Item
+
+	for _, item := range items {
+		var name string
+		switch item := item.(type) {
+		case zipItem:
+			name = groupOf(item.file.Name, config.groups)
+		case chunkItem:
+			if name := groupOf(item.file.Name, config.groups); name != "" {
+				return nil, withStatus(exitTooLarge, fmt.Errorf(
+					"The group %s can never fit in one part, %s alone "+
+						"does not.", name, item.file.Name))
+			}
+		}
+		if name == "" {
+			grouped = append(grouped, item)
+			continue
+		}
+
+		group, ok := groups[name]
+		if !ok {
+			group = &zipGroup{}
+			groups[name] = group
+			names = append(names, name)
+			grouped = append(grouped, group)
+		}
+		group.items = append(group.items, item.(zipItem))
+	}
+
+	for _, name := range names {
+		group := groups[name]
+		if group.Size() > capacity {
+			return nil, withStatus(exitTooLarge, fmt.Errorf(
+				"The group %s can never fit in one part (%s).", name,
+				numberToHuman(group.Size())))
+		}
+		if config.maxFiles > 0 && len(group.items) > config.maxFiles {
+			return nil, fmt.Errorf("The group %s has more entries than "+
+				"-max-files allows in one part.", name)
+		}
+		if config.verbose {
+			fmt.Fprintf(progress, "Keeping the %d entries of %s together.\n",
+				len(group.items), name)
+		}
+	}
+
+	return grouped, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+
+	"github.com/ascheepe/zipsplit/blake3"
+)
+
+// Digest algorithms for the entries in the manifest.
+const (
+	hashCRC32  = "crc32"
+	hashSHA1   = "sha1"
+	hashSHA256 = "sha256"
+	hashBLAKE3 = "blake3"
+)
+
+// Return a new hash of the named algorithm.
+func newHash(name string) (hash.Hash, error) {
+	switch name {
+	case hashCRC32:
+		return crc32.NewIEEE(), nil
+	case hashSHA1:
+		return sha1.New(), nil
+	case hashSHA256:
+		return sha256.New(), nil
+	case hashBLAKE3:
+		return blake3.New(), nil
+	default:
+		return nil, fmt.Errorf("Invalid hash %q.", name)
+	}
+}
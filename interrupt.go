@@ -0,0 +1,57 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/ascheepe/zipsplit/sink"
+)
+
+// Stop splitting when interrupted, reporting the parts which were
+// complete and exiting with the status a shell gives a process killed
+// by the signal, so scripts can tell an interrupted run from a failed
+// one. The parts being written are removed, unless a checkpoint is
+// kept to resume them from.
+func handleInterrupts(config Config, resumable bool) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		if config.checkpoint == "" {
+			sink.RemoveUnfinished()
+		}
+		closeStdin()
+
+		eventsMu.Lock()
+		var names []string
+		for _, part := range writtenParts {
+			names = append(names, part.Name)
+		}
+		eventsMu.Unlock()
+		emitEvent(event{Event: "interrupted", Parts: len(names)})
+
+		switch len(names) {
+		case 0:
+			warnings.Print("Interrupted before any part was complete.")
+		case 1:
+			warnings.Printf("Interrupted, %s was complete.", names[0])
+		default:
+			warnings.Printf("Interrupted, %d parts were complete: %s.",
+				len(names), strings.Join(names, ", "))
+		}
+		switch {
+		case config.checkpoint != "":
+			warnings.Printf("Run again with -checkpoint %s to resume.",
+				config.checkpoint)
+		case config.resume || config.skipExisting:
+			warnings.Print("Run again to finish the split.")
+		case len(names) > 0 && resumable:
+			warnings.Print("Run again with -resume to finish the split.")
+		}
+
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"sync"
+)
+
+// Write the parts with up to jobs of them at once, stopping at the
+// first error. The workers share the source, reading it at their own
+// offsets, which only a local file allows without them getting in each
+// other's way.
+func writeParts(buckets []*Bucket, writePart func(bucket *Bucket) error,
+	jobs int) error {
+
+	var mu sync.Mutex
+	var firstErr error
+	next := 0
+
+	worker := func() {
+		for {
+			mu.Lock()
+			if firstErr != nil || next == len(buckets) {
+				mu.Unlock()
+				return
+			}
+			bucket := buckets[next]
+			next++
+			mu.Unlock()
+
+			if err := writePart(bucket); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for range max(1, min(jobs, len(buckets))) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
+	}
+	wg.Wait()
+
+	return firstErr
+}
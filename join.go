@@ -0,0 +1,234 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// A part as seen by the join scripts, which run in the directory
+// holding the parts.
+type joinPart struct {
+	Name   string
+	SHA256 string
+}
+
+// An entry the join scripts check once extracted, against the CRC32
+// the manifest records for it.
+type joinedEntry struct {
+	Name  string
+	CRC32 string
+}
+
+type joinScript struct {
+	Archive string
+	Dir     string
+	Parts   []joinPart
+	Entries []joinedEntry
+}
+
+var joinShTemplate = template.Must(template.New("join.sh").Funcs(
+	template.FuncMap{"quote": shellQuote}).Parse(`#!/bin/sh
+# Extracts all parts of {{.Archive}} into {{.Dir}} and checks the files,
+# run from the directory holding the parts.
+set -e
+
+sum() {
+	if command -v sha256sum >/dev/null 2>&1; then
+		sha256sum "$1"
+	else
+		shasum -a 256 "$1"
+	fi | cut -d ' ' -f 1
+}
+
+check() {
+	if [ "$(sum "$1")" != "$2" ]; then
+		echo "$1 is damaged." >&2
+		exit 1
+	fi
+}
+
+# The CRC32 of a file is in the gzip trailer, least significant byte
+# first.
+crc() {
+	gzip -1 -c <"$1" | tail -c 8 | od -An -tx1 -N4 | {
+		read a b c d
+		echo "$d$c$b$a"
+	}
+}
+
+entry() {
+	if [ "$(crc {{quote .Dir}}/"$1")" != "$2" ]; then
+		echo "$1 does not match its CRC." >&2
+		exit 1
+	fi
+}
+{{range .Parts}}
+check {{quote .Name}} {{.SHA256}}{{end}}
+
+mkdir -p {{quote .Dir}}
+{{- range .Parts}}
+unzip -o -q {{quote .Name}} -d {{quote $.Dir}}{{end}}
+{{range .Entries}}
+entry {{quote .Name}} {{.CRC32}}{{end}}
+
+echo "Extracted "{{quote .Archive}}" into "{{quote .Dir}}.
+`))
+
+// The part of join.cmd after the marker is run by PowerShell, which
+// can compute a CRC32 with a bit of C#.
+var joinCmdTemplate = template.Must(template.New("join.cmd").Funcs(
+	template.FuncMap{"quote": powerShellQuote}).Parse(`@echo off
+rem Extracts all parts of {{.Archive}} into {{.Dir}} and checks the files,
+rem run from the directory holding the parts.
+setlocal
+{{range .Parts}}
+call :check "{{.Name}}" {{.SHA256}} || exit /b 1{{end}}
+
+mkdir "{{.Dir}}" 2>nul
+{{- range .Parts}}
+tar -xf "{{.Name}}" -C "{{$.Dir}}" || exit /b 1{{end}}
+
+powershell -NoProfile -ExecutionPolicy Bypass -Command "$s = Get-Content -Raw -LiteralPath '%~f0'; Invoke-Expression $s.Substring($s.IndexOf('#entry' + 'crcs'))" || exit /b 1
+echo Extracted {{.Archive}} into {{.Dir}}.
+exit /b 0
+
+:check
+certutil -hashfile %1 SHA256 | findstr /i /x "%2" >nul || (echo %~1 is damaged. & exit /b 1)
+exit /b 0
+
+#entrycrcs
+Add-Type -TypeDefinition @"
+public static class EntryCRC {
+    public static string Sum(string path) {
+        uint[] table = new uint[256];
+        for (uint n = 0; n < 256; n++) {
+            uint c = n;
+            for (int k = 0; k < 8; k++) {
+                c = (c & 1) != 0 ? 0xedb88320 ^ (c >> 1) : c >> 1;
+            }
+            table[n] = c;
+        }
+
+        uint crc = 0xffffffff;
+        byte[] buf = new byte[65536];
+        using (var f = System.IO.File.OpenRead(path)) {
+            int len;
+            while ((len = f.Read(buf, 0, buf.Length)) > 0) {
+                for (int i = 0; i < len; i++) {
+                    crc = table[(crc ^ buf[i]) & 0xff] ^ (crc >> 8);
+                }
+            }
+        }
+        return (~crc).ToString("x8");
+    }
+}
+"@
+
+function Check-Entry($name, $crc) {
+    $path = Join-Path {{quote .Dir}} $name
+    if ([EntryCRC]::Sum((Resolve-Path -LiteralPath $path).Path) -ne $crc) {
+        Write-Host "$name does not match its CRC."
+        exit 1
+    }
+}
+{{range .Entries}}
+Check-Entry {{quote .Name}} {{.CRC32}}{{end}}
+`))
+
+// Quote a string for the shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Quote a string for PowerShell.
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// Return the SHA-256 digest of a file.
+func fileDigest(name string) (string, error) {
+	return hashFile(name, sha256.New())
+}
+
+// Return the digest of a file in hex, using the given hash.
+func hashFile(name string, h hash.Hash) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Write join.sh and join.cmd next to the parts, which extract them all
+// into one directory and check the extracted files against the CRC32s
+// of the manifest, using only tools that come with the OS. They don't
+// rebuild the source archive itself, zipsplit merge does that.
+func makeJoinScripts(buckets []*Bucket, config Config) error {
+	archive := path.Base(filepath.ToSlash(config.sourceArchive))
+	script := joinScript{
+		Archive: archive,
+		Dir:     strings.TrimSuffix(archive, path.Ext(archive))}
+	if script.Dir == archive {
+		script.Dir += ".d"
+	}
+
+	var dir string
+	for _, bucket := range buckets {
+		name, err := partPath(bucket.Name, config)
+		if err != nil {
+			return err
+		}
+		digest, err := fileDigest(name)
+		if err != nil {
+			return err
+		}
+
+		dir = filepath.Dir(name)
+		script.Parts = append(script.Parts,
+			joinPart{filepath.Base(name), digest})
+
+		// Chunks are extracted as pieces, and links as what they
+		// point to, so neither has the CRC32 of the entry.
+		for _, file := range bucket.Files {
+			if _, chunk := bucket.Chunks[file]; chunk ||
+				strings.HasSuffix(file.Name, "/") ||
+				file.Mode()&fs.ModeSymlink != 0 {
+				continue
+			}
+			script.Entries = append(script.Entries, joinedEntry{file.Name,
+				fmt.Sprintf("%08x", file.CRC32)})
+		}
+	}
+
+	var sh strings.Builder
+	if err := joinShTemplate.Execute(&sh, script); err != nil {
+		return err
+	}
+	err := os.WriteFile(filepath.Join(dir, "join.sh"), []byte(sh.String()), 0755)
+	if err != nil {
+		return err
+	}
+
+	var cmd strings.Builder
+	if err := joinCmdTemplate.Execute(&cmd, script); err != nil {
+		return err
+	}
+	crlf := strings.ReplaceAll(cmd.String(), "\n", "\r\n")
+
+	return os.WriteFile(filepath.Join(dir, "join.cmd"), []byte(crlf), 0644)
+}
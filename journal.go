@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A part as it was written, for the journal.
+type journalPart struct {
+	Name   string `json:"name"`
+	Size   uint64 `json:"size"`
+	CRC32  uint32 `json:"crc32"`
+	SHA256 string `json:"sha256"`
+}
+
+// The parts written so far in this run.
+var writtenParts []journalPart
+
+// What the journal remembers of a run, one per line.
+type journalRecord struct {
+	Time           time.Time     `json:"time"`
+	Source         string        `json:"source"`
+	Args           []string      `json:"args"`
+	Manifest       string        `json:"manifest,omitempty"`
+	ManifestSHA256 string        `json:"manifest_sha256,omitempty"`
+	Parts          []journalPart `json:"parts"`
+}
+
+// Return where the journal is kept by default, following the XDG base
+// directories, or nothing when there is no home to keep it in.
+func defaultJournal() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+
+	return filepath.Join(dir, "zipsplit", "history.jsonl")
+}
+
+// Flags whose values are secrets, which are never recorded.
+var secretFlags = map[string]bool{
+	"password":     true,
+	"out-password": true,
+}
+
+// Return the arguments of the run without the secret flags and their
+// values, as far as the journal is concerned.
+func journalArgs(args []string) []string {
+	kept := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(kept, args[i:]...)
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || !secretFlags[name] {
+			kept = append(kept, arg)
+			continue
+		}
+		if !hasValue {
+			i++
+		}
+	}
+
+	return kept
+}
+
+// Return a name which still means the same file when read from
+// elsewhere later on.
+func journalName(name string) string {
+	if isRemote(name) || name == stdinName {
+		return name
+	}
+
+	source, members := splitMemberPath(name)
+	abs, err := filepath.Abs(source)
+	if err != nil {
+		return name
+	}
+	for _, member := range members {
+		abs += memberSeparator + member
+	}
+
+	return abs
+}
+
+// Append a record of this run to the journal.
+func writeJournal(name string, config Config) error {
+	record := journalRecord{
+		Time:   config.startTime.UTC(),
+		Source: journalName(config.sourceArchive),
+		Args:   journalArgs(os.Args[1:]),
+		Parts:  []journalPart{}}
+
+	for _, part := range writtenParts {
+		if filename, err := partPath(part.Name, config); err == nil {
+			part.Name = journalName(filename)
+		}
+		record.Parts = append(record.Parts, part)
+	}
+
+	if config.manifestName != "" {
+		digest, err := fileDigest(config.manifestName)
+		if err != nil {
+			return err
+		}
+		record.Manifest = journalName(config.manifestName)
+		record.ManifestSHA256 = digest
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// What was split where is nobody else's business.
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Record the run when keeping a journal. Losing the record is not
+// worth failing a finished split over.
+func saveJournal(name string, config Config) {
+	if name == "" {
+		return
+	}
+	if err := writeJournal(name, config); err != nil {
+		warnings.Printf("Not recorded in the journal: %v", err)
+	}
+}
+
+// Report whether a run involved a file whose base name matches the
+// pattern, either as its source or as one of its parts.
+func (record *journalRecord) matches(pattern string) bool {
+	names := []string{record.Source, record.Manifest}
+	for _, part := range record.Parts {
+		names = append(names, part.Name)
+	}
+
+	for _, name := range names {
+		base := path.Base(filepath.ToSlash(name))
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Show the runs recorded in the journal, optionally only those
+// involving a given source or part.
+func historyCommand(args []string) {
+	flags := flag.NewFlagSet("history", flag.ExitOnError)
+
+	journal := flags.String(
+		"journal",
+		defaultJournal(),
+		"The journal to read.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Also show the parts of every run with their checksums.")
+
+	flags.Parse(args)
+
+	if flags.NArg() > 1 || *journal == "" {
+		fatal(errors.New(
+			"Usage: zipsplit history [-journal history.jsonl] [-v] [pattern]"))
+	}
+	pattern := "*"
+	if flags.NArg() == 1 {
+		pattern = flags.Arg(0)
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		fatalf("Invalid pattern %q.", pattern)
+	}
+
+	f, err := os.Open(*journal)
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+
+	found := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var record journalRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			warnings.Printf("%s:%d: %v", *journal, line, err)
+			continue
+		}
+		if !record.matches(pattern) {
+			continue
+		}
+		found = true
+
+		fmt.Printf("%s\t%s\t%d parts\n",
+			record.Time.Local().Format(time.DateTime), record.Source,
+			len(record.Parts))
+		if *verbose {
+			fmt.Printf("\tzipsplit %s\n", strings.Join(record.Args, " "))
+			for _, part := range record.Parts {
+				fmt.Printf("\t%s\t%s\t%s\n", part.Name,
+					numberToHuman(part.Size), part.SHA256)
+			}
+			if record.Manifest != "" {
+				fmt.Printf("\t%s\t\t%s\n", record.Manifest,
+					record.ManifestSHA256)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal(err)
+	}
+
+	if !found {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/ascheepe/zipsplit/manifest"
+)
+
+// An entry of a split set and the part it is in.
+type setEntry struct {
+	manifest.Entry
+	part string
+}
+
+// List every entry of a split set as if it were one archive.
+func listCommand(args []string) {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Read the entries from this manifest instead of the parts.")
+
+	flags.Parse(args)
+
+	if *manifestName == "" && flags.NArg() == 0 {
+		fatal(errors.New(
+			"Usage: zipsplit list [-manifest manifest.json] [part...]"))
+	}
+
+	entries, err := setEntries(*manifestName, flags.Args())
+	if err != nil {
+		fatal(err)
+	}
+
+	printEntries(entries)
+}
+
+// Return the entries of a split set, from its manifest when given and
+// otherwise from the central directories of the parts.
+func setEntries(manifestName string, parts []string) ([]setEntry, error) {
+	var entries []setEntry
+
+	if manifestName != "" {
+		m, err := manifest.Load(manifestName)
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range m.Parts {
+			for _, entry := range part.Entries {
+				entries = append(entries, setEntry{entry, part.Name})
+			}
+		}
+
+		return entries, nil
+	}
+
+	for _, part := range parts {
+		r, err := zip.OpenReader(part)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range r.File {
+			entries = append(entries,
+				setEntry{manifestEntry(&f.FileHeader), part})
+		}
+		r.Close()
+	}
+
+	return entries, nil
+}
+
+func printEntries(entries []setEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  Compressed\t        Size\tCRC-32\tPart\tName")
+
+	var compressed, uncompressed uint64
+	for _, entry := range entries {
+		fmt.Fprintf(w, "%12d\t%12d\t%08x\t%s\t%s\n",
+			entry.CompressedSize,
+			entry.UncompressedSize,
+			entry.CRC32,
+			entry.part,
+			entry.Name)
+		compressed += entry.CompressedSize
+		uncompressed += entry.UncompressedSize
+	}
+
+	fmt.Fprintf(w, "%12d\t%12d\t\t\t%d entries\n",
+		compressed, uncompressed, len(entries))
+	w.Flush()
+}
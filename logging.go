@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Log targets.
+const (
+	logStderr = "stderr"
+	logSyslog = "syslog"
+)
+
+// Progress messages go here, warnings to the warnings logger and
+// failures to the standard logger. Normally that is stdout and stderr.
+var (
+	progress io.Writer = os.Stdout
+	warnings           = log.Default()
+)
+
+// How much is said about the process, picked with -q, -v and -debug.
+const (
+	levelQuiet = iota
+	levelNormal
+	levelVerbose
+	levelDebug
+)
+
+var logLevel = levelNormal
+
+// Set how much is said. Being quiet leaves out progress and warnings,
+// but failures are always logged.
+func setLogLevel(level int) {
+	logLevel = level
+	if level == levelQuiet {
+		progress = io.Discard
+		warnings = log.New(io.Discard, "", 0)
+	}
+}
+
+// Explain a decision, when debugging.
+func debugf(format string, args ...any) {
+	if logLevel >= levelDebug {
+		fmt.Fprintf(progress, format+"\n", args...)
+	}
+}
+
+// Passes complete lines to a logging function, so messages written in
+// pieces end up as a single record.
+type lineWriter struct {
+	logLine func(string) error
+	buf     []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if err := w.logLine(string(w.buf[:i])); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[i+1:]
+	}
+}
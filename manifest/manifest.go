@@ -0,0 +1,103 @@
+// Package manifest describes a split set: which parts were produced
+// from which source archive and which entries ended up in each part.
+package manifest
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Entry is an entry of a part, as it was written there. Entries cut
+// into chunks are listed as their chunks, each in the part holding it.
+type Entry struct {
+	Name             string `json:"name"`
+	CRC32            uint32 `json:"crc32"`
+	CompressedSize   uint64 `json:"compressed_size"`
+	UncompressedSize uint64 `json:"uncompressed_size"`
+
+	// Digest of the uncompressed data, using the hash named in the
+	// manifest.
+	Digest string `json:"digest,omitempty"`
+
+	// Where the data of the entry starts in the source archive, when
+	// it was copied from there as-is.
+	Offset int64 `json:"offset,omitempty"`
+}
+
+// Part is one of the archives of the split set and the entries it
+// holds, in the order they were written. Name is the path it was
+// written to.
+type Part struct {
+	Name string `json:"name"`
+
+	// Size and CRC32 of the part file as written.
+	Size  uint64 `json:"size,omitempty"`
+	CRC32 uint32 `json:"crc32,omitempty"`
+
+	Entries []Entry `json:"entries"`
+}
+
+// An entry of the source archive whose data was found to be damaged,
+// either left out of the split set or copied as-is.
+type Damaged struct {
+	Name    string `json:"name"`
+	Error   string `json:"error"`
+	Skipped bool   `json:"skipped"`
+}
+
+// Manifest is a split set as written to a manifest file, with the
+// settings it was split with and its parts in order. The JSON names
+// of the fields are the file format, which other tools read too.
+type Manifest struct {
+	Source       string    `json:"source"`
+	SplitSize    uint64    `json:"split_size,omitempty"`
+	Measure      string    `json:"measure,omitempty"`
+	NameTemplate string    `json:"name_template,omitempty"`
+	Hash         string    `json:"hash,omitempty"`
+	SetID        string    `json:"set_id,omitempty"`
+	Parts        []Part    `json:"parts"`
+	Damaged      []Damaged `json:"damaged,omitempty"`
+
+	// Archives whose entries were added to the split set later.
+	Appended []string `json:"appended,omitempty"`
+}
+
+// Load reads a manifest from a file.
+func Load(filename string) (*Manifest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// Save writes the manifest to a file as indented JSON, replacing it
+// when it exists.
+func (m *Manifest) Save(filename string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(data, '\n'), 0666)
+}
+
+// Find the part holding the named entry, returning the indexes of the
+// part and of the entry within it.
+func (m *Manifest) Find(name string) (int, int, bool) {
+	for i, part := range m.Parts {
+		for j, entry := range part.Entries {
+			if entry.Name == name {
+				return i, j, true
+			}
+		}
+	}
+
+	return 0, 0, false
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// Return how many entries an item stands for.
+func itemEntries(item pack.Item) int {
+	if group, ok := item.(*zipGroup); ok {
+		return len(group.items)
+	}
+
+	return 1
+}
+
+// Break up the groups with more entries than a part can hold.
+func breakUpGroups(items []pack.Item, maxFiles int) ([]pack.Item, error) {
+	var split []pack.Item
+	for _, item := range items {
+		group, ok := item.(*zipGroup)
+		if !ok || len(group.items) <= maxFiles {
+			split = append(split, item)
+			continue
+		}
+		if group.leading {
+			return nil, withStatus(exitTooLarge,
+				errors.New("Can never fit the leading entries."))
+		}
+		for _, groupItem := range group.items {
+			split = append(split, groupItem)
+		}
+	}
+
+	return split, nil
+}
+
+// Wrap a strategy to put at most maxFiles entries in a bin. What the
+// strategy put in a bin beyond that is packed again, the same way,
+// into bins of its own.
+func limitFiles(strategy pack.Strategy, maxFiles int) pack.Strategy {
+	var limited pack.Strategy
+	limited = func(items []pack.Item, capacity uint64) ([]*pack.Bin, error) {
+		split, err := breakUpGroups(items, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		bins, err := strategy(split, capacity)
+		if err != nil {
+			return nil, err
+		}
+
+		var overflow []pack.Item
+		for _, bin := range bins {
+			var kept []pack.Item
+			var size uint64
+			count := 0
+			for _, item := range bin.Items {
+				n := itemEntries(item)
+				if count+n > maxFiles {
+					overflow = append(overflow, item)
+					continue
+				}
+				kept = append(kept, item)
+				size += item.Size()
+				count += n
+			}
+			bin.Items, bin.Size = kept, size
+		}
+
+		if len(overflow) == 0 {
+			return bins, nil
+		}
+
+		more, err := limited(overflow, capacity)
+		if err != nil {
+			return nil, err
+		}
+
+		return append(bins, more...), nil
+	}
+
+	return limited
+}
+
+// Return a strategy packing the items in their original order, with at
+// most maxFiles entries in a bin. A new bin is started when the next
+// item doesn't fit in the last one, or would take it past the limit.
+func nextFitFiles(maxFiles int) pack.Strategy {
+	return func(items []pack.Item, capacity uint64) ([]*pack.Bin, error) {
+		split, err := breakUpGroups(items, maxFiles)
+		if err != nil {
+			return nil, err
+		}
+
+		var bins []*pack.Bin
+		var last *pack.Bin
+		count := 0
+		for _, item := range split {
+			if item.Size() > capacity {
+				return nil, pack.ErrTooLarge
+			}
+
+			n := itemEntries(item)
+			if last == nil || last.Size+item.Size() > capacity ||
+				count+n > maxFiles {
+				last = &pack.Bin{}
+				bins = append(bins, last)
+				count = 0
+			}
+			last.Size += item.Size()
+			last.Items = append(last.Items, item)
+			count += n
+		}
+
+		return bins, nil
+	}
+}
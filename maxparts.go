@@ -0,0 +1,102 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"math"
+
+	"github.com/ascheepe/zipsplit/pack"
+)
+
+// Fit the files into at most maxParts parts, growing the split size to
+// the smallest that allows it. Returns the buckets and the split size
+// used.
+func fitWithin(files []*zip.FileHeader, maxParts int,
+	config Config) ([]*Bucket, uint64, error) {
+
+	fits := func(size uint64) ([]*Bucket, bool) {
+		sized := config
+		sized.splitSize = size
+		buckets, err := fit(files, sized)
+		return buckets, err == nil && len(buckets) <= maxParts
+	}
+
+	if buckets, ok := fits(config.splitSize); ok {
+		return buckets, config.splitSize, nil
+	}
+
+	// Everything in a single part should always fit; leave some room
+	// for the estimate being off.
+	hi := partOverhead(config)
+	for _, file := range files {
+		hi += entrySize(file, config)
+	}
+	hi = max(hi+hi/100, config.splitSize)
+	if config.maxFileSize != 0 {
+		hi = min(hi, config.maxFileSize)
+	}
+
+	best, ok := fits(hi)
+	if !ok {
+		return nil, 0, fmt.Errorf("Cannot fit the source in %d parts.",
+			maxParts)
+	}
+
+	// Sizes up to lo are known not to fit, hi is known to.
+	lo := config.splitSize
+	for lo+1 < hi {
+		mid := lo + (hi-lo)/2
+		if buckets, ok := fits(mid); ok {
+			best, hi = buckets, mid
+		} else {
+			lo = mid
+		}
+	}
+
+	return best, hi, nil
+}
+
+// Spread the files evenly over exactly the given number of parts.
+// Returns the buckets and the split size this takes.
+func fitParts(files []*zip.FileHeader, parts int,
+	config Config) ([]*Bucket, uint64, error) {
+
+	newZipName, err := partNamer(config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	items, leading, err := packItems(files, math.MaxUint64, config)
+	if err != nil {
+		return nil, 0, err
+	}
+	if leading != nil {
+		items = append(items, leading)
+	}
+	if len(items) < parts {
+		return nil, 0, fmt.Errorf("Cannot make %d parts of %d entries.",
+			parts, len(items))
+	}
+
+	bins := pack.Balance(items, parts)
+	moveLeadingFirst(bins)
+
+	var splitSize uint64
+	for _, bin := range bins {
+		splitSize = max(splitSize, bin.Size+partOverhead(config))
+	}
+
+	// Larger parts can need more room for zip64 records, so work it
+	// out again for parts of this size.
+	if splitSize > config.splitSize {
+		config.splitSize = splitSize
+		return fitParts(files, parts, config)
+	}
+
+	if config.maxFileSize != 0 && splitSize > config.maxFileSize {
+		return nil, 0, fmt.Errorf("Cannot fit the source in %d parts.",
+			parts)
+	}
+
+	return binsToBuckets(bins, newZipName), splitSize, nil
+}
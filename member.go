@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Sources may name a zip inside other archives, like
+// backups.tar#nested/archive.zip, each # going one archive deeper.
+const memberSeparator = "#"
+
+// Split a source name into the outer archive and the members leading
+// to the zip to split. A local file whose name happens to contain the
+// separator is taken as it is.
+func splitMemberPath(name string) (string, []string) {
+	if !strings.Contains(name, memberSeparator) || !isRemote(name) &&
+		fileExists(name) {
+		return name, nil
+	}
+
+	fields := strings.Split(name, memberSeparator)
+	return fields[0], fields[1:]
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Closes a number of things in reverse order.
+type closers []io.Closer
+
+func (c closers) Close() error {
+	var err error
+	for i := len(c) - 1; i >= 0; i-- {
+		if closeErr := c[i].Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+// Open a member of a tar or zip archive for random access. Members
+// stored as they are are read in place; compressed zip members are
+// decompressed to a temporary file first, returned as the closer.
+func openMember(r io.ReaderAt, size int64,
+	name string) (io.ReaderAt, int64, io.Closer, error) {
+
+	switch format := detectFormat(r); format {
+	case "tar":
+		return openTarMember(r, size, name)
+	case "zip":
+		return openZipMember(r, size, name)
+	case "":
+		return nil, 0, nil, fmt.Errorf("%s: unknown container.", name)
+	default:
+		return nil, 0, nil, fmt.Errorf(
+			"%s: detected %s, expected tar or zip container.", name, format)
+	}
+}
+
+// Counts the bytes read, to tell where a tar member starts or whether
+// a file changed size.
+type countReader struct {
+	r     io.Reader
+	count int64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func openTarMember(r io.ReaderAt, size int64,
+	name string) (io.ReaderAt, int64, io.Closer, error) {
+
+	cr := &countReader{r: io.NewSectionReader(r, 0, size)}
+	tr := tar.NewReader(cr)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, 0, nil, fmt.Errorf("%s: not found.", name)
+		}
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != name {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			return nil, 0, nil, fmt.Errorf("%s: not a regular file.", name)
+		}
+
+		// The tar reader has read up to the data of the member.
+		section := io.NewSectionReader(r, cr.count, hdr.Size)
+		return section, hdr.Size, closers(nil), nil
+	}
+}
+
+func openZipMember(r io.ReaderAt, size int64,
+	name string) (io.ReaderAt, int64, io.Closer, error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+
+		if f.Method == zip.Store {
+			offset, err := f.DataOffset()
+			if err != nil {
+				return nil, 0, nil, err
+			}
+			size := int64(f.UncompressedSize64)
+			return io.NewSectionReader(r, offset, size), size, closers(nil), nil
+		}
+
+		return extractMember(f)
+	}
+
+	return nil, 0, nil, fmt.Errorf("%s: not found.", name)
+}
+
+// Decompress a zip member into a temporary file removed when closed.
+func extractMember(f *zip.File) (io.ReaderAt, int64, io.Closer, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	extracted := tempFile{tmp}
+
+	size, err := io.Copy(extracted, rc)
+	if err != nil {
+		extracted.Close()
+		return nil, 0, nil, fmt.Errorf("%s: %v", f.Name, err)
+	}
+
+	return extracted, size, extracted, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Rough amounts of memory used, to stay within -max-memory.
+const (
+	// A decompressor, its window and the copy buffer.
+	checkWorkerMemory = 256 * KByte
+
+	// The same for a cpio part, or the buffers of a zip part and its
+	// destination.
+	partWorkerMemory = 256 * KByte
+
+	// A compressor and the entries waiting to be written, of which
+	// there are two for every worker.
+	recompressWorkerMemory = MByte + 2*maxBufferedEntry
+
+	// The zip.File, the planner item and the manifest entry
+	// besides the names and extra fields.
+	entryMemory = 512
+)
+
+// Make the garbage collector work harder as memory use approaches the
+// limit, rather than growing the heap beyond it.
+func applyMemoryLimit(config Config) {
+	if config.maxMemory > 0 {
+		debug.SetMemoryLimit(int64(config.maxMemory))
+	}
+}
+
+// Return how many entries to check at the same time. Remote sources
+// are read sequentially as concurrent reads would keep interrupting
+// each others streams.
+func checkWorkers(config Config) int {
+	workers := runtime.GOMAXPROCS(0)
+	if isRemote(config.sourceArchive) {
+		return 1
+	}
+
+	if config.maxMemory > 0 {
+		// Leave half for everything else.
+		workers = min(workers, int(config.maxMemory/2/checkWorkerMemory))
+	}
+
+	return max(workers, 1)
+}
+
+// Return how many parts to write, or entries to recompress, at the
+// same time within the memory limit.
+func jobsWithinMemory(config Config) int {
+	if config.maxMemory == 0 {
+		return config.jobs
+	}
+
+	perJob := uint64(partWorkerMemory)
+	if config.recompress {
+		perJob = recompressWorkerMemory
+	}
+
+	// Leave half for everything else.
+	return max(1, min(config.jobs, int(config.maxMemory/2/perJob)))
+}
+
+// Estimate the memory needed to plan the split and refuse to start
+// when it won't fit in the limit.
+func checkPlanningMemory(files []*zip.File, config Config) error {
+	if config.maxMemory == 0 {
+		return nil
+	}
+
+	var needed uint64
+	for _, f := range files {
+		needed += entryMemory +
+			uint64(len(f.Name)+len(f.Extra)+len(f.Comment))
+	}
+
+	if needed > config.maxMemory {
+		return fmt.Errorf("Planning %d entries needs about %s, more "+
+			"than the %s memory limit.", len(files),
+			numberToHuman(needed), numberToHuman(config.maxMemory))
+	}
+
+	return nil
+}
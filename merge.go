@@ -0,0 +1,185 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Put the parts of a split set back together into one archive.
+func mergeCommand(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+
+	output := flags.String(
+		"o",
+		"",
+		"Write the merged archive to this file.")
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Check against this manifest that no entries are missing.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Show some information about the process.")
+
+	flags.Parse(args)
+
+	if *output == "" || flags.NArg() == 0 {
+		fatal(errors.New(
+			"Usage: zipsplit merge -o combined.zip [-manifest manifest.json] part..."))
+	}
+
+	var m *manifest.Manifest
+	if *manifestName != "" {
+		var err error
+		if m, err = manifest.Load(*manifestName); err != nil {
+			fatal(err)
+		}
+	}
+
+	if err := mergeParts(*output, flags.Args(), m, *verbose); err != nil {
+		os.Remove(*output)
+		fatal(err)
+	}
+}
+
+// Copy the entries of all parts into one archive, putting entries cut
+// into chunks back together. An entry found twice is an error, as is
+// one of the manifest which is not found at all.
+func mergeParts(output string, names []string, m *manifest.Manifest,
+	verbose bool) error {
+
+	var parts []*zip.ReadCloser
+	defer func() {
+		for _, part := range parts {
+			part.Close()
+		}
+	}()
+	for _, name := range names {
+		part, err := zip.OpenReader(name)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, part)
+	}
+
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	w := split.NewWriter(f)
+
+	if err := mergeEntries(w, names, parts, m, verbose); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func mergeEntries(w *split.Writer, names []string, parts []*zip.ReadCloser,
+	m *manifest.Manifest, verbose bool) error {
+
+	// Where every entry, or chunk, was found.
+	found := make(map[string]string)
+	chunks := make(map[string][]foundChunk)
+
+	// Directory entries parts were given to make the tree when
+	// extracted on their own are in several of them.
+	dirs := make(map[string]*zip.FileHeader)
+
+	for i, part := range parts {
+		if verbose {
+			fmt.Fprintf(progress, "Merging %s..", names[i])
+		}
+
+		for _, f := range part.File {
+			if where, ok := found[f.Name]; ok {
+				if dir, ok := dirs[f.Name]; ok && sameDir(dir, &f.FileHeader) {
+					continue
+				}
+				return fmt.Errorf("%s is in both %s and %s.", f.Name,
+					where, names[i])
+			}
+			found[f.Name] = names[i]
+			if isDirEntry(&f.FileHeader) {
+				dirs[f.Name] = &f.FileHeader
+			}
+
+			if name, chunk, ok := chunkOf(f); ok {
+				chunks[name] = append(chunks[name], chunk)
+				continue
+			}
+			if err := split.CopyEntry(w, f, 0); err != nil {
+				return fmt.Errorf("%s: %s: %v", names[i], f.Name, err)
+			}
+		}
+
+		if verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+	}
+
+	var chunked []string
+	for name := range chunks {
+		if where, ok := found[name]; ok {
+			return fmt.Errorf("%s is in %s and cut into chunks.", name,
+				where)
+		}
+		chunked = append(chunked, name)
+	}
+	sort.Strings(chunked)
+
+	for _, name := range chunked {
+		if verbose {
+			fmt.Fprintf(progress, "Joining %s..", name)
+		}
+		if err := joinEntry(w.Writer, name, chunks[name]); err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+	}
+
+	if m == nil {
+		return nil
+	}
+
+	missing := 0
+	for _, part := range m.Parts {
+		for _, entry := range part.Entries {
+			if _, ok := found[entry.Name]; !ok {
+				warnings.Printf("%s from %s is missing.", entry.Name,
+					part.Name)
+				missing++
+			}
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%d entries are missing.", missing)
+	}
+
+	return nil
+}
+
+// Report whether two directory entries of the same name are copies of
+// each other.
+func sameDir(a, b *zip.FileHeader) bool {
+	return a.Mode() == b.Mode() && a.Modified.Equal(b.Modified) &&
+		a.CompressedSize64 == 0 && b.CompressedSize64 == 0
+}
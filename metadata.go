@@ -0,0 +1,75 @@
+package main
+
+import (
+	"archive/zip"
+	"io/fs"
+	"time"
+
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// The extra field WinZip AES keeps the real compression method in.
+const aesExtraID = 0x9901
+
+// The extended timestamp extra field, with Unix times in UTC.
+const extTimeExtraID = 0x5455
+
+// Extra fields which hold the times of an entry: the extended
+// timestamp, NTFS times and the old Info-ZIP Unix field.
+var timeExtraIDs = map[uint16]bool{
+	extTimeExtraID: true,
+	0x000a:         true,
+	0x5855:         true,
+}
+
+// The earliest time a zip entry can have.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Give an entry the given time, in the MS-DOS fields only, leaving out
+// the extra fields with times of their own.
+func setEntryTime(file *zip.FileHeader, t time.Time) {
+	t = t.UTC()
+	if t.Before(zipEpoch) {
+		t = zipEpoch
+	}
+
+	file.Modified = time.Time{}
+	file.ModifiedDate = uint16((t.Year()-1980)<<9 | int(t.Month())<<5 |
+		t.Day())
+	file.ModifiedTime = uint16(t.Hour()<<11 | t.Minute()<<5 |
+		t.Second()/2)
+	file.Extra = filterExtra(file.Extra, func(id uint16) bool {
+		return !timeExtraIDs[id]
+	})
+}
+
+// Strip what the entries tell about where they come from besides their
+// names and data: comments, extra fields, times and all permissions
+// but whether they can be executed. Like renaming unsafe paths this
+// changes the shared headers, so the entries are written without it.
+func stripMetadata(files []*zip.FileHeader) {
+	for _, file := range files {
+		file.Comment = ""
+		file.Extra = filterExtra(file.Extra, func(id uint16) bool {
+			return id == aesExtraID
+		})
+
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			file.SetMode(fs.ModeDir | 0755)
+		case mode&fs.ModeSymlink != 0:
+			file.SetMode(fs.ModeSymlink | 0777)
+		case mode&0111 != 0:
+			file.SetMode(0755)
+		default:
+			file.SetMode(0644)
+		}
+
+		// Entries encrypted the traditional way can have their time
+		// checked by the password.
+		if !zipcrypt.IsEncrypted(file) {
+			setEntryTime(file, zipEpoch)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Progress display formats.
+const (
+	progressNone  = "none"
+	progressBar   = "bar"
+	progressPlain = "plain"
+	progressJSON  = "json"
+)
+
+// Width of the bar itself, between the brackets.
+const barWidth = 30
+
+// How much of the split set has been written, shown on stderr while
+// the parts are written, or nil when not asked for.
+var meter *progressMeter
+
+type progressMeter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format string
+	start  time.Time
+	shown  time.Time
+
+	// Bytes to write in all, or 0 when not known up front.
+	total  uint64
+	copied uint64
+	part   string
+
+	// Length of the last bar, to blank out what is left of it.
+	width int
+}
+
+// A progress report in the json format.
+type progressReport struct {
+	Part       string  `json:"part"`
+	Copied     uint64  `json:"copied"`
+	Total      uint64  `json:"total,omitempty"`
+	Percent    float64 `json:"percent,omitempty"`
+	ETASeconds int64   `json:"eta_seconds,omitempty"`
+}
+
+// Start showing progress in the given format, towards a total number
+// of bytes which may be 0 when it isn't known.
+func startMeter(format string, total uint64) {
+	if format == progressNone {
+		return
+	}
+
+	meter = &progressMeter{
+		out:    os.Stderr,
+		format: format,
+		start:  time.Now(),
+		total:  total}
+}
+
+// Count bytes written to a part.
+func (m *progressMeter) add(part string, n int) {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.copied += uint64(n)
+	m.part = part
+
+	interval := time.Second
+	if m.format == progressBar {
+		interval = 100 * time.Millisecond
+	}
+	if now := time.Now(); now.Sub(m.shown) >= interval {
+		m.shown = now
+		m.show()
+	}
+}
+
+// Show the final state, once all parts are written.
+func (m *progressMeter) finish() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The total was only an estimate.
+	m.total = m.copied
+	m.show()
+	if m.format == progressBar {
+		fmt.Fprintln(m.out)
+	}
+}
+
+// Return the estimated time left, or a negative duration when there
+// is nothing to base it on, or nothing left.
+func (m *progressMeter) eta() time.Duration {
+	if m.total == 0 || m.copied == 0 || m.copied >= m.total {
+		return -1
+	}
+
+	elapsed := time.Since(m.start)
+	left := float64(m.total - m.copied)
+
+	return time.Duration(float64(elapsed) * left /
+		float64(m.copied)).Round(time.Second)
+}
+
+func (m *progressMeter) percent() float64 {
+	if m.total == 0 {
+		return 0
+	}
+
+	return min(100, 100*float64(m.copied)/float64(m.total))
+}
+
+func (m *progressMeter) show() {
+	switch m.format {
+	case progressJSON:
+		report := progressReport{
+			Part:    m.part,
+			Copied:  m.copied,
+			Total:   m.total,
+			Percent: math.Round(m.percent()*10) / 10}
+		if eta := m.eta(); eta >= 0 {
+			report.ETASeconds = int64(eta / time.Second)
+		}
+		json.NewEncoder(m.out).Encode(report)
+
+	case progressPlain:
+		fmt.Fprintln(m.out, m.describe())
+
+	case progressBar:
+		filled := int(m.percent() * barWidth / 100)
+		line := fmt.Sprintf("[%s%s] %s", strings.Repeat("#", filled),
+			strings.Repeat(" ", barWidth-filled), m.describe())
+		pad := max(0, m.width-len(line))
+		m.width = len(line)
+		fmt.Fprintf(m.out, "\r%s%s", line, strings.Repeat(" ", pad))
+	}
+}
+
+// Describe the progress in a line of text.
+func (m *progressMeter) describe() string {
+	if m.total == 0 {
+		return fmt.Sprintf("%s %s", numberToHuman(m.copied), m.part)
+	}
+
+	line := fmt.Sprintf("%3.0f%% %s/%s %s", m.percent(),
+		numberToHuman(m.copied), numberToHuman(m.total), m.part)
+	if eta := m.eta(); eta >= 0 {
+		line += " ETA " + eta.String()
+	}
+
+	return line
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// A variable of a part name template, like {num} or {num:03}, with a
+// width after the colon.
+var nameVariable = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+// The variables of a part name template.
+const (
+	nameNum    = "num"
+	nameTotal  = "total"
+	nameSource = "source"
+	nameDate   = "date"
+	nameSize   = "size"
+	nameSHA256 = "sha256"
+)
+
+// What is known about a part to name it by, zero when not known yet.
+type nameValues struct {
+	num    int
+	total  int
+	size   uint64
+	digest string
+}
+
+// Report whether a name template has {variables} rather than being a
+// printf one.
+func isNameTemplate(template string) bool {
+	return nameVariable.MatchString(template)
+}
+
+// Report whether a name template has variables only known once the
+// parts are written.
+func namedByContents(template string) bool {
+	for _, match := range nameVariable.FindAllStringSubmatch(template, -1) {
+		if match[1] == nameSize || match[1] == nameSHA256 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check the variables of a name template, which has to tell the parts
+// apart. Streamed parts are named when they are started, before the
+// number of parts and their contents are known.
+func checkNameTemplate(template string, streaming bool) error {
+	distinct := false
+	for _, match := range nameVariable.FindAllStringSubmatch(template, -1) {
+		switch match[1] {
+		case nameNum, nameSHA256:
+			distinct = true
+		case nameTotal, nameSource, nameDate, nameSize:
+		default:
+			return fmt.Errorf("Unknown variable %s in the name template.",
+				match[0])
+		}
+		if streaming && match[1] != nameNum && match[1] != nameSource &&
+			match[1] != nameDate {
+			return errors.New("Streamed parts are named before they are " +
+				"complete, without {total}, {size} or {sha256}.")
+		}
+	}
+	if !distinct {
+		return errors.New("The name template needs {num} or {sha256} to " +
+			"tell the parts apart.")
+	}
+
+	return nil
+}
+
+// Fill in the variables of a name template which are known, leaving
+// the others for later.
+func renderName(template string, values nameValues, config Config) string {
+	return nameVariable.ReplaceAllStringFunc(template, func(variable string) string {
+		match := nameVariable.FindStringSubmatch(variable)
+		width, _ := strconv.Atoi(match[2])
+
+		switch {
+		case match[1] == nameNum && values.num > 0:
+			return fmt.Sprintf("%0*d", width, values.num)
+		case match[1] == nameTotal && values.total > 0:
+			return fmt.Sprintf("%0*d", width, values.total)
+		case match[1] == nameSize && values.size > 0:
+			return fmt.Sprintf("%0*d", width, values.size)
+		case match[1] == nameSHA256 && values.digest != "":
+			if width > 0 && width < len(values.digest) {
+				return values.digest[:width]
+			}
+			return values.digest
+		case match[1] == nameSource:
+			source := path.Base(config.sourceArchive)
+			return strings.TrimSuffix(source, path.Ext(source))
+		case match[1] == nameDate:
+			return config.entryTime.Format(time.DateOnly)
+		}
+
+		return variable
+	})
+}
+
+// Return a function giving the names of the parts in turn. Names with
+// {variables} only get the number of the part filled in here, along
+// with the source and date, the rest once all parts are planned.
+func partNamer(config Config) (func() string, error) {
+	if !isNameTemplate(config.nameTemplate) {
+		return split.Namer(config.nameTemplate, 1)
+	}
+
+	num := 0
+	return func() string {
+		num++
+		return renderName(config.nameTemplate, nameValues{num: num}, config)
+	}, nil
+}
+
+// Name the planned parts after a template with {variables}, leaving
+// their size and digest until they are written.
+func nameParts(buckets []*Bucket, config Config) {
+	if !isNameTemplate(config.nameTemplate) {
+		return
+	}
+
+	for i, bucket := range buckets {
+		bucket.num = i + 1
+		bucket.Name = renderName(config.nameTemplate,
+			nameValues{num: i + 1, total: len(buckets)}, config)
+	}
+}
+
+// Return the name to write a part as, which for parts named by their
+// contents stands in until they are complete.
+func (bucket *Bucket) writeName(config Config) string {
+	if !namedByContents(bucket.Name) {
+		return bucket.Name
+	}
+
+	return fmt.Sprintf("%s.%d.partial", bucket.Name, bucket.num)
+}
+
+// Give a part written as its stand-in name the name it has by its
+// size and digest.
+func (bucket *Bucket) nameByContents(written string, w io.WriteCloser,
+	config Config) error {
+
+	ph, ok := w.(*partHasher)
+	if !ok || written == bucket.Name {
+		return nil
+	}
+
+	name := renderName(bucket.Name, nameValues{size: ph.size,
+		digest: fmt.Sprintf("%x", ph.h.Sum(nil))}, config)
+	from, err := partPath(written, config)
+	if err != nil {
+		return err
+	}
+	to, err := partPath(name, config)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	bucket.Name = name
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// A temporary file removed when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+
+	return err
+}
+
+// Report whether an entry looks like a zip archive worth expanding.
+func isNestedArchive(f *zip.File) bool {
+	return !f.FileInfo().IsDir() &&
+		strings.EqualFold(path.Ext(f.Name), ".zip")
+}
+
+// Expand the zips inside the source, up to the given depth, into a
+// temporary archive where their entries are named after the archive
+// holding them, so they can be packed one by one.
+func expandArchives(r *zip.Reader, depth int,
+	config Config) (*zip.Reader, io.Closer, error) {
+
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	expanded := tempFile{f}
+
+	w := zip.NewWriter(expanded)
+	if err := expandInto(w, r, "", depth, config); err != nil {
+		expanded.Close()
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		expanded.Close()
+		return nil, nil, err
+	}
+
+	info, err := expanded.Stat()
+	if err != nil {
+		expanded.Close()
+		return nil, nil, err
+	}
+
+	er, err := zip.NewReader(expanded, info.Size())
+	if err != nil {
+		expanded.Close()
+		return nil, nil, err
+	}
+
+	return er, expanded, nil
+}
+
+// Copy the entries of an archive, prefixing their names, replacing
+// the zips among them by their contents while depth allows.
+func expandInto(w *zip.Writer, r *zip.Reader, prefix string, depth int,
+	config Config) error {
+
+	for _, f := range r.File {
+		if depth > 0 && isNestedArchive(f) {
+			expanded, err := expandNested(w, f, prefix, depth, config)
+			if err != nil {
+				return err
+			}
+			if expanded {
+				continue
+			}
+		}
+
+		fh := f.FileHeader
+		fh.Name = prefix + f.Name
+
+		data, err := f.OpenRaw()
+		if err != nil {
+			return err
+		}
+		fw, err := w.CreateRaw(&fh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Expand a zip found inside an archive, reporting false when it turns
+// out not to be one, so it is copied as-is instead.
+func expandNested(w *zip.Writer, f *zip.File, prefix string, depth int,
+	config Config) (bool, error) {
+
+	rc, err := f.Open()
+	if err != nil {
+		// Damaged entries are left for the entry check to report.
+		return false, nil
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return false, err
+	}
+	inner := tempFile{tmp}
+	defer inner.Close()
+
+	size, err := io.Copy(inner, rc)
+	if err != nil {
+		return false, nil
+	}
+
+	ir, err := zip.NewReader(inner, size)
+	if err != nil {
+		return false, nil
+	}
+
+	if err := checkBomb(ir, config); err != nil {
+		return false, fmt.Errorf("%s%s: %v", prefix, f.Name, err)
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Expanding %s%s.\n", prefix, f.Name)
+	}
+
+	err = expandInto(w, ir, prefix+f.Name+"/", depth-1, config)
+	return true, err
+}
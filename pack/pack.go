@@ -0,0 +1,220 @@
+// Package pack distributes sized items over bins of a fixed capacity.
+//
+// The packer knows nothing about what it is packing; anything with a
+// size can be an item, and callers keep their own metadata on the
+// concrete type.
+package pack
+
+import (
+	"errors"
+	"sort"
+)
+
+var ErrTooLarge = errors.New("Item larger than bin capacity.")
+
+var ErrNoRoom = errors.New("Items do not fit in the bins.")
+
+// An Item is anything which takes up space in a bin.
+type Item interface {
+	Size() uint64
+}
+
+// A Bin holds the items which were packed together.
+type Bin struct {
+	Size  uint64
+	Items []Item
+}
+
+func (bin *Bin) add(item Item) {
+	bin.Size += item.Size()
+	bin.Items = append(bin.Items, item)
+}
+
+// A Strategy distributes items over as few bins of the given
+// capacity as it can manage.
+type Strategy func(items []Item, capacity uint64) ([]*Bin, error)
+
+// Pick the bin an item should go into from the bins it fits in,
+// or return nil to have a new bin opened.
+type chooser func(bins []*Bin, item Item, capacity uint64) *Bin
+
+func fits(bin *Bin, item Item, capacity uint64) bool {
+	return bin.Size+item.Size() <= capacity
+}
+
+func packInto(bins []*Bin, items []Item, capacity uint64,
+	choose chooser) ([]*Bin, error) {
+
+	for _, item := range items {
+		if item.Size() > capacity {
+			return nil, ErrTooLarge
+		}
+
+		bin := choose(bins, item, capacity)
+		if bin == nil {
+			bin = &Bin{}
+			bins = append(bins, bin)
+		}
+		bin.add(item)
+	}
+
+	return bins, nil
+}
+
+func packWith(choose chooser) Strategy {
+	return func(items []Item, capacity uint64) ([]*Bin, error) {
+		return packInto(nil, items, capacity, choose)
+	}
+}
+
+func firstFit(bins []*Bin, item Item, capacity uint64) *Bin {
+	for _, bin := range bins {
+		if fits(bin, item, capacity) {
+			return bin
+		}
+	}
+	return nil
+}
+
+// FirstFit puts every item in the first bin it fits in.
+var FirstFit Strategy = packWith(firstFit)
+
+// BestFit puts every item in the fullest bin it fits in.
+var BestFit Strategy = packWith(
+	func(bins []*Bin, item Item, capacity uint64) *Bin {
+		var best *Bin
+		for _, bin := range bins {
+			if fits(bin, item, capacity) &&
+				(best == nil || bin.Size > best.Size) {
+				best = bin
+			}
+		}
+		return best
+	})
+
+// WorstFit puts every item in the emptiest bin it fits in.
+var WorstFit Strategy = packWith(
+	func(bins []*Bin, item Item, capacity uint64) *Bin {
+		var worst *Bin
+		for _, bin := range bins {
+			if fits(bin, item, capacity) &&
+				(worst == nil || bin.Size < worst.Size) {
+				worst = bin
+			}
+		}
+		return worst
+	})
+
+// NextFit only ever considers the most recently opened bin, which
+// keeps the items in their original order.
+var NextFit Strategy = packWith(
+	func(bins []*Bin, item Item, capacity uint64) *Bin {
+		if len(bins) == 0 {
+			return nil
+		}
+		last := bins[len(bins)-1]
+		if fits(last, item, capacity) {
+			return last
+		}
+		return nil
+	})
+
+// FirstFitDecreasing sorts the items from large to small before
+// packing them with FirstFit.
+func FirstFitDecreasing(items []Item, capacity uint64) ([]*Bin, error) {
+	return FirstFit(Decreasing(items), capacity)
+}
+
+// BestFitDecreasing sorts the items from large to small before
+// packing them with BestFit.
+func BestFitDecreasing(items []Item, capacity uint64) ([]*Bin, error) {
+	return BestFit(Decreasing(items), capacity)
+}
+
+// WorstFitDecreasing sorts the items from large to small before
+// packing them with WorstFit, spreading them evenly over the bins.
+func WorstFitDecreasing(items []Item, capacity uint64) ([]*Bin, error) {
+	return WorstFit(Decreasing(items), capacity)
+}
+
+// DefaultStrategy names the strategy to use when none is asked for.
+const DefaultStrategy = "first-fit-decreasing"
+
+// Strategies by the names they can be picked by. Original order is
+// next fit, which never reorders the items.
+var Strategies = map[string]Strategy{
+	"first-fit":            FirstFit,
+	"first-fit-decreasing": FirstFitDecreasing,
+	"best-fit":             BestFitDecreasing,
+	"worst-fit":            WorstFitDecreasing,
+	"next-fit":             NextFit,
+	"original-order":       NextFit,
+}
+
+// Fill adds items to bins which may already hold something, largest
+// items first, opening new bins for what doesn't fit. The given bins
+// are updated in place and returned followed by any new ones.
+func Fill(bins []*Bin, items []Item, capacity uint64) ([]*Bin, error) {
+	bins = append([]*Bin(nil), bins...)
+	return packInto(bins, Decreasing(items), capacity, firstFit)
+}
+
+// FirstFitSized puts every item, in the given order, in the first of a
+// fixed set of bins with their own capacities it fits in. A bin is
+// returned for every capacity, even when it stays empty.
+func FirstFitSized(items []Item, capacities []uint64) ([]*Bin, error) {
+	bins := make([]*Bin, len(capacities))
+	for i := range bins {
+		bins[i] = &Bin{}
+	}
+
+	for _, item := range items {
+		placed := false
+		for i, bin := range bins {
+			if fits(bin, item, capacities[i]) {
+				bin.add(item)
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			return nil, ErrNoRoom
+		}
+	}
+
+	return bins, nil
+}
+
+// Balance spreads the items over n bins, without a capacity, by
+// putting every item, largest first, in the emptiest bin. The bins end
+// up about equally full.
+func Balance(items []Item, n int) []*Bin {
+	bins := make([]*Bin, n)
+	for i := range bins {
+		bins[i] = &Bin{}
+	}
+
+	for _, item := range Decreasing(items) {
+		emptiest := bins[0]
+		for _, bin := range bins[1:] {
+			if bin.Size < emptiest.Size {
+				emptiest = bin
+			}
+		}
+		emptiest.add(item)
+	}
+
+	return bins
+}
+
+// Return a copy of items sorted from large to small; items of the
+// same size keep their relative order.
+func Decreasing(items []Item) []Item {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size() > sorted[j].Size()
+	})
+
+	return sorted
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// Where the passwords of the source and the parts are taken from when
+// -password and -out-password are left out.
+const (
+	passwordEnv    = "ZIPSPLIT_PASSWORD"
+	outPasswordEnv = "ZIPSPLIT_OUT_PASSWORD"
+)
+
+// Warn about a password given on the command line, where other users
+// can see it in the process list and it stays in the shell history.
+func warnPasswordArg(name, password, env string) {
+	if password == "" || password == "-" {
+		return
+	}
+
+	warnings.Printf("The -%s shows up in the process list and the shell "+
+		"history; set $%s or give -%s - to be asked for it instead.",
+		name, env, name)
+}
+
+// Return the password of the source: the one given, one asked for on
+// the terminal when that is -, or the one in the environment.
+func sourcePassword(password string) (string, error) {
+	switch password {
+	case "":
+		return os.Getenv(passwordEnv), nil
+	case "-":
+		return promptPassword("Password: ")
+	}
+
+	return password, nil
+}
+
+// Return the password to encrypt the parts with, like sourcePassword,
+// asking for it twice to rule out typos.
+func partsPassword(password string) (string, error) {
+	switch password {
+	case "":
+		password = os.Getenv(outPasswordEnv)
+	case "-":
+		first, err := promptPassword("Password for the parts: ")
+		if err != nil {
+			return "", err
+		}
+		again, err := promptPassword("Once more: ")
+		if err != nil {
+			return "", err
+		}
+		if first != again {
+			return "", errors.New("The passwords differ.")
+		}
+		password = first
+	}
+
+	if password == "" {
+		return "", errors.New("Encrypting needs a password, set $" +
+			outPasswordEnv + " or give -out-password - to be asked for it.")
+	}
+
+	return password, nil
+}
+
+// Ask for a password on the terminal, without showing what is typed.
+func promptPassword(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errors.New("No terminal to ask for the password on.")
+	}
+	defer tty.Close()
+
+	stty := func(arg string) {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		cmd.Run()
+	}
+
+	fmt.Fprint(tty, prompt)
+	stty("-echo")
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	stty("echo")
+	fmt.Fprintln(tty)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Report whether any entry of the archive is encrypted.
+func hasEncrypted(r *zip.Reader) bool {
+	for _, f := range r.File {
+		if zipcrypt.IsEncrypted(&f.FileHeader) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Decrypt the encrypted entries of the source into a temporary
+// archive, so they are split like any other.
+func decryptArchive(r *zip.Reader, password string) (*zip.Reader, io.Closer, error) {
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	decrypted := tempFile{f}
+
+	w := zip.NewWriter(decrypted)
+	if err := decryptInto(w, r, password); err != nil {
+		decrypted.Close()
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		decrypted.Close()
+		return nil, nil, err
+	}
+
+	info, err := decrypted.Stat()
+	if err != nil {
+		decrypted.Close()
+		return nil, nil, err
+	}
+
+	dr, err := zip.NewReader(decrypted, info.Size())
+	if err != nil {
+		decrypted.Close()
+		return nil, nil, err
+	}
+
+	return dr, decrypted, nil
+}
+
+func decryptInto(w *zip.Writer, r *zip.Reader, password string) error {
+	for _, f := range r.File {
+		fh := f.FileHeader
+		var data io.Reader
+		var err error
+
+		if zipcrypt.IsEncrypted(&f.FileHeader) {
+			var decrypted *zip.FileHeader
+			decrypted, data, err = zipcrypt.Open(f, password)
+			if errors.Is(err, zipcrypt.ErrPassword) {
+				return fmt.Errorf("%s: Wrong password.", f.Name)
+			}
+			if err == nil {
+				fh = *decrypted
+			}
+		} else {
+			data, err = f.OpenRaw()
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+
+		fw, err := w.CreateRaw(&fh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, data); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// What to do with entries whose names would escape the directory they
+// are extracted in.
+const (
+	unsafeWarn     = "warn"
+	unsafeSanitize = "sanitize"
+	unsafeExclude  = "exclude"
+	unsafeAbort    = "abort"
+)
+
+// What to do with entries whose names are unsafe to extract on Windows.
+const (
+	sanitizeOff       = "off"
+	sanitizeReject    = "reject"
+	sanitizeRename    = "rename"
+	sanitizeNormalize = "normalize"
+)
+
+// Report whether extracting an entry could write outside the target
+// directory, because its name is absolute or has .. components.
+func isUnsafePath(name string) bool {
+	if strings.HasPrefix(name, "/") {
+		return true
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		if component == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Return the name with its leading slashes and any . and .. components
+// removed, keeping a trailing slash for directories.
+func sanitizePath(name string) string {
+	var components []string
+	for _, component := range strings.Split(name, "/") {
+		if component != "" && component != "." && component != ".." {
+			components = append(components, component)
+		}
+	}
+
+	clean := path.Join(components...)
+	if clean != "" && strings.HasSuffix(name, "/") {
+		clean += "/"
+	}
+
+	return clean
+}
+
+// Report whether a path component is a device name on Windows, which
+// it stays with any extension or trailing spaces.
+func isReservedName(component string) bool {
+	base, _, _ := strings.Cut(component, ".")
+	base = strings.ToUpper(strings.TrimRight(base, " "))
+
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") ||
+		strings.HasPrefix(base, "LPT")) && base[3] >= '1' && base[3] <= '9'
+}
+
+func hasDriveLetter(name string) bool {
+	return len(name) >= 2 && name[1] == ':' &&
+		(name[0] >= 'a' && name[0] <= 'z' || name[0] >= 'A' && name[0] <= 'Z')
+}
+
+// Return the reserved name with an underscore after its base name, like
+// con_.txt for con.txt.
+func escapeReservedName(component string) string {
+	base, ext, found := strings.Cut(component, ".")
+	if !found {
+		return base + "_"
+	}
+
+	return base + "_." + ext
+}
+
+// Return what makes extracting an entry on Windows unsafe, or an empty
+// string when nothing does.
+func windowsPathProblem(name string) string {
+	switch {
+	case strings.Contains(name, `\`):
+		return "a backslash"
+	case hasDriveLetter(name):
+		return "a drive letter"
+	}
+
+	for _, component := range strings.Split(name, "/") {
+		switch {
+		case component == "..":
+			return "a .. component"
+		case isReservedName(component):
+			return "the reserved name " + component
+		}
+	}
+
+	return ""
+}
+
+// Return the name read as a Windows path: backslashes separate
+// directories, and the drive, . and .. components are left out.
+// Reserved names are escaped.
+func normalizeWindowsPath(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	if hasDriveLetter(name) {
+		name = name[2:]
+	}
+
+	clean := sanitizePath(name)
+	components := strings.Split(clean, "/")
+	for i, component := range components {
+		if isReservedName(component) {
+			components[i] = escapeReservedName(component)
+		}
+	}
+
+	return strings.Join(components, "/")
+}
+
+// Return the name with the parts which are unsafe on Windows replaced,
+// keeping its directories as they are.
+func renameWindowsPath(name string) string {
+	name = strings.ReplaceAll(name, `\`, "_")
+	if hasDriveLetter(name) {
+		name = name[:1] + "_" + name[2:]
+	}
+
+	components := strings.Split(name, "/")
+	for i, component := range components {
+		switch {
+		case component == "..":
+			components[i] = "__"
+		case isReservedName(component):
+			components[i] = escapeReservedName(component)
+		}
+	}
+
+	return strings.Join(components, "/")
+}
+
+// Apply the Windows path policy to an entry, returning whether it should
+// still be split.
+func sanitizeEntry(file *zip.FileHeader, config Config) (bool, error) {
+	if config.sanitize == sanitizeOff {
+		return true, nil
+	}
+
+	problem := windowsPathProblem(file.Name)
+	if problem == "" {
+		return true, nil
+	}
+
+	var clean string
+	switch config.sanitize {
+	case sanitizeReject:
+		return false, fmt.Errorf("%s has %s.", file.Name, problem)
+	case sanitizeRename:
+		clean = renameWindowsPath(file.Name)
+	case sanitizeNormalize:
+		clean = normalizeWindowsPath(file.Name)
+	}
+	if clean == "" {
+		warnings.Printf("Excluding %s, it has %s.", file.Name, problem)
+		return false, nil
+	}
+	warnings.Printf("Renaming %s to %s, it has %s.", file.Name, clean, problem)
+
+	// Shared with the source reader like for unsafe paths.
+	file.Name = clean
+
+	return true, nil
+}
+
+// Apply the unsafe path policy to the entries, returning those which
+// should be split.
+func checkPaths(files []*zip.FileHeader, config Config) ([]*zip.FileHeader,
+	error) {
+
+	var kept []*zip.FileHeader
+
+	for _, file := range files {
+		ok, err := sanitizeEntry(file, config)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		if !isUnsafePath(file.Name) {
+			kept = append(kept, file)
+			continue
+		}
+
+		switch config.unsafePaths {
+		case unsafeAbort:
+			return nil, fmt.Errorf("Unsafe path %s.", file.Name)
+
+		case unsafeExclude:
+			warnings.Printf("Excluding unsafe path %s.", file.Name)
+			continue
+
+		case unsafeSanitize:
+			clean := sanitizePath(file.Name)
+			if clean == "" {
+				warnings.Printf("Excluding unsafe path %s.", file.Name)
+				continue
+			}
+			warnings.Printf("Renaming unsafe path %s to %s.", file.Name, clean)
+
+			// The header is shared with the source reader, so the
+			// entry is written under its new name.
+			file.Name = clean
+
+		default:
+			warnings.Printf("Unsafe path %s.", file.Name)
+		}
+		kept = append(kept, file)
+	}
+
+	return kept, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// How a split set would turn out at some split size.
+type planResult struct {
+	splitSize uint64
+	parts     int
+	waste     uint64
+	slack     uint64
+	err       error
+}
+
+// Simulate splitting a source at several sizes and compare the
+// results, without writing anything.
+func planCommand(args []string) {
+	flags := flag.NewFlagSet("plan", flag.ExitOnError)
+
+	sweep := flags.String(
+		"sweep",
+		"",
+		"Comma separated split sizes to try.")
+
+	strategyName := flags.String(
+		"strategy",
+		pack.DefaultStrategy,
+		"How to pack entries into parts, see zipsplit -h.")
+
+	flags.Parse(args)
+
+	if *sweep == "" || flags.NArg() != 1 {
+		fatal(errors.New(
+			"Usage: zipsplit plan -sweep size,size... source.zip"))
+	}
+
+	var sizes []uint64
+	for _, field := range strings.Split(*sweep, ",") {
+		size, err := humanToNumber(field)
+		if err != nil || size == 0 {
+			fatalf("Invalid split size %q.", field)
+		}
+		sizes = append(sizes, size)
+	}
+
+	strategy, ok := pack.Strategies[*strategyName]
+	if !ok {
+		fatalf("Invalid packing strategy %q.", *strategyName)
+	}
+
+	config := Config{
+		sourceArchive: flags.Arg(0),
+		nameTemplate:  "out-%03d.zip",
+		format:        formatZip,
+		retries:       5,
+		overhead:      split.DefaultOverhead,
+		strategy:      strategy}
+
+	sourceReader, sourceCloser, err := openSource(config)
+	if err != nil {
+		fatal(err)
+	}
+	defer sourceCloser.Close()
+	defer closeStdin()
+
+	var files []*zip.FileHeader
+	for _, f := range sourceReader.File {
+		files = append(files, &f.FileHeader)
+	}
+
+	var results []planResult
+	for _, size := range sizes {
+		config.splitSize = size
+		results = append(results, planSize(files, config))
+	}
+
+	printPlans(results)
+}
+
+// Pack the files at the configured split size. Waste is the room left
+// unused in all parts, slack the most left in any part but the last.
+func planSize(files []*zip.FileHeader, config Config) planResult {
+	result := planResult{splitSize: config.splitSize}
+
+	buckets, err := fit(files, config)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.parts = len(buckets)
+
+	overhead := partOverhead(config)
+	for i, bucket := range buckets {
+		unused := config.splitSize - overhead - bucket.Size
+		result.waste += unused
+		if i < len(buckets)-1 {
+			result.slack = max(result.slack, unused)
+		}
+	}
+
+	return result
+}
+
+func printPlans(results []planResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Split size\tParts\tWaste\tLargest slack\t")
+
+	for _, result := range results {
+		if result.err != nil {
+			fmt.Fprintf(w, "%s\t-\t-\t-\t  %v\n",
+				numberToHuman(result.splitSize), result.err)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t\n",
+			numberToHuman(result.splitSize),
+			result.parts,
+			numberToHuman(result.waste),
+			numberToHuman(result.slack))
+	}
+
+	w.Flush()
+}
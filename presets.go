@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Disc sizes are whole 2048 byte sectors.
+const sector = 2048
+
+// A common target for the parts, so its limits need not be
+// remembered.
+type preset struct {
+	// The most a part can take up there.
+	limit uint64
+
+	// Room to leave for what the target adds to a part, like the
+	// encoding of an attachment or the filesystem of a disc.
+	margin uint64
+}
+
+var presets = map[string]preset{
+	// Attachments are base64 encoded, in lines of 76 characters,
+	// which takes 78/57 the room, and the message needs headers.
+	"email": {20_000_000, 20_000_000 - 14_500_000},
+
+	"fat32":   {4*GByte - 1, 0},
+	"discord": {25 * MByte, 64 * KByte},
+
+	// The filesystem of a disc takes some room of its own.
+	"cd700":  {360_000 * sector, 2 * MByte},
+	"dvd4.7": {2_295_104 * sector, 4 * MByte},
+	"bd25":   {12_219_392 * sector, 16 * MByte},
+}
+
+// Return the preset of the given name.
+func lookupPreset(name string) (preset, error) {
+	p, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return preset{}, fmt.Errorf("Unknown preset %q, pick one of %s.",
+			name, strings.Join(presetNames(), ", "))
+	}
+
+	return p, nil
+}
+
+func presetNames() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Parse a margin, either a size or a percentage of the split size like
+// 2.5%.
+func parseMargin(s string, splitSize uint64) (uint64, error) {
+	percentage, ok := strings.CutSuffix(strings.TrimSpace(s), "%")
+	if !ok {
+		return humanToNumber(s)
+	}
+
+	percent, err := strconv.ParseFloat(percentage, 64)
+	if err != nil || percent < 0 || percent > 100 {
+		return 0, fmt.Errorf("Invalid margin %q.", s)
+	}
+
+	return uint64(float64(splitSize) * percent / 100), nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// Start CPU profiling when asked to. The returned function stops it
+// and writes the memory profile, if one was asked for.
+func startProfiling(cpuProfile, memProfile string) (func(), error) {
+	var cpuFile *os.File
+
+	if cpuProfile != "" {
+		f, err := os.Create(cpuProfile)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpuFile = f
+	}
+
+	return func() {
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			cpuFile.Close()
+		}
+
+		if memProfile != "" {
+			f, err := os.Create(memProfile)
+			if err != nil {
+				warnings.Print(err)
+				return
+			}
+			defer f.Close()
+
+			// Get up-to-date statistics.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				warnings.Print(err)
+			}
+		}
+	}, nil
+}
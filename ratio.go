@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+// Parts whose data shrank by less than this are mostly incompressible,
+// so recompressing them is unlikely to be worth the effort.
+const incompressibleRatio = 0.95
+
+// Compressed and uncompressed totals of a part.
+type partRatio struct {
+	name         string
+	compressed   uint64
+	uncompressed uint64
+}
+
+func (p partRatio) ratio() float64 {
+	if p.uncompressed == 0 {
+		return 1
+	}
+
+	return float64(p.compressed) / float64(p.uncompressed)
+}
+
+// Report how well the data in each part of a split set compressed.
+func ratioCommand(args []string) {
+	flags := flag.NewFlagSet("ratio", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Read the entries from this manifest instead of the parts.")
+
+	flags.Parse(args)
+
+	if *manifestName == "" && flags.NArg() == 0 {
+		fatal(errors.New(
+			"Usage: zipsplit ratio [-manifest manifest.json] [part...]"))
+	}
+
+	entries, err := setEntries(*manifestName, flags.Args())
+	if err != nil {
+		fatal(err)
+	}
+
+	printRatios(partRatios(entries))
+}
+
+// Sum the entries of a split set per part, keeping the part order.
+func partRatios(entries []setEntry) []partRatio {
+	var parts []partRatio
+
+	for _, entry := range entries {
+		if len(parts) == 0 || parts[len(parts)-1].name != entry.part {
+			parts = append(parts, partRatio{name: entry.part})
+		}
+		part := &parts[len(parts)-1]
+		part.compressed += entry.CompressedSize
+		part.uncompressed += entry.UncompressedSize
+	}
+
+	return parts
+}
+
+func printRatios(parts []partRatio) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "  Compressed\t        Size\t Ratio\tPart")
+
+	total := partRatio{name: fmt.Sprintf("%d parts", len(parts))}
+	for _, part := range parts {
+		printRatio(w, part)
+		total.compressed += part.compressed
+		total.uncompressed += part.uncompressed
+	}
+
+	printRatio(w, total)
+	w.Flush()
+}
+
+func printRatio(w *tabwriter.Writer, part partRatio) {
+	note := ""
+	if part.ratio() >= incompressibleRatio {
+		note = " (incompressible)"
+	}
+
+	fmt.Fprintf(w, "%12d\t%12d\t%5.1f%%\t%s%s\n",
+		part.compressed,
+		part.uncompressed,
+		part.ratio()*100,
+		part.name,
+		note)
+}
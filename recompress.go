@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ascheepe/zipsplit/zipcrypt"
+	"github.com/ascheepe/zipsplit/zstd"
+)
+
+// Compression methods entries can be recompressed with.
+const (
+	methodDeflate = "deflate"
+	methodStore   = "store"
+	methodZstd    = "zstd"
+)
+
+// Return the zip method of a compression method name.
+func parseMethod(name string) (uint16, error) {
+	switch strings.ToLower(name) {
+	case methodDeflate:
+		return zip.Deflate, nil
+	case methodStore:
+		return zip.Store, nil
+	case methodZstd:
+		return zstd.Method, nil
+	}
+
+	return 0, fmt.Errorf("Invalid compression method %q.", name)
+}
+
+// Recompress the entries of the source into a temporary archive, with
+// the given method and deflate level, so they are planned by the size
+// they end up with. Entries which can't be decompressed here are
+// copied as they are. Zstandard has no levels.
+func recompressArchive(r *zip.Reader, method uint16,
+	level, jobs int) (*zip.Reader, io.Closer, error) {
+
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	recompressed := tempFile{f}
+
+	w := zip.NewWriter(recompressed)
+	w.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	w.RegisterCompressor(zstd.Method, func(out io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(out), nil
+	})
+	if err := recompressInto(w, r, method, level, jobs); err != nil {
+		recompressed.Close()
+		return nil, nil, err
+	}
+	if err := w.Close(); err != nil {
+		recompressed.Close()
+		return nil, nil, err
+	}
+
+	info, err := recompressed.Stat()
+	if err != nil {
+		recompressed.Close()
+		return nil, nil, err
+	}
+
+	rr, err := zip.NewReader(recompressed, info.Size())
+	if err != nil {
+		recompressed.Close()
+		return nil, nil, err
+	}
+
+	return rr, recompressed, nil
+}
+
+// Entries larger than this are compressed by the writer itself in
+// their turn, as holding on to their compressed data until then would
+// take too much memory.
+const maxBufferedEntry = 64 * MByte
+
+// An entry compressed by a worker, waiting for its turn to be written.
+type recompressedEntry struct {
+	f    *zip.File
+	fh   zip.FileHeader
+	data bytes.Buffer
+	err  error
+	done chan struct{}
+}
+
+// Recompress the entries with up to jobs of them at once, writing them
+// in their original order.
+func recompressInto(w *zip.Writer, r *zip.Reader, method uint16,
+	level, jobs int) error {
+
+	work := make(chan *recompressedEntry)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(work)
+
+	for range max(jobs, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range work {
+				entry.err = compressEntry(entry, method, level)
+				close(entry.done)
+			}
+		}()
+	}
+
+	// Entries handed to the workers and not written yet, at most
+	// twice as many as there are workers.
+	var pending []*recompressedEntry
+	flush := func(keep int) error {
+		for len(pending) > keep {
+			entry := pending[0]
+			pending = pending[1:]
+			<-entry.done
+			if entry.err != nil {
+				return fmt.Errorf("%s: %v", entry.fh.Name, entry.err)
+			}
+			dst, err := w.CreateRaw(&entry.fh)
+			if err == nil {
+				_, err = entry.data.WriteTo(dst)
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %v", entry.fh.Name, err)
+			}
+		}
+		return nil
+	}
+
+	for _, f := range r.File {
+		if jobs > 1 && f.UncompressedSize64 <= maxBufferedEntry &&
+			canRecompress(f) {
+			entry := &recompressedEntry{f: f, done: make(chan struct{})}
+			pending = append(pending, entry)
+			work <- entry
+			if err := flush(2 * jobs); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := flush(0); err != nil {
+			return err
+		}
+		if err := recompressEntry(w, f, method); err != nil {
+			return err
+		}
+	}
+
+	return flush(0)
+}
+
+// Report whether an entry can be decompressed here to compress it anew.
+func canRecompress(f *zip.File) bool {
+	if f.FileInfo().IsDir() || zipcrypt.IsEncrypted(&f.FileHeader) {
+		return false
+	}
+
+	return zip.Store == f.Method || zip.Deflate == f.Method
+}
+
+// Return the header of an entry compressed anew. The time is kept in
+// the fields and extra field it was found in, rather than adding one
+// of its own.
+func recompressedHeader(f *zip.File, method uint16) zip.FileHeader {
+	fh := f.FileHeader
+	fh.Method = method
+	fh.Modified = time.Time{}
+	fh.Extra = filterExtra(fh.Extra, func(id uint16) bool {
+		return id != zip64ExtraID
+	})
+
+	return fh
+}
+
+// Recompress an entry straight into the archive, or copy it as it is
+// when it can't be decompressed here.
+func recompressEntry(w *zip.Writer, f *zip.File, method uint16) error {
+	if f.FileInfo().IsDir() || zipcrypt.IsEncrypted(&f.FileHeader) {
+		if err := w.Copy(f); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		return nil
+	}
+
+	rc, err := f.Open()
+	if errors.Is(err, zip.ErrAlgorithm) {
+		if err := w.Copy(f); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Name, err)
+	}
+
+	// The zip.Writer works out the sizes and CRC anew.
+	fh := recompressedHeader(f, method)
+	dst, err := w.CreateHeader(&fh)
+	if err == nil {
+		_, err = io.Copy(dst, rc)
+	}
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Name, err)
+	}
+
+	return nil
+}
+
+// Compress an entry into memory, with the header to write it with
+// as it is.
+func compressEntry(entry *recompressedEntry, method uint16, level int) error {
+	entry.fh = recompressedHeader(entry.f, method)
+
+	rc, err := entry.f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	var cw io.WriteCloser
+	switch method {
+	case zip.Store:
+		cw = nopWriteCloser{&entry.data}
+	case zip.Deflate:
+		if cw, err = flate.NewWriter(&entry.data, level); err != nil {
+			return err
+		}
+	case zstd.Method:
+		cw = zstd.NewWriter(&entry.data)
+	}
+
+	sum := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(cw, sum), rc)
+	if err != nil {
+		return err
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+
+	// Like the zip.Writer does for the entries it compresses itself,
+	// but with the sizes in the local header.
+	entry.fh.Flags &^= hasDataDescriptorFlag
+	entry.fh.CreatorVersion = entry.fh.CreatorVersion&0xff00 | 20
+	entry.fh.ReaderVersion = 20
+	entry.fh.CRC32 = sum.Sum32()
+	entry.fh.UncompressedSize64 = uint64(size)
+	entry.fh.CompressedSize64 = uint64(entry.data.Len())
+
+	return nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Return an extra field with only the records whose ID is kept.
+func filterExtra(extra []byte, keep func(id uint16) bool) []byte {
+	var rest []byte
+
+	for len(extra) >= 4 {
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
+		}
+		if keep(binary.LittleEndian.Uint16(extra)) {
+			rest = append(rest, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+
+	return append(rest, extra...)
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/ascheepe/zipsplit/manifest"
+)
+
+var (
+	localHeaderMagic    = []byte("PK\x03\x04")
+	dataDescriptorMagic = []byte("PK\x07\x08")
+)
+
+const (
+	// How much to read at a time when looking for a signature.
+	scanChunk = 64 * 1024
+
+	zip64ExtraID = 0x0001
+
+	fileHeaderLen         = 30
+	dataDescriptor64Len   = 24
+	hasDataDescriptorFlag = 0x8
+)
+
+// Salvage what can be read from a source whose central directory is
+// damaged, by walking its local headers. The entries found are copied
+// to a temporary archive which is used as the source instead. Entries
+// whose data can't be delimited are returned as damaged.
+func recoverSource(config Config) (*zip.Reader, io.Closer,
+	[]manifest.Damaged, error) {
+
+	src, size, closer, err := openSourceFile(config)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer closer.Close()
+
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	recovered := tempFile{f}
+
+	damaged, count, err := recoverEntries(src, size, zip.NewWriter(recovered))
+	if err != nil {
+		recovered.Close()
+		return nil, nil, nil, err
+	}
+	warnings.Printf("Recovered %d entries, lost %d.", count, len(damaged))
+
+	info, err := recovered.Stat()
+	if err != nil {
+		recovered.Close()
+		return nil, nil, nil, err
+	}
+	r, err := zip.NewReader(recovered, info.Size())
+	if err != nil {
+		recovered.Close()
+		return nil, nil, nil, err
+	}
+
+	return r, recovered, damaged, nil
+}
+
+// Copy every entry with a readable local header to w, returning those
+// which could not be copied and the number which were.
+func recoverEntries(src io.ReaderAt, size int64,
+	w *zip.Writer) ([]manifest.Damaged, int, error) {
+
+	var damaged []manifest.Damaged
+	count := 0
+
+	for offset := int64(0); ; {
+		start, found := findSignature(src, size, offset, localHeaderMagic)
+		if !found {
+			break
+		}
+		if start > offset {
+			warnings.Printf("Skipping %d unreadable bytes at offset %d.",
+				start-offset, offset)
+		}
+
+		fh, dataOffset, end, err := readLocalEntry(src, size, start)
+		if err != nil {
+			if fh != nil {
+				damaged = append(damaged, manifest.Damaged{
+					Name:    fh.Name,
+					Error:   err.Error(),
+					Skipped: true})
+			}
+			offset = start + int64(len(localHeaderMagic))
+			continue
+		}
+
+		fw, err := w.CreateRaw(fh)
+		if err != nil {
+			return nil, 0, err
+		}
+		data := io.NewSectionReader(src, dataOffset, int64(fh.CompressedSize64))
+		if _, err := io.Copy(fw, data); err != nil {
+			return nil, 0, err
+		}
+		count++
+		offset = end
+	}
+
+	return damaged, count, w.Close()
+}
+
+// Return the offset of the first signature at or after offset.
+func findSignature(r io.ReaderAt, size, offset int64,
+	magic []byte) (int64, bool) {
+
+	buf := make([]byte, scanChunk+len(magic)-1)
+	for offset < size {
+		n, err := r.ReadAt(buf, offset)
+		if n == 0 && err != nil {
+			return 0, false
+		}
+		if i := bytes.Index(buf[:n], magic); i >= 0 {
+			return offset + int64(i), true
+		}
+		offset += scanChunk
+	}
+
+	return 0, false
+}
+
+// Read the local header at offset, returning the header for the entry
+// and where its data starts and the entry ends. When the header is
+// readable but the data isn't, the header is returned with the error.
+func readLocalEntry(src io.ReaderAt, size,
+	offset int64) (*zip.FileHeader, int64, int64, error) {
+
+	le := binary.LittleEndian
+
+	fixed := make([]byte, fileHeaderLen)
+	if _, err := src.ReadAt(fixed, offset); err != nil {
+		return nil, 0, 0, err
+	}
+	nameLen := int64(le.Uint16(fixed[26:]))
+	extraLen := int64(le.Uint16(fixed[28:]))
+
+	variable := make([]byte, nameLen+extraLen)
+	if _, err := src.ReadAt(variable, offset+fileHeaderLen); err != nil {
+		return nil, 0, 0, err
+	}
+
+	fh := &zip.FileHeader{
+		Name:               string(variable[:nameLen]),
+		ReaderVersion:      le.Uint16(fixed[4:]),
+		Flags:              le.Uint16(fixed[6:]),
+		Method:             le.Uint16(fixed[8:]),
+		ModifiedTime:       le.Uint16(fixed[10:]),
+		ModifiedDate:       le.Uint16(fixed[12:]),
+		CRC32:              le.Uint32(fixed[14:]),
+		CompressedSize64:   uint64(le.Uint32(fixed[18:])),
+		UncompressedSize64: uint64(le.Uint32(fixed[22:]))}
+	fh.NonUTF8 = fh.Flags&0x800 == 0
+	fh.Extra = localExtra(fh, variable[nameLen:])
+
+	dataOffset := offset + fileHeaderLen + nameLen + extraLen
+
+	if fh.Flags&hasDataDescriptorFlag == 0 {
+		end := dataOffset + int64(fh.CompressedSize64)
+		if end > size {
+			return fh, 0, 0, errors.New("data is truncated")
+		}
+		return fh, dataOffset, end, nil
+	}
+
+	// The sizes follow the data, so its end has to be found first.
+	end, err := readDataDescriptor(src, size, dataOffset, fh)
+	if err != nil {
+		return fh, 0, 0, err
+	}
+	fh.Flags &^= hasDataDescriptorFlag
+
+	return fh, dataOffset, end, nil
+}
+
+// Take the sizes from a zip64 extra field, returning the other fields.
+func localExtra(fh *zip.FileHeader, extra []byte) []byte {
+	le := binary.LittleEndian
+	var rest []byte
+
+	for len(extra) >= 4 {
+		id := le.Uint16(extra)
+		size := 4 + int(le.Uint16(extra[2:]))
+		if size > len(extra) {
+			break
+		}
+
+		if id == zip64ExtraID && size >= 4+16 {
+			fh.UncompressedSize64 = le.Uint64(extra[4:])
+			fh.CompressedSize64 = le.Uint64(extra[12:])
+		} else if id != zip64ExtraID {
+			rest = append(rest, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+
+	return rest
+}
+
+// Find where the data of an entry with a data descriptor ends, filling
+// in its sizes and CRC, and return the end of the descriptor. Deflated
+// data ends where its stream does; for stored data a descriptor
+// matching the distance travelled is looked for.
+func readDataDescriptor(src io.ReaderAt, size, dataOffset int64,
+	fh *zip.FileHeader) (int64, error) {
+
+	switch fh.Method {
+	case zip.Deflate:
+		cr := &countByteReader{r: bufio.NewReader(
+			io.NewSectionReader(src, dataOffset, size-dataOffset))}
+		h := crc32.NewIEEE()
+		n, err := io.Copy(h, flate.NewReader(cr))
+		if err != nil {
+			return 0, err
+		}
+
+		fh.CRC32 = h.Sum32()
+		fh.CompressedSize64 = uint64(cr.count)
+		fh.UncompressedSize64 = uint64(n)
+
+		end, ok := matchDataDescriptor(src, dataOffset+cr.count, fh)
+		if !ok {
+			return 0, errors.New("data descriptor does not match")
+		}
+		return end, nil
+
+	case zip.Store:
+		for offset := dataOffset; ; offset++ {
+			p, found := findSignature(src, size, offset, dataDescriptorMagic)
+			if !found {
+				return 0, errors.New("no data descriptor found")
+			}
+
+			var crc [4]byte
+			if _, err := src.ReadAt(crc[:], p+4); err != nil {
+				return 0, err
+			}
+			fh.CRC32 = binary.LittleEndian.Uint32(crc[:])
+			fh.CompressedSize64 = uint64(p - dataOffset)
+			fh.UncompressedSize64 = fh.CompressedSize64
+
+			if end, ok := matchDataDescriptor(src, p, fh); ok {
+				return end, nil
+			}
+			offset = p
+		}
+
+	default:
+		return 0, errors.New("can't find the end of the data")
+	}
+}
+
+// Check that a data descriptor at offset, with or without signature
+// and with either size of fields, agrees with the header, returning
+// where it ends.
+func matchDataDescriptor(src io.ReaderAt, offset int64,
+	fh *zip.FileHeader) (int64, bool) {
+
+	le := binary.LittleEndian
+	buf := make([]byte, dataDescriptor64Len+4)
+	n, _ := src.ReadAt(buf, offset)
+	buf = buf[:n]
+
+	start := 0
+	if bytes.HasPrefix(buf, dataDescriptorMagic) {
+		start = 4
+	}
+	buf = buf[start:]
+
+	if len(buf) >= 12 && le.Uint32(buf) == fh.CRC32 &&
+		uint64(le.Uint32(buf[4:])) == fh.CompressedSize64 &&
+		uint64(le.Uint32(buf[8:])) == fh.UncompressedSize64 {
+		return offset + int64(start) + 12, true
+	}
+	if len(buf) >= 20 && le.Uint32(buf) == fh.CRC32 &&
+		le.Uint64(buf[4:]) == fh.CompressedSize64 &&
+		le.Uint64(buf[12:]) == fh.UncompressedSize64 {
+		return offset + int64(start) + 20, true
+	}
+
+	return 0, false
+}
+
+// Counts the bytes taken from a buffered reader, which the flate
+// reader reads one at a time, to tell where a deflate stream ends.
+type countByteReader struct {
+	r     *bufio.Reader
+	count int64
+}
+
+func (c *countByteReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.count += int64(n)
+	return n, err
+}
+
+func (c *countByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.count++
+	}
+	return b, err
+}
@@ -0,0 +1,306 @@
+// Package remote gives random access to source archives which are not
+// on the local file system.
+package remote
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// How much of the end of the file to fetch to find the end of central
+// directory record; it is followed by at most a 64k comment.
+const tailProbeLen = 64*1024 + 22
+
+// Skipping ahead this far in the current stream is cheaper than
+// starting a new one.
+const maxSkip = 256 * 1024
+
+var (
+	ErrNoRanges = errors.New("Server does not support range requests.")
+	ErrChanged  = errors.New("File changed while reading it.")
+)
+
+// A place remote files can be read from in pieces.
+type backend interface {
+	size() (int64, error)
+
+	// Return a stream of the bytes from off up to end.
+	openRange(off, end int64) (io.ReadCloser, error)
+}
+
+// File reads a remote file in ranges. Sequential reads share one
+// stream, which is resumed where it left off when it breaks. The
+// central directory at the end of the archive is fetched once and kept
+// in memory.
+type File struct {
+	backend backend
+	retries int
+	size    int64
+
+	tail       []byte
+	tailOffset int64
+
+	mu   sync.Mutex
+	body io.ReadCloser
+	pos  int64
+}
+
+func open(b backend, retries int) (*File, error) {
+	f := &File{backend: b, retries: retries}
+
+	err := f.retry(func() error {
+		size, err := b.size()
+		f.size = size
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.fetchTail(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *File) Size() int64 {
+	return f.size
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("Negative offset.")
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	if off < f.tailOffset {
+		want := p
+		if off+int64(len(want)) > f.tailOffset {
+			want = want[:f.tailOffset-off]
+		}
+
+		f.mu.Lock()
+		m, err := f.readStream(want, off)
+		f.mu.Unlock()
+
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		start := off + int64(n) - f.tailOffset
+		n += copy(p[n:], f.tail[start:])
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Close the open stream, if any.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closeBody()
+	return nil
+}
+
+// Fill p from the stream at off, (re)starting the stream as needed.
+func (f *File) readStream(p []byte, off int64) (int, error) {
+	n := 0
+	attempt := 0
+
+	for n < len(p) {
+		f.skipTo(off + int64(n))
+		if f.body == nil || f.pos != off+int64(n) {
+			f.closeBody()
+			body, err := f.backend.openRange(off+int64(n), f.tailOffset)
+			if err != nil {
+				if permanent(err) || attempt >= f.retries {
+					return n, err
+				}
+				attempt++
+				backoff(attempt)
+				continue
+			}
+			f.body, f.pos = body, off+int64(n)
+		}
+
+		m, err := f.body.Read(p[n:])
+		n += m
+		f.pos += int64(m)
+
+		if err != nil {
+			f.closeBody()
+			if n == len(p) {
+				break
+			}
+			if attempt >= f.retries {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n, err
+			}
+			attempt++
+			backoff(attempt)
+		}
+	}
+
+	return n, nil
+}
+
+// Read up to a nearby offset in the current stream instead of
+// dropping it, as happens when reading the entry after a header.
+func (f *File) skipTo(off int64) {
+	if f.body == nil || off <= f.pos || off-f.pos > maxSkip {
+		return
+	}
+
+	n, _ := io.CopyN(io.Discard, f.body, off-f.pos)
+	f.pos += n
+}
+
+func (f *File) closeBody() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+// Fetch a range in full, retrying as needed.
+func (f *File) fetch(off, end int64) ([]byte, error) {
+	var data []byte
+
+	err := f.retry(func() error {
+		body, err := f.backend.openRange(off, end)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		data, err = io.ReadAll(body)
+		if err == nil && int64(len(data)) != end-off {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	})
+
+	return data, err
+}
+
+func (f *File) retry(fn func() error) error {
+	var err error
+
+	for attempt := 0; attempt <= f.retries; attempt++ {
+		if attempt > 0 {
+			backoff(attempt)
+		}
+
+		err = fn()
+		if err == nil || permanent(err) {
+			break
+		}
+	}
+
+	return err
+}
+
+// Keep everything from the start of the central directory to the end
+// of the file in memory.
+func (f *File) fetchTail() error {
+	f.tailOffset = max(f.size-tailProbeLen, 0)
+	tail, err := f.fetch(f.tailOffset, f.size)
+	if err != nil {
+		return err
+	}
+	f.tail = tail
+
+	start, ok := f.directoryStart()
+	if !ok || start >= f.tailOffset {
+		return nil
+	}
+
+	head, err := f.fetch(start, f.tailOffset)
+	if err != nil {
+		return err
+	}
+	f.tail = append(head, f.tail...)
+	f.tailOffset = start
+
+	return nil
+}
+
+// Find where the central directory starts from the end of central
+// directory record in the tail.
+func (f *File) directoryStart() (int64, bool) {
+	tail := f.tail
+
+	eocd := -1
+	for i := len(tail) - 22; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == 0x06054b50 {
+			eocd = i
+			break
+		}
+	}
+	if eocd < 0 {
+		return 0, false
+	}
+
+	offset := int64(binary.LittleEndian.Uint32(tail[eocd+16:]))
+	if offset != 0xffffffff {
+		return offset, true
+	}
+
+	// A zip64 end of central directory locator precedes the record
+	// and points to the zip64 record holding the real offset.
+	locator := eocd - 20
+	if locator < 0 ||
+		binary.LittleEndian.Uint32(tail[locator:]) != 0x07064b50 {
+		return 0, false
+	}
+	record := int64(binary.LittleEndian.Uint64(tail[locator+8:]))
+	if record < f.tailOffset {
+		data, err := f.fetch(record, record+56)
+		if err != nil {
+			return 0, false
+		}
+		return int64(binary.LittleEndian.Uint64(data[48:])), true
+	}
+
+	i := record - f.tailOffset
+	if i+56 > int64(len(tail)) {
+		return 0, false
+	}
+
+	return int64(binary.LittleEndian.Uint64(tail[i+48:])), true
+}
+
+// Report whether retrying can't help.
+func permanent(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.permanent()
+	}
+
+	return errors.Is(err, ErrNoRanges) || errors.Is(err, ErrChanged)
+}
+
+func backoff(attempt int) {
+	delay := time.Duration(attempt) * time.Second
+	if delay > 30*time.Second {
+		delay = 30 * time.Second
+	}
+	time.Sleep(delay)
+}
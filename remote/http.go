@@ -0,0 +1,172 @@
+package remote
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A request fails when the server takes longer than this to answer,
+// or to send more of the body, so that it can be retried. A timeout on
+// the whole request would cut off long streams.
+const stallTimeout = time.Minute
+
+var httpClient = newHTTPClient()
+
+func newHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = stallTimeout
+
+	return &http.Client{Transport: transport}
+}
+
+// An unexpected response, which is worth retrying unless the server
+// says the request itself is at fault.
+type statusError struct {
+	url    string
+	status string
+	code   int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.url, e.status)
+}
+
+func (e *statusError) permanent() bool {
+	return e.code >= 400 && e.code < 500 &&
+		e.code != http.StatusRequestTimeout &&
+		e.code != http.StatusTooManyRequests
+}
+
+type httpBackend struct {
+	url    string
+	client *http.Client
+	etag   string
+
+	// Signs requests for stores which need them signed, nil when not.
+	sign func(req *http.Request)
+}
+
+// Open url for reading using range requests, retrying failed requests
+// up to retries times.
+func OpenHTTP(url string, retries int) (*File, error) {
+	return open(&httpBackend{url: url, client: httpClient}, retries)
+}
+
+// OpenSigned is like OpenHTTP, passing every request through sign
+// before sending it, for object stores which need signed requests.
+func OpenSigned(url string, sign func(req *http.Request),
+	retries int) (*File, error) {
+
+	return open(&httpBackend{url: url, client: httpClient,
+		sign: sign}, retries)
+}
+
+func (b *httpBackend) size() (int64, error) {
+	resp, err := b.get("bytes=0-0")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	b.etag = resp.Header.Get("ETag")
+	if strings.HasPrefix(b.etag, "W/") {
+		// Weak validators can't be used with If-Match.
+		b.etag = ""
+	}
+
+	return contentRangeSize(resp.Header.Get("Content-Range"))
+}
+
+func (b *httpBackend) openRange(off, end int64) (io.ReadCloser, error) {
+	resp, err := b.get(fmt.Sprintf("bytes=%d-%d", off, end-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Perform a range request, making sure the file did not change since
+// it was first opened. The body is cancelled when it stalls.
+func (b *httpBackend) get(byteRange string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.url, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Range", byteRange)
+	if b.etag != "" {
+		req.Header.Set("If-Match", b.etag)
+	}
+	if b.sign != nil {
+		b.sign(req)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		resp.Body = &stallReader{
+			body:   resp.Body,
+			cancel: cancel,
+			timer:  time.AfterFunc(stallTimeout, cancel)}
+		return resp, nil
+	case http.StatusOK:
+		err = ErrNoRanges
+	case http.StatusPreconditionFailed:
+		err = fmt.Errorf("%s: %w", b.url, ErrChanged)
+	default:
+		err = &statusError{b.url, resp.Status, resp.StatusCode}
+	}
+	resp.Body.Close()
+	cancel()
+
+	return nil, err
+}
+
+// Cancels the request when no data arrives for stallTimeout.
+type stallReader struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	timer  *time.Timer
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.body.Read(p)
+	r.timer.Reset(stallTimeout)
+
+	return n, err
+}
+
+func (r *stallReader) Close() error {
+	r.timer.Stop()
+	err := r.body.Close()
+	r.cancel()
+
+	return err
+}
+
+// Parse the total size from a "bytes first-last/total" header.
+func contentRangeSize(contentRange string) (int64, error) {
+	i := strings.LastIndexByte(contentRange, '/')
+	if i < 0 {
+		return 0, fmt.Errorf("Invalid Content-Range %q.", contentRange)
+	}
+
+	size, err := strconv.ParseInt(contentRange[i+1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid Content-Range %q.", contentRange)
+	}
+
+	return size, nil
+}
@@ -0,0 +1,110 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+type rcloneBackend struct {
+	path string
+}
+
+// Open a file on an rclone remote, given as remote:path, through the
+// rclone command; any backend rclone has been configured for works.
+func OpenRclone(path string, retries int) (*File, error) {
+	return open(&rcloneBackend{path: path}, retries)
+}
+
+// Report whether a name refers to an rclone remote, remote:path, as
+// opposed to a local path or a URL.
+func IsRcloneRemote(name string) bool {
+	i := strings.IndexByte(name, ':')
+
+	// Single letters are Windows drive letters.
+	return i > 1 &&
+		!strings.ContainsAny(name[:i], `/\`) &&
+		!strings.HasPrefix(name[i+1:], "//")
+}
+
+func (b *rcloneBackend) size() (int64, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("rclone", "lsjson", "--stat", b.path)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("rclone lsjson %s: %v: %s",
+			b.path, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var stat struct {
+		Size  int64
+		IsDir bool
+	}
+	if err := json.Unmarshal(out, &stat); err != nil {
+		return 0, err
+	}
+	if stat.IsDir {
+		return 0, fmt.Errorf("%s is a directory.", b.path)
+	}
+
+	return stat.Size, nil
+}
+
+func (b *rcloneBackend) openRange(off, end int64) (io.ReadCloser, error) {
+	cmd := exec.Command("rclone", "cat",
+		"--offset", strconv.FormatInt(off, 10),
+		"--count", strconv.FormatInt(end-off, 10),
+		b.path)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandReader{cmd: cmd, out: out}, nil
+}
+
+// The output of a command, which fails rather than ends when the
+// command does.
+type commandReader struct {
+	cmd  *exec.Cmd
+	out  io.ReadCloser
+	done bool
+}
+
+func (r *commandReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+
+	n, err := r.out.Read(p)
+	if err == io.EOF {
+		r.done = true
+		if waitErr := r.cmd.Wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
+
+func (r *commandReader) Close() error {
+	r.out.Close()
+	if r.cmd.ProcessState == nil {
+		r.cmd.Process.Kill()
+		r.cmd.Wait()
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Parts filled less than this fraction of the split size are emptied
+// into the others when rebalancing.
+const rebalanceFraction = 4
+
+// Remove entries from a split set, rewriting only the parts which
+// hold them.
+func removeCommand(args []string) {
+	flags := flag.NewFlagSet("remove", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Manifest of the split set to remove entries from.")
+
+	rebalance := flags.Bool(
+		"rebalance",
+		false,
+		"Move the entries of parts which end up less than a quarter\n"+
+			"full into the other parts and delete them.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Show some information about the process.")
+
+	flags.Parse(args)
+
+	if *manifestName == "" || flags.NArg() == 0 {
+		fatal(errors.New(
+			"Usage: zipsplit remove -manifest manifest.json entry..."))
+	}
+
+	m, err := manifest.Load(*manifestName)
+	if err != nil {
+		fatal(err)
+	}
+
+	config := Config{
+		splitSize: m.SplitSize,
+		measure:   m.Measure,
+		format:    formatZip,
+		overhead:  split.DefaultOverhead,
+		verbose:   *verbose}
+
+	affected, err := removeEntries(m, flags.Args(), config)
+	if err != nil {
+		fatal(err)
+	}
+
+	if *rebalance {
+		if config.splitSize == 0 {
+			fatal(errors.New("Unknown split size, can't rebalance."))
+		}
+		if err := rebalanceParts(m, affected, config); err != nil {
+			fatal(err)
+		}
+	}
+
+	recordPartSums(m)
+	if err := m.Save(*manifestName); err != nil {
+		fatal(err)
+	}
+}
+
+// Remove the named entries, returning the names of the parts which
+// were changed. Parts left without entries are kept as empty archives,
+// so the parts keep their numbers and the set can still be added to.
+// Only rebalancing deletes parts.
+func removeEntries(m *manifest.Manifest, names []string,
+	config Config) ([]string, error) {
+
+	byPart := make(map[int]map[string]bool)
+	for _, name := range names {
+		i, _, ok := m.Find(name)
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the split set.", name)
+		}
+		if byPart[i] == nil {
+			byPart[i] = make(map[string]bool)
+		}
+		byPart[i][name] = true
+	}
+
+	var affected []string
+
+	for i := range m.Parts {
+		removed, ok := byPart[i]
+		if !ok {
+			continue
+		}
+		part := &m.Parts[i]
+
+		entries := []manifest.Entry{}
+		for _, entry := range part.Entries {
+			if !removed[entry.Name] {
+				entries = append(entries, entry)
+			}
+		}
+
+		if config.verbose {
+			fmt.Fprintf(progress, "Removing %d entries from %s..", len(removed),
+				part.Name)
+		}
+		keep := func(f *zip.File) bool {
+			return !removed[f.Name]
+		}
+		if err := rewritePart(part.Name, keep, nil, 0, config); err != nil {
+			return nil, err
+		}
+		if config.verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+
+		part.Entries = entries
+		affected = append(affected, part.Name)
+	}
+
+	return affected, nil
+}
+
+// Empty the given parts into the others when they became mostly empty
+// and the others have room for their entries.
+func rebalanceParts(m *manifest.Manifest, candidates []string,
+	config Config) error {
+
+	for _, name := range candidates {
+		donor := -1
+		for i, part := range m.Parts {
+			if part.Name == name {
+				donor = i
+			}
+		}
+		if donor < 0 {
+			continue
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if uint64(info.Size()) >= config.splitSize/rebalanceFraction {
+			continue
+		}
+
+		if err := emptyPart(m, donor, config); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Move all entries of a part into the room left in the other parts,
+// deleting it. When they don't all fit nothing is changed.
+func emptyPart(m *manifest.Manifest, donor int, config Config) error {
+	overhead := partOverhead(config)
+	if config.splitSize <= overhead {
+		return errors.New("Split size too small.")
+	}
+	capacity := config.splitSize - overhead
+
+	var bins []*pack.Bin
+	var others []int
+	for i, part := range m.Parts {
+		if i == donor {
+			continue
+		}
+		info, err := os.Stat(part.Name)
+		if err != nil {
+			return err
+		}
+		size := uint64(info.Size())
+		bins = append(bins, &pack.Bin{Size: size - min(size, overhead)})
+		others = append(others, i)
+	}
+
+	// The entries are copied as they are, so what the manifest says
+	// about them, their digests and offsets in the source included,
+	// still holds.
+	donorName := m.Parts[donor].Name
+	donorEntries := make(map[string]manifest.Entry)
+	for _, entry := range m.Parts[donor].Entries {
+		donorEntries[entry.Name] = entry
+	}
+	r, err := zip.OpenReader(donorName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var items []pack.Item
+	donorFiles := make(map[*zip.FileHeader]*zip.File)
+	for _, f := range r.File {
+		items = append(items, zipItem{&f.FileHeader,
+			entrySize(&f.FileHeader, config)})
+		donorFiles[&f.FileHeader] = f
+	}
+
+	filled, err := pack.Fill(bins, items, capacity)
+	if err != nil {
+		return err
+	}
+	if len(filled) > len(bins) {
+		// Some entries would need a part of their own anyway.
+		return nil
+	}
+
+	for i, bin := range filled {
+		if len(bin.Items) == 0 {
+			continue
+		}
+		part := &m.Parts[others[i]]
+
+		if config.verbose {
+			fmt.Fprintf(progress, "Moving %d entries from %s to %s..",
+				len(bin.Items), donorName, part.Name)
+		}
+		err := rewritePart(part.Name, nil, func(w *split.Writer) error {
+			for _, item := range bin.Items {
+				f := donorFiles[item.(zipItem).file]
+				if err := split.CopyEntry(w, f, config.align); err != nil {
+					return err
+				}
+				entry, ok := donorEntries[f.Name]
+				if !ok {
+					entry = manifestEntry(&f.FileHeader)
+				}
+				part.Entries = append(part.Entries, entry)
+			}
+			return nil
+		}, config.splitSize, config)
+		if err != nil {
+			return err
+		}
+		if config.verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+	}
+
+	r.Close()
+	if err := os.Remove(donorName); err != nil {
+		return err
+	}
+	m.Parts = append(m.Parts[:donor], m.Parts[donor+1:]...)
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Deleted %s.\n", donorName)
+	}
+
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// Report formats.
+const reportJSON = "json"
+
+// What the JSON report tells of a part.
+type reportPart struct {
+	Path       string   `json:"path"`
+	Size       uint64   `json:"size"`
+	EntryCount int      `json:"entry_count"`
+	Entries    []string `json:"entries"`
+}
+
+// Print the parts of the split set to stdout as a JSON document, for
+// scripts to go on with. Parts kept from an earlier run are measured
+// where they are.
+func printReport(buckets []*Bucket, config Config) error {
+	sizes := make(map[string]uint64)
+	for _, part := range append(keptParts, writtenParts...) {
+		sizes[part.Name] = part.Size
+	}
+
+	var report struct {
+		Parts []reportPart `json:"parts"`
+	}
+	for _, bucket := range buckets {
+		part := reportPart{
+			Path:       bucket.Name,
+			EntryCount: len(bucket.Files),
+			Entries:    []string{}}
+		if name, err := partPath(bucket.Name, config); err == nil {
+			part.Path = name
+		}
+
+		size, ok := sizes[bucket.Name]
+		if !ok {
+			info, err := os.Stat(part.Path)
+			if err != nil {
+				return err
+			}
+			size = uint64(info.Size())
+		}
+		part.Size = size
+
+		for _, file := range bucket.Files {
+			part.Entries = append(part.Entries, file.Name)
+		}
+		report.Parts = append(report.Parts, part)
+	}
+
+	w := json.NewEncoder(os.Stdout)
+	w.SetIndent("", "  ")
+	return w.Encode(report)
+}
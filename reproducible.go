@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// Where build systems put the time to give what they make, see
+// https://reproducible-builds.org/specs/source-date-epoch/.
+const sourceDateEnv = "SOURCE_DATE_EPOCH"
+
+// Return the time to give the entries of reproducible parts, the one
+// in SOURCE_DATE_EPOCH or else the earliest a zip entry can have.
+func reproducibleTime() (time.Time, error) {
+	epoch := os.Getenv(sourceDateEnv)
+	if epoch == "" {
+		return zipEpoch, nil
+	}
+
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil || seconds < zipEpoch.Unix() {
+		return time.Time{}, fmt.Errorf("Invalid %s %q.",
+			sourceDateEnv, epoch)
+	}
+
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// Put the entries in order of their name and give them all the same
+// time, so the same entries always make the same parts. A time other
+// than the zip epoch is kept in an extended timestamp as well, which
+// unlike the MS-DOS fields doesn't depend on the time zone.
+func normalizeEntries(files []*zip.FileHeader, t time.Time) {
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	for _, file := range files {
+		// The password can check the time of entries encrypted the
+		// traditional way.
+		if zipcrypt.IsEncrypted(file) {
+			continue
+		}
+
+		setEntryTime(file, t)
+		if !t.Equal(zipEpoch) {
+			file.Extra = binary.LittleEndian.AppendUint16(file.Extra,
+				extTimeExtraID)
+			file.Extra = binary.LittleEndian.AppendUint16(file.Extra, 5)
+			file.Extra = append(file.Extra, 1)
+			file.Extra = binary.LittleEndian.AppendUint32(file.Extra,
+				uint32(t.Unix()))
+		}
+	}
+}
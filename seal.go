@@ -0,0 +1,258 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Parts are sealed with a line in their comment naming the set, the
+// place of the part in it and a digest of all entries of the set,
+// signed with an ed25519 key.
+const sealPrefix = "zipsplit-seal:1:"
+
+// The most room a seal takes up in a part comment, including the line
+// break separating it from any other comment; part numbers won't get
+// anywhere near eight digits.
+const maxSealSize = len(sealPrefix) + 32 + 1 + 8 + 1 + 8 + 1 +
+	sha256.Size*2 + 1 + ed25519.SignatureSize*2 + 1
+
+// What a seal says about a part.
+type seal struct {
+	setID     string
+	part      int
+	total     int
+	setDigest string
+}
+
+func (s seal) message() string {
+	return fmt.Sprintf("%s%s:%d:%d:%s",
+		sealPrefix, s.setID, s.part, s.total, s.setDigest)
+}
+
+// Read a private key, stored as the hex encoded seed.
+func loadSealKey(name string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not a seal key.", name)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// Return a random identifier for a split set.
+func newSetID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Hash the entries of every part in order, so a seal covers the names,
+// checksums and contents of the whole set.
+type setDigest struct {
+	h io.Writer
+}
+
+func (d setDigest) add(part int, name string, crc uint32, size uint64,
+	digest string) {
+
+	fmt.Fprintf(d.h, "%d\t%s\t%08x\t%d\t%s\n", part, name, crc, size, digest)
+}
+
+// Add a seal to the comment of every part, signed with the key.
+func sealBuckets(buckets []*Bucket, contents *sourceContents,
+	config Config) {
+
+	// Directory entries recreated for a part are not in the source,
+	// but are written without data like any other.
+	emptyDigest := hex.EncodeToString(sha256.New().Sum(nil))
+
+	h := sha256.New()
+	digest := setDigest{h}
+	for i, bucket := range buckets {
+		for _, file := range bucket.Files {
+			fileDigest := contents.digests[file]
+			if bucket.Dirs[file] {
+				fileDigest = emptyDigest
+			}
+			digest.add(i+1, file.Name, file.CRC32,
+				file.UncompressedSize64, fileDigest)
+		}
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	for i, bucket := range buckets {
+		s := seal{config.setID, i + 1, len(buckets), sum}
+		line := s.message() + ":" +
+			hex.EncodeToString(ed25519.Sign(config.sealKey, []byte(s.message())))
+
+		if bucket.Comment != "" {
+			bucket.Comment += "\n"
+		}
+		bucket.Comment += line
+	}
+}
+
+// Find and check the seal at the end of a part comment.
+func readSeal(comment string, key ed25519.PublicKey) (seal, error) {
+	line := comment[strings.LastIndex(comment, "\n")+1:]
+	if !strings.HasPrefix(line, sealPrefix) {
+		return seal{}, errors.New("not sealed")
+	}
+
+	fields := strings.Split(strings.TrimPrefix(line, sealPrefix), ":")
+	if len(fields) != 5 {
+		return seal{}, errors.New("malformed seal")
+	}
+
+	var s seal
+	var err1, err2 error
+	s.setID = fields[0]
+	s.part, err1 = strconv.Atoi(fields[1])
+	s.total, err2 = strconv.Atoi(fields[2])
+	s.setDigest = fields[3]
+	signature, err3 := hex.DecodeString(fields[4])
+	if err1 != nil || err2 != nil || err3 != nil {
+		return seal{}, errors.New("malformed seal")
+	}
+
+	if !ed25519.Verify(key, []byte(s.message()), signature) {
+		return seal{}, errors.New("seal signature does not match")
+	}
+
+	return s, nil
+}
+
+// Return the SHA-256 digest of the data of an entry, or nothing when
+// it can't be read, like when it was sealed.
+func entryDigest(f *zip.File) string {
+	digest, err := checkEntry(f, hashSHA256)
+	if err != nil {
+		return ""
+	}
+
+	return digest
+}
+
+// Create a key for sealing parts, printing the public key to verify
+// them with.
+func sealKeygenCommand(args []string) {
+	flags := flag.NewFlagSet("seal-keygen", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fatal(errors.New("Usage: zipsplit seal-keygen key-file"))
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		fatal(err)
+	}
+
+	seed := hex.EncodeToString(private.Seed()) + "\n"
+	err = os.WriteFile(flags.Arg(0), []byte(seed), 0600)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Println(hex.EncodeToString(public))
+}
+
+// Check that the given parts form a complete sealed set, unchanged
+// since they were written.
+func verifySealCommand(args []string) {
+	flags := flag.NewFlagSet("verify-seal", flag.ExitOnError)
+
+	publicKey := flags.String(
+		"public-key",
+		"",
+		"The hex encoded public key of the key the parts were sealed with.")
+
+	flags.Parse(args)
+
+	if *publicKey == "" || flags.NArg() == 0 {
+		fatal(errors.New(
+			"Usage: zipsplit verify-seal -public-key key part..."))
+	}
+
+	key, err := hex.DecodeString(*publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		fatal(errors.New("Invalid public key."))
+	}
+
+	if err := verifySeals(flags.Args(), key); err != nil {
+		fatal(err)
+	}
+
+	fmt.Printf("All %d parts are sealed and unchanged.\n", flags.NArg())
+}
+
+func verifySeals(parts []string, key ed25519.PublicKey) error {
+	seals := make(map[int]seal)
+	files := make(map[int][]*zip.File)
+	var first seal
+
+	for _, name := range parts {
+		r, err := zip.OpenReader(name)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		s, err := readSeal(r.Comment, key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		if len(seals) == 0 {
+			first = s
+		}
+		if s.setID != first.setID || s.total != first.total ||
+			s.setDigest != first.setDigest {
+			return fmt.Errorf("%s: belongs to another split set.", name)
+		}
+		if _, ok := seals[s.part]; ok {
+			return fmt.Errorf("%s: part %d given twice.", name, s.part)
+		}
+
+		seals[s.part] = s
+		files[s.part] = r.File
+	}
+
+	for part := 1; part <= first.total; part++ {
+		if _, ok := seals[part]; !ok {
+			return fmt.Errorf("Part %d of %d is missing.", part, first.total)
+		}
+	}
+
+	h := sha256.New()
+	digest := setDigest{h}
+	for part := 1; part <= first.total; part++ {
+		for _, f := range files[part] {
+			digest.add(part, f.Name, f.CRC32, f.UncompressedSize64,
+				entryDigest(f))
+		}
+	}
+	if hex.EncodeToString(h.Sum(nil)) != first.setDigest {
+		return errors.New("The entries of the parts were changed.")
+	}
+
+	return nil
+}
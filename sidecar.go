@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/torrent"
+)
+
+// Write a torrent covering all parts, named after the torrent file.
+func makeTorrent(buckets []*Bucket, config Config) error {
+	var paths []string
+	for _, bucket := range buckets {
+		path, err := partPath(bucket.Name, config)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, path)
+	}
+
+	var total int64
+	for _, bucket := range buckets {
+		total += int64(bucket.Size)
+	}
+
+	name := filepath.Base(config.torrentName)
+	name = strings.TrimSuffix(name, filepath.Ext(name))
+
+	t, err := torrent.Create(name, paths, torrent.PieceLength(total))
+	if err != nil {
+		return err
+	}
+	t.Announce = config.announce
+
+	return t.Save(config.torrentName)
+}
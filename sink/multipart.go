@@ -0,0 +1,190 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Parts are uploaded in pieces of this size once they grow past it.
+// S3 needs all pieces but the last to be at least 5MiB.
+const s3PieceSize = 16 << 20
+
+type s3Part struct {
+	sink *S3
+	key  string
+	buf  bytes.Buffer
+
+	// Set once the first piece is uploaded.
+	uploadID string
+	etags    []string
+
+	// Why uploading a piece failed, which Close leaves it at.
+	err error
+}
+
+func (p *s3Part) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+
+	p.buf.Write(b)
+	for p.buf.Len() >= s3PieceSize {
+		if p.err = p.uploadPiece(p.buf.Next(s3PieceSize)); p.err != nil {
+			return len(b), p.err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Upload what is left, and put the pieces together when there are
+// any. A failed multipart upload is aborted so its pieces don't linger
+// in the bucket.
+func (p *s3Part) Close() error {
+	if p.uploadID == "" && p.err == nil {
+		return p.sink.put(p.key, p.buf.Bytes())
+	}
+
+	err := p.err
+	if err == nil {
+		err = p.uploadPiece(p.buf.Bytes())
+	}
+	if err == nil {
+		err = p.complete()
+	}
+	if err != nil && p.uploadID != "" {
+		p.abort()
+	}
+
+	return err
+}
+
+func (p *s3Part) uploadPiece(data []byte) error {
+	if p.uploadID == "" {
+		id, err := p.sink.startUpload(p.key)
+		if err != nil {
+			return err
+		}
+		p.uploadID = id
+	}
+
+	query := url.Values{
+		"partNumber": {strconv.Itoa(len(p.etags) + 1)},
+		"uploadId":   {p.uploadID}}
+	req, err := http.NewRequest(http.MethodPut,
+		p.sink.ObjectURL(p.key)+"?"+query.Encode(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+	p.sink.Sign(req, data)
+
+	header, err := p.sink.do(req, nil)
+	if err != nil {
+		return err
+	}
+	p.etags = append(p.etags, header.Get("ETag"))
+
+	return nil
+}
+
+// The listing of the pieces which completes a multipart upload.
+type completeUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completePiece `xml:"Part"`
+}
+
+type completePiece struct {
+	PartNumber int
+	ETag       string
+}
+
+func (p *s3Part) complete() error {
+	var listing completeUpload
+	for i, etag := range p.etags {
+		listing.Parts = append(listing.Parts, completePiece{i + 1, etag})
+	}
+	body, err := xml.Marshal(listing)
+	if err != nil {
+		return err
+	}
+
+	query := url.Values{"uploadId": {p.uploadID}}
+	req, err := http.NewRequest(http.MethodPost,
+		p.sink.ObjectURL(p.key)+"?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	p.sink.Sign(req, body)
+
+	// Completing can fail after the response has started, which is
+	// then told in its body.
+	var result bytes.Buffer
+	if _, err := p.sink.do(req, &result); err != nil {
+		return err
+	}
+	var failure struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(result.Bytes(), &failure) == nil {
+		return fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path,
+			failure.Code, failure.Message)
+	}
+
+	return nil
+}
+
+// Abort drops what was written of the part, and the pieces of it which
+// were uploaded.
+func (p *s3Part) Abort() error {
+	if p.uploadID != "" {
+		p.abort()
+	}
+
+	return nil
+}
+
+func (p *s3Part) abort() {
+	query := url.Values{"uploadId": {p.uploadID}}
+	req, err := http.NewRequest(http.MethodDelete,
+		p.sink.ObjectURL(p.key)+"?"+query.Encode(), nil)
+	if err != nil {
+		return
+	}
+	p.sink.Sign(req, nil)
+	p.sink.do(req, nil)
+}
+
+// Start a multipart upload of an object, returning its upload ID.
+func (s *S3) startUpload(key string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, s.ObjectURL(key)+"?uploads",
+		nil)
+	if err != nil {
+		return "", err
+	}
+	s.Sign(req, nil)
+
+	var body bytes.Buffer
+	if _, err := s.do(req, &body); err != nil {
+		return "", err
+	}
+
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body.Bytes(), &result); err != nil {
+		return "", err
+	}
+	if result.UploadID == "" {
+		return "", fmt.Errorf("S3 %s: no upload ID.", key)
+	}
+
+	return result.UploadID, nil
+}
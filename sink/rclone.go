@@ -0,0 +1,51 @@
+package sink
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Rclone writes parts to rclone remotes, with part names of the form
+// remote:path, by streaming them into rclone rcat.
+type Rclone struct{}
+
+func (Rclone) NewPart(name string) (io.WriteCloser, error) {
+	cmd := exec.Command("rclone", "rcat", name)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &commandWriter{WriteCloser: in, cmd: cmd}, nil
+}
+
+// The input of a command; closing it waits for the command to finish.
+type commandWriter struct {
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// Abort stops the command before it gets to the end of its input.
+func (w *commandWriter) Abort() error {
+	w.cmd.Process.Kill()
+	w.WriteCloser.Close()
+	w.cmd.Wait()
+
+	return nil
+}
+
+func (w *commandWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if waitErr := w.cmd.Wait(); err == nil {
+		err = waitErr
+	}
+
+	return err
+}
@@ -0,0 +1,271 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3 uploads parts as objects to an S3 compatible object store. Small
+// parts are buffered in memory and uploaded when closed, larger ones
+// are uploaded in pieces as they are written, so memory use is bounded
+// by the piece size.
+type S3 struct {
+	Bucket string
+	Prefix string
+	Region string
+
+	// Endpoint overrides the AWS endpoint for other S3 compatible
+	// stores; requests then use path style addressing.
+	Endpoint string
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	Client *http.Client
+}
+
+// Return an S3 sink configured from the usual AWS environment
+// variables.
+func NewS3FromEnv(bucket, prefix string) *S3 {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3{
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          region,
+		Endpoint:        os.Getenv("AWS_ENDPOINT_URL_S3"),
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN")}
+}
+
+// Return a sink for a Google Cloud Storage bucket, using its S3
+// compatible API with the HMAC key in GS_ACCESS_KEY_ID and
+// GS_SECRET_ACCESS_KEY.
+func NewGCSFromEnv(bucket, prefix string) *S3 {
+	return &S3{
+		Bucket:          bucket,
+		Prefix:          prefix,
+		Region:          "auto",
+		Endpoint:        "https://storage.googleapis.com",
+		AccessKeyID:     os.Getenv("GS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("GS_SECRET_ACCESS_KEY")}
+}
+
+// IsObjectURL reports whether name is an s3:// or gs:// URL.
+func IsObjectURL(name string) bool {
+	return strings.HasPrefix(name, "s3://") || strings.HasPrefix(name, "gs://")
+}
+
+// ParseObjectURL returns a sink for the bucket of an s3:// or gs://
+// URL, configured from the environment, and the key the URL names.
+func ParseObjectURL(name string) (*S3, string, error) {
+	scheme, rest, _ := strings.Cut(name, "://")
+	bucket, key, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, "", fmt.Errorf("Invalid object URL %q.", name)
+	}
+
+	if scheme == "gs" {
+		return NewGCSFromEnv(bucket, ""), key, nil
+	}
+
+	return NewS3FromEnv(bucket, ""), key, nil
+}
+
+// NewPart creates the object name, below Prefix, or the object it
+// names when it is a URL of the bucket.
+func (s *S3) NewPart(name string) (io.WriteCloser, error) {
+	key := path.Join(s.Prefix, name)
+	if IsObjectURL(name) {
+		_, urlKey, err := ParseObjectURL(name)
+		if err != nil {
+			return nil, err
+		}
+		key = path.Join(s.Prefix, urlKey)
+	}
+
+	return &s3Part{sink: s, key: key}, nil
+}
+
+// ObjectURL returns the https URL of an object in the bucket.
+func (s *S3) ObjectURL(key string) string {
+	escaped := escapePath(key)
+	if s.Endpoint != "" {
+		return strings.TrimSuffix(s.Endpoint, "/") + "/" +
+			url.PathEscape(s.Bucket) + "/" + escaped
+	}
+
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s",
+		s.Bucket, s.Region, escaped)
+}
+
+func (s *S3) put(key string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, s.ObjectURL(key),
+		bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(data))
+
+	s.Sign(req, data)
+
+	_, err = s.do(req, nil)
+	return err
+}
+
+// Perform a signed request, decoding a failure into an error, and
+// return the headers of the response. When out is not nil the response
+// body is copied into it.
+func (s *S3) do(req *http.Request, out io.Writer) (http.Header, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("S3 %s %s: %s: %s", req.Method, req.URL.Path,
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	if out != nil {
+		_, err = io.Copy(out, resp.Body)
+	}
+
+	return resp.Header, err
+}
+
+// Sign adds an AWS signature version 4 to a request with the given
+// body, nil for none.
+func (s *S3) Sign(req *http.Request, payload []byte) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(payload)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") ||
+			lower == "range" || lower == "content-md5" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:])}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretAccessKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		vals := values[key]
+		sort.Strings(vals)
+		for _, val := range vals {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(val))
+		}
+	}
+
+	return strings.Join(pairs, "&")
+}
+
+func escapePath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = awsEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// Escape everything but the RFC 3986 unreserved characters, which is
+// what the signature calculation expects.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' ||
+			'0' <= c && c <= '9' || strings.IndexByte("-_.~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
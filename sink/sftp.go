@@ -0,0 +1,259 @@
+package sink
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"sync"
+)
+
+// SFTP protocol version 3 packet types and flags.
+const (
+	sshFxpInit    = 1
+	sshFxpVersion = 2
+	sshFxpOpen    = 3
+	sshFxpClose   = 4
+	sshFxpWrite   = 6
+	sshFxpStatus  = 101
+	sshFxpHandle  = 102
+
+	sshFxfWrite = 0x02
+	sshFxfCreat = 0x08
+	sshFxfTrunc = 0x10
+
+	sshFxOK = 0
+
+	// Stay well below the 32k packet size all servers must accept.
+	sftpChunkSize = 32 * 1024
+)
+
+// SFTP uploads parts to Dir on Host using the sftp subsystem of the
+// system ssh client, so keys, agents and ~/.ssh/config all apply.
+type SFTP struct {
+	Host string
+	Dir  string
+
+	// Extra arguments to pass to ssh, such as a port or identity.
+	SSHArgs []string
+
+	mu     sync.Mutex
+	cmd    *exec.Cmd
+	in     io.WriteCloser
+	out    *bufio.Reader
+	nextID uint32
+}
+
+func (s *SFTP) NewPart(name string) (io.WriteCloser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	var payload []byte
+	payload = appendString(payload, path.Join(s.Dir, name))
+	payload = binary.BigEndian.AppendUint32(payload,
+		sshFxfWrite|sshFxfCreat|sshFxfTrunc)
+	payload = binary.BigEndian.AppendUint32(payload, 0) // no attributes
+
+	typ, data, err := s.request(sshFxpOpen, payload)
+	if err != nil {
+		return nil, err
+	}
+	if typ != sshFxpHandle {
+		return nil, statusError(typ, data, name)
+	}
+	handle, _, err := readString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sftpPart{sink: s, name: name, handle: handle}, nil
+}
+
+// Close the ssh connection.
+func (s *SFTP) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cmd == nil {
+		return nil
+	}
+	s.in.Close()
+	err := s.cmd.Wait()
+	s.cmd = nil
+
+	return err
+}
+
+func (s *SFTP) connect() error {
+	args := append(append([]string{}, s.SSHArgs...), "-s", s.Host, "sftp")
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.cmd, s.in, s.out = cmd, in, bufio.NewReader(out)
+
+	// The init packet has a version where other requests have an id.
+	init := binary.BigEndian.AppendUint32(nil, 5)
+	init = append(init, sshFxpInit)
+	init = binary.BigEndian.AppendUint32(init, 3)
+	if _, err := s.in.Write(init); err != nil {
+		return err
+	}
+
+	typ, _, err := s.readPacket()
+	if err != nil {
+		return err
+	}
+	if typ != sshFxpVersion {
+		return fmt.Errorf("Unexpected SFTP packet %d from %s.", typ, s.Host)
+	}
+
+	return nil
+}
+
+// Send a request and wait for its response, returning the response
+// type and the payload following the request id.
+func (s *SFTP) request(typ byte, payload []byte) (byte, []byte, error) {
+	s.nextID++
+	id := s.nextID
+
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(payload)+5))
+	packet = append(packet, typ)
+	packet = binary.BigEndian.AppendUint32(packet, id)
+	packet = append(packet, payload...)
+	if _, err := s.in.Write(packet); err != nil {
+		return 0, nil, err
+	}
+
+	respType, resp, err := s.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != id {
+		return 0, nil, errors.New("SFTP response out of sequence.")
+	}
+
+	return respType, resp[4:], nil
+}
+
+func (s *SFTP) readPacket() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.out, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > 256*1024 {
+		return 0, nil, errors.New("Invalid SFTP packet length.")
+	}
+
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(s.out, data); err != nil {
+		return 0, nil, err
+	}
+
+	return header[4], data, nil
+}
+
+type sftpPart struct {
+	sink   *SFTP
+	name   string
+	handle string
+	offset uint64
+}
+
+func (p *sftpPart) Write(b []byte) (int, error) {
+	p.sink.mu.Lock()
+	defer p.sink.mu.Unlock()
+
+	written := 0
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > sftpChunkSize {
+			chunk = chunk[:sftpChunkSize]
+		}
+
+		var payload []byte
+		payload = appendString(payload, p.handle)
+		payload = binary.BigEndian.AppendUint64(payload, p.offset)
+		payload = appendString(payload, string(chunk))
+
+		typ, data, err := p.sink.request(sshFxpWrite, payload)
+		if err == nil {
+			err = statusError(typ, data, p.name)
+		}
+		if err != nil {
+			return written, err
+		}
+
+		p.offset += uint64(len(chunk))
+		written += len(chunk)
+		b = b[len(chunk):]
+	}
+
+	return written, nil
+}
+
+func (p *sftpPart) Close() error {
+	p.sink.mu.Lock()
+	defer p.sink.mu.Unlock()
+
+	typ, data, err := p.sink.request(sshFxpClose, appendString(nil, p.handle))
+	if err != nil {
+		return err
+	}
+
+	return statusError(typ, data, p.name)
+}
+
+// Turn a status response into an error, nil when it reports success.
+func statusError(typ byte, data []byte, name string) error {
+	if typ != sshFxpStatus || len(data) < 4 {
+		return fmt.Errorf("Unexpected SFTP packet %d for %s.", typ, name)
+	}
+
+	code := binary.BigEndian.Uint32(data)
+	if code == sshFxOK {
+		return nil
+	}
+
+	msg, _, _ := readString(data[4:])
+	return fmt.Errorf("SFTP %s: %s (status %d).", name, msg, code)
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func readString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, errors.New("Short SFTP packet.")
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < n {
+		return "", nil, errors.New("Short SFTP packet.")
+	}
+
+	return string(b[4 : 4+n]), b[4+n:], nil
+}
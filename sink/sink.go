@@ -0,0 +1,168 @@
+// Package sink provides the destinations split parts are written to.
+package sink
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// A PartSink creates the output for each part of a split set. The part
+// is complete once the returned writer has been closed without error.
+type PartSink interface {
+	NewPart(name string) (io.WriteCloser, error)
+}
+
+// An Aborter is a part which can be given up on instead of closed,
+// leaving nothing of it behind.
+type Aborter interface {
+	Abort() error
+}
+
+// TempSuffix is added to the names of parts while they are written.
+const TempSuffix = ".tmp"
+
+// File writes parts as files, relative to Dir when it is set. A part is
+// written to a temporary file next to it, which only gets its name
+// once it is closed, so an interrupted run leaves no truncated parts.
+// Files which are already there are only replaced with Overwrite.
+type File struct {
+	Dir       string
+	Overwrite bool
+}
+
+func (f File) NewPart(name string) (io.WriteCloser, error) {
+	if f.Dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(f.Dir, name)
+	}
+
+	if !f.Overwrite {
+		if _, err := os.Lstat(name); err == nil {
+			return nil, &os.PathError{Op: "create", Path: name,
+				Err: os.ErrExist}
+		}
+	}
+
+	tmp, err := os.Create(name + TempSuffix)
+	if err != nil {
+		return nil, err
+	}
+	unfinished.add(tmp.Name())
+
+	return &filePart{File: tmp, name: name}, nil
+}
+
+// A part being written to its temporary file.
+type filePart struct {
+	*os.File
+	name string
+}
+
+func (p *filePart) Close() error {
+	err := p.File.Close()
+	if err == nil {
+		err = os.Rename(p.File.Name(), p.name)
+	}
+	if err != nil {
+		os.Remove(p.File.Name())
+	}
+	unfinished.remove(p.File.Name())
+
+	return err
+}
+
+func (p *filePart) Abort() error {
+	p.File.Close()
+	err := os.Remove(p.File.Name())
+	unfinished.remove(p.File.Name())
+
+	return err
+}
+
+// The temporary files of the parts being written.
+var unfinished = &tempFiles{names: make(map[string]bool)}
+
+type tempFiles struct {
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func (t *tempFiles) add(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.names[name] = true
+}
+
+func (t *tempFiles) remove(name string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.names, name)
+}
+
+// RemoveUnfinished removes the temporary files of the parts still being
+// written, for when the program is interrupted.
+func RemoveUnfinished() {
+	unfinished.mu.Lock()
+	defer unfinished.mu.Unlock()
+
+	for name := range unfinished.names {
+		os.Remove(name)
+	}
+}
+
+// Memory keeps parts in memory, mostly useful for library users who
+// process the parts themselves and for tests.
+type Memory struct {
+	mu    sync.Mutex
+	parts map[string][]byte
+}
+
+func (m *Memory) NewPart(name string) (io.WriteCloser, error) {
+	return &memoryPart{sink: m, name: name}, nil
+}
+
+// Return the names of the completed parts in sorted order.
+func (m *Memory) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.parts))
+	for name := range m.parts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Return the contents of a completed part.
+func (m *Memory) Bytes(name string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, ok := m.parts[name]
+	return data, ok
+}
+
+type memoryPart struct {
+	bytes.Buffer
+	sink *Memory
+	name string
+}
+
+func (p *memoryPart) Close() error {
+	p.sink.mu.Lock()
+	defer p.sink.mu.Unlock()
+
+	if p.sink.parts == nil {
+		p.sink.parts = make(map[string][]byte)
+	}
+	p.sink.parts[p.name] = p.Bytes()
+
+	return nil
+}
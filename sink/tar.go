@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Tar writes parts as the files of a tar stream, to pipe them into
+// another tool. The size of a file goes in front of it, so parts are
+// buffered in memory until they are closed and memory use is bounded
+// by the split size. Close ends the stream.
+type Tar struct {
+	// ModTime is the time given to the parts.
+	ModTime time.Time
+
+	mu sync.Mutex
+	w  *tar.Writer
+}
+
+// Return a tar sink writing to w.
+func NewTar(w io.Writer) *Tar {
+	return &Tar{ModTime: time.Now(), w: tar.NewWriter(w)}
+}
+
+func (t *Tar) NewPart(name string) (io.WriteCloser, error) {
+	return &tarPart{sink: t, name: filepath.ToSlash(name)}, nil
+}
+
+// Close writes the end of the tar stream.
+func (t *Tar) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.w.Close()
+}
+
+type tarPart struct {
+	bytes.Buffer
+	sink *Tar
+	name string
+}
+
+// Abort leaves the part out of the stream.
+func (p *tarPart) Abort() error {
+	return nil
+}
+
+func (p *tarPart) Close() error {
+	p.sink.mu.Lock()
+	defer p.sink.mu.Unlock()
+
+	err := p.sink.w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     p.name,
+		Mode:     0644,
+		Size:     int64(p.Len()),
+		ModTime:  p.sink.ModTime.Truncate(time.Second)})
+	if err != nil {
+		return err
+	}
+	_, err = p.sink.w.Write(p.Bytes())
+
+	return err
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/remote"
+	"github.com/ascheepe/zipsplit/sink"
+)
+
+func isURL(name string) bool {
+	return strings.HasPrefix(name, "http://") ||
+		strings.HasPrefix(name, "https://")
+}
+
+// Report whether the source archive is not a local file.
+func isRemote(name string) bool {
+	return isURL(name) || sink.IsObjectURL(name) ||
+		remote.IsRcloneRemote(name)
+}
+
+// Open the source archive, either a local file, standard input, an
+// http(s) URL, an S3 or Google Cloud Storage object or a file on an
+// rclone remote, or a zip inside one of those. Tarballs are
+// converted to a temporary zip first.
+func openSource(config Config) (*zip.Reader, io.Closer, error) {
+	f, size, closer, err := openSourceFile(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if format := detectFormat(f); isTarball(format) {
+		r, converted, err := convertTarball(f, size, config)
+		closer.Close()
+		return r, converted, err
+	}
+
+	r, err := zip.NewReader(f, size)
+	if err != nil {
+		err = explainOpenError(config.sourceArchive, f, err)
+		closer.Close()
+		return nil, nil, err
+	}
+
+	return r, closer, nil
+}
+
+// Open the source archive for random access, without reading its
+// central directory.
+func openSourceFile(config Config) (io.ReaderAt, int64, io.Closer, error) {
+	name, members := splitMemberPath(config.sourceArchive)
+
+	r, size, closer, err := openFile(name, config)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	opened := closers{closer}
+	for _, member := range members {
+		r, size, closer, err = openMember(r, size, member)
+		if err != nil {
+			opened.Close()
+			return nil, 0, nil, err
+		}
+		opened = append(opened, closer)
+	}
+
+	return r, size, opened, nil
+}
+
+// Open a local or remote file, or standard input, for random access.
+func openFile(name string, config Config) (io.ReaderAt, int64, io.Closer, error) {
+	if name == stdinName {
+		return openStdin()
+	}
+
+	var f *remote.File
+	var err error
+
+	switch {
+	case isURL(name):
+		f, err = remote.OpenHTTP(name, config.retries)
+	case sink.IsObjectURL(name):
+		f, err = openObject(name, config.retries)
+	case remote.IsRcloneRemote(name):
+		f, err = remote.OpenRclone(name, config.retries)
+	}
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if f != nil {
+		return f, f.Size(), f, nil
+	}
+
+	local, err := os.Open(name)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	info, err := local.Stat()
+	if err != nil {
+		local.Close()
+		return nil, 0, nil, err
+	}
+
+	return local, info.Size(), local, nil
+}
+
+// Turn a failure to read a source as zip into something clearer when
+// it turns out to be another kind of archive.
+func explainOpenError(name string, r io.ReaderAt, err error) error {
+	format := detectFormat(r)
+	if format == "" || format == "zip" {
+		return err
+	}
+
+	return fmt.Errorf("%s: detected %s, expected zip.", name, format)
+}
+
+// The output name which writes the parts to standard output.
+const stdoutName = "-"
+
+// Open an object in S3 or Google Cloud Storage, signing the range
+// requests.
+func openObject(name string, retries int) (*remote.File, error) {
+	store, key, err := sink.ParseObjectURL(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return remote.OpenSigned(store.ObjectURL(key), func(req *http.Request) {
+		store.Sign(req, nil)
+	}, retries)
+}
+
+// Return where to write the parts named by the template.
+func partSinkFor(template string) sink.PartSink {
+	if sink.IsObjectURL(template) {
+		if store, _, err := sink.ParseObjectURL(template); err == nil {
+			return store
+		}
+	}
+	if remote.IsRcloneRemote(template) {
+		return sink.Rclone{}
+	}
+
+	return sink.File{}
+}
+
+// Finish writing to sinks which need to be closed once all parts are
+// written.
+func closeSink(config Config) error {
+	if closer, ok := config.partSink.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
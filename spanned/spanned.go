@@ -0,0 +1,440 @@
+// Package spanned writes a zip archive split over volumes of a fixed
+// size, the classic split archive format where the volumes are read
+// as one archive by tools like Info-ZIP, 7-Zip and WinRAR.
+//
+// Entries are copied raw. Headers and records are never cut in two;
+// when one doesn't fit in what is left of a volume the next volume is
+// started, so volumes may end up slightly smaller than the size.
+package spanned
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	splitSignature           = 0x08074b50
+	fileHeaderSignature      = 0x04034b50
+	directoryHeaderSignature = 0x02014b50
+	directoryEndSignature    = 0x06054b50
+	directory64LocSignature  = 0x07064b50
+	directory64EndSignature  = 0x06064b50
+	fileHeaderLen            = 30
+	directoryHeaderLen       = 46
+	directoryEndLen          = 22
+	directory64LocLen        = 20
+	directory64EndLen        = 56
+	zip64ExtraID             = 0x0001
+	zipVersion20             = 20
+	zipVersion45             = 45
+	uint16max                = 0xffff
+	uint32max                = 0xffffffff
+	hasDataDescriptorFlag    = 0x8
+)
+
+var ErrTooSmall = errors.New("Volume size too small for a header.")
+
+// Writer writes an archive over volumes which it gets from next, in
+// order, starting at 1.
+type Writer struct {
+	next    func(n int) (io.WriteCloser, error)
+	size    int64
+	volume  io.WriteCloser
+	disk    int
+	offset  int64
+	entries []entry
+	comment string
+	err     error
+}
+
+// Where an entry ended up.
+type entry struct {
+	*zip.FileHeader
+	disk   int
+	offset int64
+}
+
+// NewWriter returns a writer making volumes of at most size bytes.
+func NewWriter(size int64, next func(n int) (io.WriteCloser, error)) *Writer {
+	return &Writer{next: next, size: size, disk: -1}
+}
+
+// SetComment sets the archive comment, written at the very end.
+func (w *Writer) SetComment(comment string) error {
+	if len(comment) > uint16max {
+		return errors.New("zip: Writer.Comment too long")
+	}
+	w.comment = comment
+
+	return nil
+}
+
+// Start the next volume.
+func (w *Writer) nextVolume() error {
+	if w.volume != nil {
+		if err := w.volume.Close(); err != nil {
+			return err
+		}
+		w.volume = nil
+	}
+
+	volume, err := w.next(w.disk + 2)
+	if err != nil {
+		return err
+	}
+	w.volume = volume
+	w.disk++
+	w.offset = 0
+
+	return nil
+}
+
+// Write a header or record as a whole, in the next volume when it
+// doesn't fit in this one.
+func (w *Writer) writeWhole(b []byte) error {
+	if int64(len(b)) > w.size {
+		return ErrTooSmall
+	}
+	if w.volume == nil || w.offset+int64(len(b)) > w.size {
+		if err := w.nextVolume(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.volume.Write(b)
+	w.offset += int64(n)
+
+	return err
+}
+
+// Write data, continuing in the next volume as the current one fills.
+func (w *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.volume == nil || w.offset == w.size {
+			if err := w.nextVolume(); err != nil {
+				return written, err
+			}
+		}
+
+		chunk := p[:min(int64(len(p)), w.size-w.offset)]
+		n, err := w.volume.Write(chunk)
+		w.offset += int64(n)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Report whether an entry needs zip64 fields.
+func needsZip64(fh *zip.FileHeader) bool {
+	return fh.CompressedSize64 >= uint32max ||
+		fh.UncompressedSize64 >= uint32max
+}
+
+// Return the extra field without an earlier zip64 field, which is
+// written anew.
+func stripZip64(extra []byte) []byte {
+	var stripped []byte
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id != zip64ExtraID {
+			stripped = append(stripped, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+
+	return stripped
+}
+
+// Return the MS-DOS time and date of an entry.
+func dosTime(fh *zip.FileHeader) (uint16, uint16) {
+	if fh.ModifiedDate != 0 || fh.Modified.IsZero() {
+		return fh.ModifiedTime, fh.ModifiedDate
+	}
+
+	t := fh.Modified.In(time.Local)
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, time.Local)
+	}
+
+	return uint16(t.Hour()<<11 | t.Minute()<<5 | t.Second()>>1),
+		uint16((t.Year()-1980)<<9 | int(t.Month())<<5 | t.Day())
+}
+
+// CopyRaw adds an entry with its data as it is stored, which must be
+// as long as the header says.
+func (w *Writer) CopyRaw(fh *zip.FileHeader, r io.Reader) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	h := *fh
+	h.Flags &^= hasDataDescriptorFlag
+	h.Extra = stripZip64(fh.Extra)
+	h.ReaderVersion = zipVersion20
+	if needsZip64(&h) {
+		h.ReaderVersion = zipVersion45
+	}
+
+	extra := h.Extra
+	compressed, uncompressed := uint32(h.CompressedSize64),
+		uint32(h.UncompressedSize64)
+	if needsZip64(&h) {
+		compressed, uncompressed = uint32max, uint32max
+		field := newBuf(20)
+		field.uint16(zip64ExtraID)
+		field.uint16(16)
+		field.uint64(h.UncompressedSize64)
+		field.uint64(h.CompressedSize64)
+		extra = append(field.b, extra...)
+	}
+
+	modTime, modDate := dosTime(&h)
+	b := newBuf(fileHeaderLen + len(h.Name) + len(extra))
+	b.uint32(fileHeaderSignature)
+	b.uint16(h.ReaderVersion)
+	b.uint16(h.Flags)
+	b.uint16(h.Method)
+	b.uint16(modTime)
+	b.uint16(modDate)
+	b.uint32(h.CRC32)
+	b.uint32(compressed)
+	b.uint32(uncompressed)
+	b.uint16(uint16(len(h.Name)))
+	b.uint16(uint16(len(extra)))
+	b.bytes([]byte(h.Name))
+	b.bytes(extra)
+
+	if w.volume == nil {
+		// The first volume starts with a marker telling it is split.
+		if err := w.nextVolume(); err != nil {
+			return w.fail(err)
+		}
+		start := newBuf(4)
+		start.uint32(splitSignature)
+		if err := w.writeWhole(start.b); err != nil {
+			return w.fail(err)
+		}
+	}
+	if err := w.writeWhole(b.b); err != nil {
+		return w.fail(err)
+	}
+	e := entry{&h, w.disk, w.offset - int64(len(b.b))}
+
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return w.fail(err)
+	}
+	if uint64(n) != h.CompressedSize64 {
+		return w.fail(errors.New("zip: entry data doesn't match its size"))
+	}
+	w.entries = append(w.entries, e)
+
+	return nil
+}
+
+func (w *Writer) fail(err error) error {
+	w.err = err
+	return err
+}
+
+// Return the central directory record of an entry.
+func directoryHeader(e entry) []byte {
+	var zip64 []byte
+	compressed, uncompressed := uint32(e.CompressedSize64),
+		uint32(e.UncompressedSize64)
+	offset, disk := uint32(e.offset), uint16(e.disk)
+
+	field := newBuf(32)
+	if needsZip64(e.FileHeader) {
+		compressed, uncompressed = uint32max, uint32max
+		field.uint64(e.UncompressedSize64)
+		field.uint64(e.CompressedSize64)
+	}
+	if e.offset >= uint32max {
+		offset = uint32max
+		field.uint64(uint64(e.offset))
+	}
+	if e.disk >= uint16max {
+		disk = uint16max
+		field.uint32(uint32(e.disk))
+	}
+	version := e.ReaderVersion
+	if len(field.b) > 0 {
+		version = zipVersion45
+		head := newBuf(4)
+		head.uint16(zip64ExtraID)
+		head.uint16(uint16(len(field.b)))
+		zip64 = append(head.b, field.b...)
+	}
+	extra := append(zip64, e.Extra...)
+
+	creator := e.CreatorVersion
+	if creator&0xff < version {
+		creator = creator&0xff00 | version
+	}
+
+	modTime, modDate := dosTime(e.FileHeader)
+	b := newBuf(directoryHeaderLen + len(e.Name) + len(extra) +
+		len(e.Comment))
+	b.uint32(directoryHeaderSignature)
+	b.uint16(creator)
+	b.uint16(version)
+	b.uint16(e.Flags)
+	b.uint16(e.Method)
+	b.uint16(modTime)
+	b.uint16(modDate)
+	b.uint32(e.CRC32)
+	b.uint32(compressed)
+	b.uint32(uncompressed)
+	b.uint16(uint16(len(e.Name)))
+	b.uint16(uint16(len(extra)))
+	b.uint16(uint16(len(e.Comment)))
+	b.uint16(disk)
+	b.uint16(0) // internal attributes
+	b.uint32(e.ExternalAttrs)
+	b.uint32(offset)
+	b.bytes([]byte(e.Name))
+	b.bytes(extra)
+	b.bytes([]byte(e.Comment))
+
+	return b.b
+}
+
+// Close writes the central directory and closes the last volume.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.volume == nil {
+		if err := w.nextVolume(); err != nil {
+			return w.fail(err)
+		}
+	}
+
+	startDisk, startOffset := w.disk, w.offset
+	var size int64
+	disks := make([]int, len(w.entries))
+	for i, e := range w.entries {
+		record := directoryHeader(e)
+		if err := w.writeWhole(record); err != nil {
+			return w.fail(err)
+		}
+		if i == 0 {
+			startDisk = w.disk
+			startOffset = w.offset - int64(len(record))
+		}
+		size += int64(len(record))
+		disks[i] = w.disk
+	}
+
+	if err := w.writeEnd(startDisk, startOffset, size, disks); err != nil {
+		return w.fail(err)
+	}
+
+	w.err = errors.New("zip: writer closed")
+	return w.volume.Close()
+}
+
+// Write the end of central directory record, with its zip64 versions
+// when the numbers don't fit. The disks are those the records of the
+// central directory ended up on.
+func (w *Writer) writeEnd(startDisk int, startOffset, size int64,
+	disks []int) error {
+
+	entries := len(w.entries)
+	needs64 := entries >= uint16max || startDisk >= uint16max ||
+		startOffset >= uint32max || size >= uint32max ||
+		w.disk+1 >= uint16max
+
+	// The records at the end are written in one go so they end up in
+	// the same volume.
+	length := int64(directoryEndLen + len(w.comment))
+	if needs64 {
+		length += directory64EndLen + directory64LocLen
+	}
+	if length > w.size {
+		return ErrTooSmall
+	}
+	if w.offset+length > w.size {
+		if err := w.nextVolume(); err != nil {
+			return err
+		}
+	}
+
+	onDisk := 0
+	for _, disk := range disks {
+		if disk == w.disk {
+			onDisk++
+		}
+	}
+
+	end := newBuf(int(length))
+	if needs64 {
+		end.uint32(directory64EndSignature)
+		end.uint64(directory64EndLen - 12)
+		end.uint16(zipVersion45)
+		end.uint16(zipVersion45)
+		end.uint32(uint32(w.disk))
+		end.uint32(uint32(startDisk))
+		end.uint64(uint64(onDisk))
+		end.uint64(uint64(entries))
+		end.uint64(uint64(size))
+		end.uint64(uint64(startOffset))
+
+		end.uint32(directory64LocSignature)
+		end.uint32(uint32(w.disk))
+		end.uint64(uint64(w.offset))
+		end.uint32(uint32(w.disk + 1))
+	}
+
+	end.uint32(directoryEndSignature)
+	end.uint16(uint16(min(w.disk, uint16max)))
+	end.uint16(uint16(min(startDisk, uint16max)))
+	end.uint16(uint16(min(onDisk, uint16max)))
+	end.uint16(uint16(min(entries, uint16max)))
+	end.uint32(uint32(min(size, uint32max)))
+	end.uint32(uint32(min(startOffset, uint32max)))
+	end.uint16(uint16(len(w.comment)))
+	end.bytes([]byte(w.comment))
+
+	return w.writeWhole(end.b)
+}
+
+// Builds little endian records.
+type buf struct {
+	b []byte
+}
+
+func newBuf(n int) *buf {
+	return &buf{make([]byte, 0, n)}
+}
+
+func (b *buf) uint16(v uint16) {
+	b.b = binary.LittleEndian.AppendUint16(b.b, v)
+}
+
+func (b *buf) uint32(v uint32) {
+	b.b = binary.LittleEndian.AppendUint32(b.b, v)
+}
+
+func (b *buf) uint64(v uint64) {
+	b.b = binary.LittleEndian.AppendUint64(b.b, v)
+}
+
+func (b *buf) bytes(v []byte) {
+	b.b = append(b.b, v...)
+}
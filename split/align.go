@@ -0,0 +1,81 @@
+package split
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"strings"
+)
+
+// Extra field used by zipalign to pad stored entries; its data is the
+// alignment followed by padding bytes.
+const alignmentExtraID = 0xd935
+
+// Alignment returns the alignment the data of an entry needs, zero if
+// it doesn't need any. Like zipalign -p, shared libraries are page
+// aligned so they can be mapped straight from the archive.
+func Alignment(fh *zip.FileHeader, align uint64) uint64 {
+	if align <= 1 || fh.Method != zip.Store ||
+		strings.HasSuffix(fh.Name, "/") {
+		return 0
+	}
+	if strings.HasSuffix(fh.Name, ".so") {
+		return 4096
+	}
+
+	return align
+}
+
+// MaxAlignmentPadding returns the most an alignment extra field can
+// add to an entry, used when planning before the offsets are known.
+// The zip.Writer repeats the extra field in the central directory so
+// it counts twice.
+func MaxAlignmentPadding(fh *zip.FileHeader, align uint64) uint64 {
+	alignment := Alignment(fh, align)
+	if alignment == 0 {
+		return 0
+	}
+
+	return 2 * (6 + alignment - 1)
+}
+
+// Remove earlier alignment padding from an extra field, either a
+// zipalign extra block or the bare zero bytes older versions used.
+func stripAlignment(extra []byte) []byte {
+	var stripped []byte
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if id != alignmentExtraID && !(id == 0 && size == 0) {
+			stripped = append(stripped, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+
+	return stripped
+}
+
+// AlignedHeader returns a copy of the header with an extra block that
+// makes the data start on an aligned offset when the header is written
+// at offset.
+func AlignedHeader(fh *zip.FileHeader, offset int64,
+	alignment uint64) *zip.FileHeader {
+
+	aligned := *fh
+	aligned.Extra = stripAlignment(fh.Extra)
+
+	// An alignment block is at least the header and the alignment.
+	start := uint64(DataOffset(offset, &aligned)) + 6
+	padding := (alignment - start%alignment) % alignment
+
+	block := make([]byte, 6+padding)
+	binary.LittleEndian.PutUint16(block, alignmentExtraID)
+	binary.LittleEndian.PutUint16(block[2:], uint16(2+padding))
+	binary.LittleEndian.PutUint16(block[4:], uint16(alignment))
+	aligned.Extra = append(aligned.Extra, block...)
+
+	return &aligned
+}
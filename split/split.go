@@ -0,0 +1,538 @@
+// Package split divides the entries of a zip archive over parts of a
+// maximum size, copying their compressed data as-is so every part is
+// a zip archive of its own.
+//
+// Split does all of it for a local archive; the other functions are
+// the pieces it is made of, for programs which need to do more in
+// between.
+package split
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// EndOfDirectoryLen is the size of the end of central directory
+// record every zip part ends with, without a comment.
+const EndOfDirectoryLen = 22
+
+// EndOfDirectory64Len is the size of the zip64 end of central
+// directory record and its locator, which come before the end of
+// central directory record when a part has too many entries for it,
+// or is too large.
+const EndOfDirectory64Len = 56 + 20
+
+// ErrTooLarge is matched, with errors.Is, by the error for an entry
+// which fits in no part of the split size.
+var ErrTooLarge = errors.New("split: entry too large for a part")
+
+type tooLargeError struct {
+	name string
+}
+
+func (e tooLargeError) Error() string {
+	return fmt.Sprintf("Can never fit %s.", e.name)
+}
+
+func (e tooLargeError) Is(target error) bool {
+	return target == ErrTooLarge
+}
+
+// Overhead is the room an entry takes up in a zip part besides its
+// name, extra field, comment, data and the zip64 extra fields and data
+// descriptor it turns out to need.
+type Overhead struct {
+	Local   uint64
+	Central uint64
+	Extra   uint64
+
+	// Whether entries can start 4GiB or more into a part, so their
+	// offset takes a zip64 extra field.
+	LargeOffsets bool
+}
+
+// DefaultOverhead is the fixed part of the headers of an entry; a
+// local file header is 30 bytes, a central directory file header 46
+// bytes.
+var DefaultOverhead = Overhead{Local: fileHeaderLen, Central: directoryHeaderLen}
+
+// Options for Split.
+type Options struct {
+	// The most a part may take up.
+	SplitSize uint64
+
+	// Name of the parts in printf format, out-%03d.zip when empty.
+	NameTemplate string
+
+	// Where to write the parts, the current directory when empty.
+	Dir string
+
+	// Align stored entries to this many bytes, 0 or 1 to not align.
+	Align uint64
+
+	// The room to assume for the headers of an entry, DefaultOverhead
+	// when zero.
+	Overhead Overhead
+
+	// How to pack the entries into parts, first fit decreasing when
+	// nil.
+	Strategy pack.Strategy
+}
+
+// A Part is a zip archive holding some of the entries of the source.
+type Part struct {
+	Name    string
+	Comment string
+
+	// The planned size of the entries.
+	Size  uint64
+	Files []*zip.FileHeader
+
+	// The entries among Files which hold a piece of a source entry,
+	// if any.
+	Chunks map[*zip.FileHeader]Chunk
+
+	// The directory entries among Files made for the part, rather than
+	// taken from the source.
+	Dirs map[*zip.FileHeader]bool
+
+	// Write the zip64 end records whether they are needed or not.
+	ForceZip64 bool
+
+	// Encrypt the entries with this password, when set.
+	Password string
+}
+
+// A Chunk is a piece of the raw data of a source entry too large for
+// any part, written as a stored entry of its own.
+type Chunk struct {
+	Entry  string
+	Offset int64
+	Length int64
+
+	// The header of the source entry, telling it apart from others
+	// with the same name. The first entry named Entry when nil.
+	Header *zip.FileHeader
+}
+
+// An entry as seen by the packer; its size includes the headers it
+// will need in the part.
+type item struct {
+	file *zip.FileHeader
+	size uint64
+}
+
+func (item item) Size() uint64 {
+	return item.size
+}
+
+// EntrySize returns the room an entry takes up in a zip part when its
+// raw data is copied.
+func EntrySize(file *zip.FileHeader, overhead Overhead) uint64 {
+	// So:
+	//     local overhead + filename size + extra field size +
+	//     central overhead + filename size + extra field size +
+	//     comment size
+	// per file, plus the zip64 extra fields and data descriptor the
+	// zip.Writer adds and any extra overhead asked for.
+	//
+	return overhead.Local + overhead.Central + overhead.Extra +
+		uint64(len(file.Name))*2 +
+		uint64(len(file.Extra))*2 +
+		uint64(len(file.Comment)) +
+		Zip64Size(file, overhead.LargeOffsets) +
+		DataDescriptorSize(file) +
+		uint64(file.CompressedSize64)
+}
+
+// Zip64Size returns the room the zip.Writer takes for zip64 extra
+// fields when it copies an entry: in the local header when a size
+// doesn't fit in 32 bits, and in the central directory for every size,
+// or offset, which reaches the 32 bit limit.
+func Zip64Size(file *zip.FileHeader, largeOffset bool) uint64 {
+	var size uint64
+
+	if !hasDataDescriptor(file) && (file.CompressedSize64 > uint32max ||
+		file.UncompressedSize64 > uint32max) {
+		size += zip64LocalExtraLen
+	}
+
+	var fields uint64
+	if file.UncompressedSize64 >= uint32max {
+		fields++
+	}
+	if file.CompressedSize64 >= uint32max {
+		fields++
+	}
+	if largeOffset {
+		fields++
+	}
+	if fields > 0 {
+		size += zip64ExtraHeaderLen + fields*8
+	}
+
+	return size
+}
+
+// DataDescriptorSize returns the size of the data descriptor the
+// zip.Writer writes after the data of a copied entry, if any.
+func DataDescriptorSize(file *zip.FileHeader) uint64 {
+	if !hasDataDescriptor(file) {
+		return 0
+	}
+	if file.CompressedSize64 > uint32max ||
+		file.UncompressedSize64 > uint32max {
+		return dataDescriptor64Len
+	}
+
+	return dataDescriptorLen
+}
+
+// A directory has no data, so no data descriptor either.
+func hasDataDescriptor(file *zip.FileHeader) bool {
+	return file.Flags&hasDataDescriptorFlag != 0 &&
+		!strings.HasSuffix(file.Name, "/")
+}
+
+// The least room an entry takes up, with a name of one byte.
+const minEntryLen = fileHeaderLen + directoryHeaderLen + 2
+
+// EndSize returns the room the records which end a part of at most
+// splitSize bytes take up, without a comment.
+func EndSize(splitSize uint64) uint64 {
+	// A smaller part can't hold enough entries to need the zip64
+	// records, let alone be too large.
+	if splitSize < math.MaxUint16*minEntryLen {
+		return EndOfDirectoryLen
+	}
+
+	return EndOfDirectoryLen + EndOfDirectory64Len
+}
+
+// Namer returns a function which increases the number used for the
+// format string each time it is called, starting at n.
+func Namer(template string, n int) (func() string, error) {
+	// The provided template should change when provided
+	// with different numbers but not contain the error
+	// format string.
+	a := fmt.Sprintf(template, 0)
+	b := fmt.Sprintf(template, 1)
+	if a == b || strings.Contains(a, "%!") {
+		return nil, errors.New("Invalid template.")
+	}
+
+	return func() string {
+		name := fmt.Sprintf(template, n)
+		n = n + 1
+		return name
+	}, nil
+}
+
+// Fill in the defaults for what was left out.
+func (opts *Options) defaults() {
+	if opts.NameTemplate == "" {
+		opts.NameTemplate = "out-%03d.zip"
+	}
+	if opts.Overhead == (Overhead{}) {
+		opts.Overhead = DefaultOverhead
+	}
+	if opts.Strategy == nil {
+		opts.Strategy = pack.FirstFitDecreasing
+	}
+}
+
+// Plan divides the entries over as few parts as it can, without
+// writing anything.
+func Plan(files []*zip.FileHeader, opts Options) ([]*Part, error) {
+	opts.defaults()
+
+	newName, err := Namer(opts.NameTemplate, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	end := EndSize(opts.SplitSize)
+	if opts.SplitSize <= end {
+		return nil, errors.New("Split size too small.")
+	}
+	capacity := opts.SplitSize - end
+	opts.Overhead.LargeOffsets = opts.SplitSize > uint32max
+
+	var items []pack.Item
+	for _, file := range files {
+		size := EntrySize(file, opts.Overhead) +
+			MaxAlignmentPadding(file, opts.Align)
+		if size > capacity {
+			return nil, tooLargeError{file.Name}
+		}
+		items = append(items, item{file, size})
+	}
+
+	bins, err := opts.Strategy(items, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []*Part
+	for _, bin := range bins {
+		part := &Part{Name: newName(), Size: bin.Size}
+		for _, it := range bin.Items {
+			part.Files = append(part.Files, it.(item).file)
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// WriteEntry writes an entry with the given raw data, aligning it when
+// asked for, or encrypting it when the writer has a password.
+func WriteEntry(w *Writer, file *zip.FileHeader, r io.Reader,
+	align uint64) error {
+
+	fh := *file
+
+	// There is nothing to encrypt in a directory; encrypted data is
+	// never aligned.
+	if w.Password != "" && !strings.HasSuffix(fh.Name, "/") {
+		encrypted, er, err := zipcrypt.Encrypt(&fh, r, w.Password)
+		if err != nil {
+			return err
+		}
+		return w.CopyRaw(er, encrypted)
+	}
+
+	if alignment := Alignment(&fh, align); alignment != 0 {
+		offset, err := w.Offset()
+		if err != nil {
+			return err
+		}
+		return w.CopyRaw(r, AlignedHeader(&fh, offset, alignment))
+	}
+
+	return w.CopyRaw(r, &fh)
+}
+
+// CopyEntry copies an entry from another archive without
+// decompressing it.
+func CopyEntry(w *Writer, f *zip.File, align uint64) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	return WriteEntry(w, &f.FileHeader, r, align)
+}
+
+// Copy the raw data of an entry under another header, like one found
+// by name or made an alias of it.
+func copyAs(w *Writer, f *zip.File, fh *zip.FileHeader, align uint64) error {
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+
+	return WriteEntry(w, fh, r, align)
+}
+
+// WriteChunk writes a piece of the raw data of an entry as a stored
+// entry with the given header.
+func WriteChunk(w *Writer, f *zip.File, fh *zip.FileHeader,
+	chunk Chunk) error {
+
+	r, err := f.OpenRaw()
+	if err != nil {
+		return err
+	}
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(chunk.Offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, r, chunk.Offset)
+	}
+	if err != nil {
+		return err
+	}
+
+	header := *fh
+	header.Method = zip.Store
+	dst, err := w.CreateHeader(&header)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(dst, r, chunk.Length)
+
+	return err
+}
+
+// A Source finds the entries of an archive being split, without
+// searching through all of them for every entry written.
+type Source struct {
+	*zip.Reader
+	byHeader map[*zip.FileHeader]*zip.File
+	byName   map[string]*zip.File
+}
+
+// NewSource indexes the entries of r.
+func NewSource(r *zip.Reader) *Source {
+	src := &Source{
+		Reader:   r,
+		byHeader: make(map[*zip.FileHeader]*zip.File, len(r.File)),
+		byName:   make(map[string]*zip.File, len(r.File))}
+
+	for _, f := range r.File {
+		src.byHeader[&f.FileHeader] = f
+		if _, ok := src.byName[f.Name]; !ok {
+			src.byName[f.Name] = f
+		}
+	}
+
+	return src
+}
+
+// Find returns the entry a header belongs to. Headers not taken from
+// the archive itself are matched by name, to the first entry with it.
+func (src *Source) Find(fh *zip.FileHeader) (*zip.File, bool) {
+	if f, ok := src.byHeader[fh]; ok {
+		return f, true
+	}
+
+	return src.FindName(fh.Name)
+}
+
+// Alias makes Find return the entry of target for fh, for a header
+// written with the data of another entry.
+func (src *Source) Alias(fh, target *zip.FileHeader) {
+	if f, ok := src.byHeader[target]; ok {
+		src.byHeader[fh] = f
+	}
+}
+
+// FindName returns the first entry with the given name.
+func (src *Source) FindName(name string) (*zip.File, bool) {
+	f, ok := src.byName[name]
+	return f, ok
+}
+
+// Write writes the part as a zip archive to dst, taking its entries
+// from src. When given, copied is called after every entry.
+func (part *Part) Write(src *zip.Reader, dst io.Writer, align uint64,
+	copied func(f *zip.File)) error {
+
+	return part.WriteFrom(NewSource(src), dst, align, copied)
+}
+
+// WriteFrom is like Write, for writing several parts from the same
+// source without indexing it again for each.
+func (part *Part) WriteFrom(src *Source, dst io.Writer, align uint64,
+	copied func(f *zip.File)) error {
+
+	w := NewWriter(dst)
+	w.ForceZip64 = part.ForceZip64
+	w.Password = part.Password
+	if part.Comment != "" {
+		if err := w.SetComment(part.Comment); err != nil {
+			return err
+		}
+	}
+
+	for _, partFile := range part.Files {
+		if part.Dirs[partFile] {
+			err := WriteEntry(w, partFile, strings.NewReader(""), align)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var sourceFile *zip.File
+		var found bool
+
+		chunk, isChunk := part.Chunks[partFile]
+		if isChunk && chunk.Header != nil {
+			sourceFile, found = src.Find(chunk.Header)
+		} else if isChunk {
+			sourceFile, found = src.FindName(chunk.Entry)
+		} else {
+			sourceFile, found = src.Find(partFile)
+		}
+		if !found {
+			continue
+		}
+
+		var err error
+		switch {
+		case isChunk:
+			err = WriteChunk(w, sourceFile, partFile, chunk)
+		case &sourceFile.FileHeader != partFile:
+			err = copyAs(w, sourceFile, partFile, align)
+		default:
+			err = CopyEntry(w, sourceFile, align)
+		}
+		if err != nil {
+			return err
+		}
+		if copied != nil {
+			copied(sourceFile)
+		}
+	}
+
+	return w.Close()
+}
+
+// Split divides the local zip archive source into parts of at most
+// opts.SplitSize, returning the parts it wrote.
+func Split(source string, opts Options) ([]Part, error) {
+	opts.defaults()
+
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var files []*zip.FileHeader
+	for _, f := range r.File {
+		files = append(files, &f.FileHeader)
+	}
+	EmptyDirectories(files)
+
+	planned, err := Plan(files, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	src := NewSource(&r.Reader)
+
+	var parts []Part
+	for _, part := range planned {
+		if err := writePart(part, src, opts); err != nil {
+			return parts, err
+		}
+		parts = append(parts, *part)
+	}
+
+	return parts, nil
+}
+
+func writePart(part *Part, src *Source, opts Options) error {
+	f, err := os.Create(filepath.Join(opts.Dir, part.Name))
+	if err != nil {
+		return err
+	}
+
+	err = part.WriteFrom(src, f, opts.Align, nil)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
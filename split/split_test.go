@@ -0,0 +1,329 @@
+package split
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An entry of a source archive made for a test.
+type testEntry struct {
+	name    string
+	size    int
+	method  uint16
+	extra   int
+	comment int
+
+	// Write the sizes in the local header instead of a data
+	// descriptor after the data.
+	noDescriptor bool
+}
+
+// Return an extra field of the given size, in a single block of an ID
+// no reader knows.
+func testExtra(size int) []byte {
+	if size == 0 {
+		return nil
+	}
+
+	extra := make([]byte, size)
+	binary.LittleEndian.PutUint16(extra, 0xcafe)
+	binary.LittleEndian.PutUint16(extra[2:], uint16(size-4))
+
+	return extra
+}
+
+// Write a source archive with the entries, filled with data which
+// doesn't compress, and return its name.
+func makeSource(t *testing.T, entries []testEntry) string {
+	t.Helper()
+
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+
+	for _, entry := range entries {
+		data := make([]byte, entry.size)
+		rng.Read(data)
+
+		fh := &zip.FileHeader{
+			Name:    entry.name,
+			Method:  entry.method,
+			Extra:   testExtra(entry.extra),
+			Comment: strings.Repeat("c", entry.comment)}
+
+		if entry.noDescriptor {
+			fh.Method = zip.Store
+			fh.CRC32 = crc32.ChecksumIEEE(data)
+			fh.CompressedSize64 = uint64(len(data))
+			fh.UncompressedSize64 = uint64(len(data))
+			dst, err := w.CreateRaw(fh)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := dst.Write(data); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		dst, err := w.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "source.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// Return entries of sizes all over the place, made by fill.
+func manyEntries(n int, fill func(i int, entry *testEntry)) []testEntry {
+	rng := rand.New(rand.NewSource(2))
+
+	var entries []testEntry
+	for i := range n {
+		entry := testEntry{
+			name:   fmt.Sprintf("dir/entry-%03d.bin", i),
+			size:   rng.Intn(3000),
+			method: zip.Deflate}
+		if fill != nil {
+			fill(i, &entry)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// Split the source into parts of at most splitSize, with the comment
+// and zip64 end records for every part, and check that no part is any
+// larger on disk and that together they hold every entry intact.
+func checkSplit(t *testing.T, source string, splitSize uint64,
+	comment string, forceZip64 bool) {
+
+	t.Helper()
+
+	r, err := zip.OpenReader(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var files []*zip.FileHeader
+	for _, f := range r.File {
+		files = append(files, &f.FileHeader)
+	}
+	EmptyDirectories(files)
+
+	// What the parts end with besides the end of central directory
+	// record is left out of the room for the entries.
+	reserve := uint64(len(comment))
+	if forceZip64 {
+		reserve += EndOfDirectory64Len
+	}
+	parts, err := Plan(files, Options{SplitSize: splitSize - reserve})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := NewSource(&r.Reader)
+	entries := 0
+	for _, part := range parts {
+		part.Comment = comment
+		part.ForceZip64 = forceZip64
+
+		name := filepath.Join(dir, part.Name)
+		f, err := os.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = part.WriteFrom(src, f, 0, nil)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uint64(info.Size()) > splitSize {
+			t.Errorf("%s is %d bytes, more than the split size of %d",
+				part.Name, info.Size(), splitSize)
+		}
+
+		entries += checkPart(t, name, len(part.Files))
+	}
+
+	if entries != len(r.File) {
+		t.Errorf("the parts hold %d entries, the source %d", entries,
+			len(r.File))
+	}
+}
+
+// Check that a part holds the entries it should, with data matching
+// their checksums, and return how many there are.
+func checkPart(t *testing.T, name string, want int) int {
+	t.Helper()
+
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	if len(r.File) != want {
+		t.Errorf("%s holds %d entries instead of %d", filepath.Base(name),
+			len(r.File), want)
+	}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.Copy(io.Discard, rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %s: %v", filepath.Base(name), f.Name, err)
+		}
+	}
+
+	return len(r.File)
+}
+
+var splitSizes = []uint64{4096, 7777, 16384, 50000}
+
+func TestPartsWithinSplitSize(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []testEntry
+		comment    string
+		forceZip64 bool
+	}{{
+		name:    "data descriptors",
+		entries: manyEntries(60, nil),
+	}, {
+		name: "no data descriptors",
+		entries: manyEntries(60, func(i int, entry *testEntry) {
+			entry.noDescriptor = true
+		}),
+	}, {
+		name: "mixed methods",
+		entries: manyEntries(60, func(i int, entry *testEntry) {
+			if i%2 == 0 {
+				entry.method = zip.Store
+			}
+			entry.noDescriptor = i%3 == 0
+		}),
+	}, {
+		name: "large extra fields",
+		entries: manyEntries(40, func(i int, entry *testEntry) {
+			entry.extra = 200 + i*10
+			entry.size /= 2
+		}),
+	}, {
+		name: "entry comments",
+		entries: manyEntries(40, func(i int, entry *testEntry) {
+			entry.comment = i * 20
+		}),
+	}, {
+		name:    "part comment",
+		entries: manyEntries(60, nil),
+		comment: strings.Repeat("part comment ", 40),
+	}, {
+		name:       "forced zip64",
+		entries:    manyEntries(60, nil),
+		forceZip64: true,
+	}, {
+		name: "everything at once",
+		entries: manyEntries(40, func(i int, entry *testEntry) {
+			entry.extra = 300
+			entry.comment = 100
+			entry.noDescriptor = i%2 == 0
+		}),
+		comment:    "part {num}",
+		forceZip64: true,
+	}, {
+		name: "directories and empty files",
+		entries: manyEntries(60, func(i int, entry *testEntry) {
+			switch i % 4 {
+			case 0:
+				entry.name = fmt.Sprintf("dir-%03d/", i)
+				entry.size = 0
+			case 1:
+				entry.size = 0
+			}
+		}),
+	}}
+
+	for _, test := range tests {
+		source := makeSource(t, test.entries)
+		for _, splitSize := range splitSizes {
+			t.Run(fmt.Sprintf("%s/%d", test.name, splitSize),
+				func(t *testing.T) {
+					checkSplit(t, source, splitSize, test.comment,
+						test.forceZip64)
+				})
+		}
+	}
+}
+
+func TestSplitWithinSplitSize(t *testing.T) {
+	source := makeSource(t, manyEntries(60, func(i int, entry *testEntry) {
+		entry.extra = 100
+		entry.noDescriptor = i%2 == 1
+	}))
+
+	for _, splitSize := range splitSizes {
+		dir := t.TempDir()
+		parts, err := Split(source, Options{SplitSize: splitSize, Dir: dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, part := range parts {
+			info, err := os.Stat(filepath.Join(dir, part.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(info.Size()) > splitSize {
+				t.Errorf("%s is %d bytes, more than the split size of %d",
+					part.Name, info.Size(), splitSize)
+			}
+		}
+	}
+}
+
+func TestEntryTooLarge(t *testing.T) {
+	source := makeSource(t, []testEntry{{
+		name:   "big.bin",
+		size:   10000,
+		method: zip.Store}})
+
+	_, err := Split(source, Options{SplitSize: 4096, Dir: t.TempDir()})
+	if err == nil {
+		t.Fatal("an entry larger than the split size was split")
+	}
+}
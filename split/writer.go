@@ -0,0 +1,224 @@
+package split
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+const (
+	fileHeaderLen         = 30
+	directoryHeaderLen    = 46
+	dataDescriptorLen     = 16
+	dataDescriptor64Len   = 24
+	zip64LocalExtraLen    = 20
+	zip64ExtraHeaderLen   = 4
+	uint16max             = (1 << 16) - 1
+	uint32max             = (1 << 32) - 1
+	hasDataDescriptorFlag = 0x8
+)
+
+type countWriter struct {
+	w     io.Writer
+	count int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.count += int64(n)
+	return n, err
+}
+
+// A Writer is a zip.Writer which keeps track of where the next entry
+// will start in its output.
+type Writer struct {
+	*zip.Writer
+	cw *countWriter
+
+	// Size of the data descriptor the zip.Writer will write for the
+	// last raw entry once the next one is started.
+	pending int64
+
+	// Write the zip64 end of central directory records even when they
+	// are not needed, for tools which only look for large archives
+	// that way.
+	ForceZip64 bool
+
+	// Encrypt the entries written with WriteEntry with AES-256 using
+	// this password, when set.
+	Password string
+}
+
+func NewWriter(w io.Writer) *Writer {
+	cw := &countWriter{w: w}
+	return &Writer{Writer: zip.NewWriter(cw), cw: cw}
+}
+
+// Offset returns the offset at which the next local file header will
+// start.
+func (w *Writer) Offset() (int64, error) {
+	if err := w.Flush(); err != nil {
+		return 0, err
+	}
+
+	return w.cw.count + w.pending, nil
+}
+
+// Close finishes the archive like the zip.Writer does, adding the zip64
+// end records when they are forced and the zip.Writer left them out.
+func (w *Writer) Close() error {
+	if !w.ForceZip64 {
+		return w.Writer.Close()
+	}
+
+	// Hold on to the central directory and what ends it, to put the
+	// zip64 records in before the end record.
+	out := w.cw.w
+	var tail bytes.Buffer
+	w.cw.w = &tail
+	err := w.Writer.Close()
+	w.cw.w = out
+	if err != nil {
+		return err
+	}
+
+	end, err := addZip64End(tail.Bytes())
+	if err != nil {
+		return err
+	}
+	w.cw.count -= int64(tail.Len())
+	_, err = w.cw.Write(end)
+
+	return err
+}
+
+// Rewrite the end of an archive, from its central directory on, to end
+// with the zip64 records.
+func addZip64End(tail []byte) ([]byte, error) {
+	const (
+		endSignature     = 0x06054b50
+		end64Signature   = 0x06064b50
+		locatorSignature = 0x07064b50
+		zipVersion45     = 45
+	)
+
+	at := -1
+	for i := len(tail) - EndOfDirectoryLen; i >= 0; i-- {
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if binary.LittleEndian.Uint32(tail[i:]) == endSignature &&
+			i+EndOfDirectoryLen+commentLen == len(tail) {
+			at = i
+			break
+		}
+	}
+	if at < 0 {
+		return nil, errors.New("Cannot find the end of the central directory.")
+	}
+
+	// The zip.Writer wrote them itself.
+	const locatorLen = 20
+	if at >= locatorLen &&
+		binary.LittleEndian.Uint32(tail[at-locatorLen:]) == locatorSignature {
+		return tail, nil
+	}
+
+	end := tail[at:]
+	records := uint64(binary.LittleEndian.Uint16(end[10:]))
+	size := uint64(binary.LittleEndian.Uint32(end[12:]))
+	offset := uint64(binary.LittleEndian.Uint32(end[16:]))
+
+	var records64 [EndOfDirectory64Len]byte
+	b := records64[:]
+	binary.LittleEndian.PutUint32(b[0:], end64Signature)
+	binary.LittleEndian.PutUint64(b[4:], EndOfDirectory64Len-locatorLen-12)
+	binary.LittleEndian.PutUint16(b[12:], zipVersion45)
+	binary.LittleEndian.PutUint16(b[14:], zipVersion45)
+	binary.LittleEndian.PutUint64(b[24:], records)
+	binary.LittleEndian.PutUint64(b[32:], records)
+	binary.LittleEndian.PutUint64(b[40:], size)
+	binary.LittleEndian.PutUint64(b[48:], offset)
+
+	locator := b[EndOfDirectory64Len-locatorLen:]
+	binary.LittleEndian.PutUint32(locator[0:], locatorSignature)
+	binary.LittleEndian.PutUint64(locator[8:], offset+size)
+	binary.LittleEndian.PutUint32(locator[16:], 1)
+
+	// Point readers to the zip64 record for all of it.
+	binary.LittleEndian.PutUint16(end[8:], uint16max)
+	binary.LittleEndian.PutUint16(end[10:], uint16max)
+	binary.LittleEndian.PutUint32(end[12:], uint32max)
+	binary.LittleEndian.PutUint32(end[16:], uint32max)
+
+	rewritten := append([]byte(nil), tail[:at]...)
+	rewritten = append(rewritten, b...)
+
+	return append(rewritten, end...), nil
+}
+
+// Written returns how much has been written to the output so far.
+func (w *Writer) Written() int64 {
+	return w.cw.count
+}
+
+// CreateHeader adds an entry to be compressed, like the zip.Writer
+// does.
+func (w *Writer) CreateHeader(fh *zip.FileHeader) (io.Writer, error) {
+	dst, err := w.Writer.CreateHeader(fh)
+	w.pending = 0
+
+	return dst, err
+}
+
+// CopyRaw copies the raw data of an entry using the given header,
+// which may differ from the one of the source entry in the parts which
+// don't affect the data.
+func (w *Writer) CopyRaw(r io.Reader, fh *zip.FileHeader) error {
+	dst, err := w.CreateRaw(fh)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(dst, r); err != nil {
+		return err
+	}
+
+	w.pending = int64(DataDescriptorSize(fh))
+
+	return nil
+}
+
+// DataOffset returns where the data of an entry written with the given
+// header at offset will start.
+func DataOffset(offset int64, fh *zip.FileHeader) int64 {
+	offset += fileHeaderLen + int64(len(fh.Name)) + int64(len(fh.Extra))
+
+	// The zip.Writer adds a zip64 extra field of its own to the local
+	// header when it knows the sizes up front and they are too large.
+	if fh.Flags&hasDataDescriptorFlag == 0 &&
+		(fh.CompressedSize64 > uint32max ||
+			fh.UncompressedSize64 > uint32max) {
+		offset += zip64LocalExtraLen
+	}
+
+	return offset
+}
+
+// EmptyDirectories turns directory entries with compressed nothing in
+// them, as some writers like the jar tool make, into plain empty
+// entries, since a zip.Writer takes no data for those. Like renaming
+// unsafe paths this changes the shared headers, so they are written
+// that way.
+func EmptyDirectories(files []*zip.FileHeader) {
+	for _, file := range files {
+		if strings.HasSuffix(file.Name, "/") &&
+			file.UncompressedSize64 == 0 && file.CompressedSize64 != 0 {
+			file.Method = zip.Store
+			file.CompressedSize64 = 0
+			file.CompressedSize = 0
+			file.CRC32 = 0
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package splitfs
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Entries too large for any part are cut into stored chunks named
+// after the entry with this and their number appended, whose comment
+// says how to put them back together.
+const chunkSuffix = ".part"
+
+// What the comment of a chunk says about the entry it belongs to.
+type chunkInfo struct {
+	n, count int
+	method   uint16
+	crc32    uint32
+	size     uint64
+}
+
+type chunk struct {
+	info chunkInfo
+	file *zip.File
+}
+
+// Return the entry a chunk belongs to, reporting false for entries
+// which are not chunks.
+func chunkOf(f *zip.File) (string, chunk, bool) {
+	var info chunkInfo
+	_, err := fmt.Sscanf(f.Comment,
+		"zipsplit-chunk %d/%d method %d crc32 %x size %d",
+		&info.n, &info.count, &info.method, &info.crc32, &info.size)
+	i := strings.LastIndex(f.Name, chunkSuffix)
+	if err != nil || info.n < 1 || info.n > info.count || i < 0 {
+		return "", chunk{}, false
+	}
+
+	return f.Name[:i], chunk{info, f}, true
+}
+
+// An entry put back together from its chunks.
+type joined struct {
+	name   string
+	chunks []chunk
+}
+
+// Check that all chunks of the entry are there, in order.
+func (j *joined) check() error {
+	sort.SliceStable(j.chunks, func(a, b int) bool {
+		return j.chunks[a].info.n < j.chunks[b].info.n
+	})
+
+	first := j.chunks[0].info
+	for i, c := range j.chunks {
+		if c.info.n != i+1 || c.info.count != first.count ||
+			c.info.crc32 != first.crc32 {
+			return fmt.Errorf("%s: chunk %d is missing or doesn't belong.",
+				j.name, i+1)
+		}
+	}
+	if len(j.chunks) != first.count {
+		return fmt.Errorf("%s: has %d of %d chunks.", j.name,
+			len(j.chunks), first.count)
+	}
+
+	return nil
+}
+
+func (j *joined) info() fs.FileInfo {
+	return joinedInfo{j}
+}
+
+// Open the chunks one after the other and decompress what they hold
+// together, checking it against the CRC32 of the entry.
+func (j *joined) open() (io.ReadCloser, error) {
+	var readers []io.Reader
+	var closers []io.Closer
+	closeAll := func() error {
+		var err error
+		for _, c := range closers {
+			if cerr := c.Close(); err == nil {
+				err = cerr
+			}
+		}
+		return err
+	}
+
+	for _, c := range j.chunks {
+		// Opening a stored chunk checks its own CRC on the way.
+		r, err := c.file.Open()
+		if err != nil {
+			closeAll()
+			return nil, err
+		}
+		readers = append(readers, r)
+		closers = append(closers, r)
+	}
+
+	data := io.MultiReader(readers...)
+	var r io.Reader
+	switch j.chunks[0].info.method {
+	case zip.Store:
+		r = data
+	case zip.Deflate:
+		fr := flate.NewReader(data)
+		closers = append(closers, fr)
+		r = fr
+	default:
+		closeAll()
+		return nil, zip.ErrAlgorithm
+	}
+
+	return &joinedReader{
+		r:     r,
+		hash:  crc32.NewIEEE(),
+		info:  j.chunks[0].info,
+		close: closeAll}, nil
+}
+
+type joinedReader struct {
+	r     io.Reader
+	hash  hash.Hash32
+	info  chunkInfo
+	read  uint64
+	close func() error
+}
+
+func (jr *joinedReader) Read(p []byte) (int, error) {
+	n, err := jr.r.Read(p)
+	jr.hash.Write(p[:n])
+	jr.read += uint64(n)
+	if jr.read > jr.info.size {
+		return n, zip.ErrFormat
+	}
+	if err == io.EOF && (jr.read != jr.info.size ||
+		jr.hash.Sum32() != jr.info.crc32) {
+		return n, zip.ErrChecksum
+	}
+
+	return n, err
+}
+
+func (jr *joinedReader) Close() error {
+	return jr.close()
+}
+
+// The entry as it was, which the chunks take their mode and time from.
+type joinedInfo struct {
+	j *joined
+}
+
+func (ji joinedInfo) first() fs.FileInfo { return ji.j.chunks[0].file.FileInfo() }
+func (ji joinedInfo) Name() string       { return path.Base(ji.j.name) }
+func (ji joinedInfo) Size() int64        { return int64(ji.j.chunks[0].info.size) }
+func (ji joinedInfo) Mode() fs.FileMode  { return ji.first().Mode() }
+func (ji joinedInfo) ModTime() time.Time { return ji.first().ModTime() }
+func (ji joinedInfo) IsDir() bool        { return false }
+func (ji joinedInfo) Sys() any           { return nil }
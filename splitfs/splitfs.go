@@ -0,0 +1,283 @@
+// Package splitfs presents a split set as a single read-only file
+// system, so programs can read entries without caring which part
+// holds them.
+package splitfs
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ascheepe/zipsplit/manifest"
+)
+
+// FS is an fs.FS over all the parts of a split set.
+type FS struct {
+	parts  []*zip.ReadCloser
+	files  map[string]*zip.File
+	joined map[string]*joined
+	dirs   map[string]map[string]fs.DirEntry
+}
+
+// Open the given parts as one file system. When a manifest is given
+// entries are looked up in the part the manifest says holds them and
+// every part it lists must be present; without one the central
+// directories of the parts are merged. Entries cut into chunks with
+// -split-large-files show up whole, their chunks read one after the
+// other in the order of the manifest, or of the parts.
+func New(m *manifest.Manifest, parts ...string) (*FS, error) {
+	fsys := &FS{
+		files:  make(map[string]*zip.File),
+		joined: make(map[string]*joined),
+		dirs:   map[string]map[string]fs.DirEntry{".": {}}}
+
+	byName := make(map[string]*zip.ReadCloser)
+	for _, part := range parts {
+		r, err := zip.OpenReader(part)
+		if err != nil {
+			fsys.Close()
+			return nil, err
+		}
+		fsys.parts = append(fsys.parts, r)
+		byName[filepath.Base(part)] = r
+	}
+
+	var err error
+	if m != nil {
+		err = fsys.indexManifest(m, byName)
+	} else {
+		err = fsys.indexParts()
+	}
+	if err == nil {
+		err = fsys.addJoined()
+	}
+	if err != nil {
+		fsys.Close()
+		return nil, err
+	}
+
+	return fsys, nil
+}
+
+func (fsys *FS) indexParts() error {
+	for _, part := range fsys.parts {
+		for _, f := range part.File {
+			if err := fsys.add(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fsys *FS) indexManifest(m *manifest.Manifest,
+	byName map[string]*zip.ReadCloser) error {
+
+	for _, part := range m.Parts {
+		r, ok := byName[filepath.Base(part.Name)]
+		if !ok {
+			return fmt.Errorf("Missing part %s.", part.Name)
+		}
+
+		files := make(map[string]*zip.File)
+		for _, f := range r.File {
+			files[f.Name] = f
+		}
+
+		for _, entry := range part.Entries {
+			f, ok := files[entry.Name]
+			if !ok {
+				return fmt.Errorf("Entry %s not found in %s.",
+					entry.Name, part.Name)
+			}
+			if err := fsys.add(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fsys *FS) add(f *zip.File) error {
+	name := strings.TrimSuffix(f.Name, "/")
+	if !fs.ValidPath(name) {
+		// Not reachable through fs.FS anyway.
+		return nil
+	}
+
+	if strings.HasSuffix(f.Name, "/") {
+		fsys.addDir(name)
+		return nil
+	}
+
+	// Chunks are added once all of them have been seen.
+	if entry, c, ok := chunkOf(f); ok {
+		j := fsys.joined[entry]
+		if j == nil {
+			j = &joined{name: entry}
+			fsys.joined[entry] = j
+		}
+		j.chunks = append(j.chunks, c)
+		return nil
+	}
+
+	if _, ok := fsys.files[name]; ok {
+		return fmt.Errorf("Duplicate entry %s.", name)
+	}
+	fsys.files[name] = f
+	fsys.addFile(name, f.FileInfo())
+
+	return nil
+}
+
+// Add the entries put back together from their chunks.
+func (fsys *FS) addJoined() error {
+	for name, j := range fsys.joined {
+		if err := j.check(); err != nil {
+			return err
+		}
+		if _, ok := fsys.files[name]; ok {
+			return fmt.Errorf("Duplicate entry %s.", name)
+		}
+		fsys.addFile(name, j.info())
+	}
+
+	return nil
+}
+
+func (fsys *FS) addFile(name string, info fs.FileInfo) {
+	dir := path.Dir(name)
+	fsys.addDir(dir)
+	fsys.dirs[dir][path.Base(name)] = fs.FileInfoToDirEntry(info)
+}
+
+// Register a directory and all of its parents.
+func (fsys *FS) addDir(name string) {
+	for name != "." {
+		if _, ok := fsys.dirs[name]; ok {
+			return
+		}
+		fsys.dirs[name] = make(map[string]fs.DirEntry)
+
+		parent := path.Dir(name)
+		if _, ok := fsys.dirs[parent]; !ok {
+			fsys.dirs[parent] = make(map[string]fs.DirEntry)
+		}
+		base := path.Base(name)
+		fsys.dirs[parent][base] = fs.FileInfoToDirEntry(dirInfo(base))
+		name = parent
+	}
+}
+
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if f, ok := fsys.files[name]; ok {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &file{ReadCloser: rc, info: f.FileInfo()}, nil
+	}
+
+	if j, ok := fsys.joined[name]; ok {
+		rc, err := j.open()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &file{ReadCloser: rc, info: j.info()}, nil
+	}
+
+	if children, ok := fsys.dirs[name]; ok {
+		entries := make([]fs.DirEntry, 0, len(children))
+		for _, entry := range children {
+			entries = append(entries, entry)
+		}
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+		return &dir{name: name, entries: entries}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// Close all the parts.
+func (fsys *FS) Close() error {
+	var errs []error
+	for _, part := range fsys.parts {
+		errs = append(errs, part.Close())
+	}
+	fsys.parts = nil
+
+	return errors.Join(errs...)
+}
+
+type file struct {
+	io.ReadCloser
+	info fs.FileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+type dir struct {
+	name    string
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return dirInfo(path.Base(d.name)), nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{
+		Op:   "read",
+		Path: d.name,
+		Err:  errors.New("is a directory")}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+
+	return rest[:n], nil
+}
+
+// Directories which only exist implicitly, through the names of the
+// entries below them.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
@@ -0,0 +1,143 @@
+package splitfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ascheepe/zipsplit/manifest"
+)
+
+// Build zipsplit, for the tests which read back what it writes.
+func buildZipsplit(t *testing.T) string {
+	t.Helper()
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go not found:", err)
+	}
+
+	bin := filepath.Join(t.TempDir(), "zipsplit")
+	out, err := exec.Command(goTool, "build", "-o", bin, "..").CombinedOutput()
+	if err != nil {
+		t.Fatalf("building zipsplit: %v\n%s", err, out)
+	}
+
+	return bin
+}
+
+// Write a source archive holding the given files, deflated, and return
+// its name.
+func makeSource(t *testing.T, files map[string][]byte) string {
+	t.Helper()
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		dst, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write(files[name]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(t.TempDir(), "source.zip")
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return name
+}
+
+// Split a source with an entry several times the split size using
+// -split-large-files, and check that splitfs shows it whole, with and
+// without the manifest.
+func TestSplitLargeFiles(t *testing.T) {
+	bin := buildZipsplit(t)
+
+	rng := rand.New(rand.NewSource(1))
+	large := make([]byte, 200000)
+	rng.Read(large)
+	files := map[string][]byte{
+		"dir/large.bin": large,
+		"small.txt":     []byte("not cut into chunks\n")}
+
+	out := t.TempDir()
+	manifestName := filepath.Join(out, "manifest.json")
+	cmd := exec.Command(bin,
+		"-in", makeSource(t, files),
+		"-out", filepath.Join(out, "part-%03d.zip"),
+		"-s", "64k",
+		"-split-large-files",
+		"-manifest", manifestName)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("zipsplit: %v\n%s", err, output)
+	}
+
+	parts, err := filepath.Glob(filepath.Join(out, "part-*.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 3 {
+		t.Fatalf("Expected the large entry over 3 parts at least, got %d.",
+			len(parts))
+	}
+
+	m, err := manifest.Load(manifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range []*manifest.Manifest{m, nil} {
+		fsys, err := New(m, parts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, want := range files {
+			got, err := fs.ReadFile(fsys, name)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from the source.", name)
+			}
+
+			info, err := fs.Stat(fsys, name)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			} else if info.Size() != int64(len(want)) {
+				t.Errorf("%s: size %d, want %d.", name, info.Size(),
+					len(want))
+			}
+		}
+
+		entries, err := fs.ReadDir(fsys, "dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].Name() != "large.bin" {
+			t.Errorf("dir holds %v, want only large.bin.", entries)
+		}
+
+		if err := fsys.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Exit statuses, so scripts can tell failures apart. Invalid options
+// exit with 2 like the flag package does for flags it can't parse, and
+// an interrupted run with 128 and the number of the signal.
+const (
+	exitFailure  = 1
+	exitUsage    = 2
+	exitSource   = 3
+	exitTooLarge = 4
+	exitWrite    = 5
+	exitVerify   = 6
+)
+
+// What the exit statuses are called in JSON errors.
+var statusKinds = map[int]string{
+	exitFailure:  "failure",
+	exitUsage:    "usage",
+	exitSource:   "source",
+	exitTooLarge: "too_large",
+	exitWrite:    "write",
+	exitVerify:   "verify",
+}
+
+// The status to exit with on failure for what is being done, unless the
+// error says otherwise.
+var exitStatus = exitFailure
+
+// Report failures as JSON objects instead of log lines, with -json-errors.
+var jsonErrors bool
+
+// An error which fails the run with a status of its own, whatever is
+// being done.
+type statusError struct {
+	status int
+	err    error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+func (e *statusError) Unwrap() error { return e.err }
+
+func withStatus(status int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &statusError{status, err}
+}
+
+// Return the status to exit with for an error.
+func statusOf(err error) int {
+	var se *statusError
+	switch {
+	case errors.As(err, &se):
+		return se.status
+	case errors.Is(err, split.ErrTooLarge):
+		return exitTooLarge
+	}
+
+	return exitStatus
+}
+
+// Report the failure and exit with its status.
+func fatal(err error) {
+	status := statusOf(err)
+
+	if jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Error  string `json:"error"`
+			Kind   string `json:"kind"`
+			Status int    `json:"status"`
+		}{err.Error(), statusKinds[status], status})
+	} else {
+		log.Print(err)
+	}
+
+	os.Exit(status)
+}
+
+func fatalf(format string, v ...any) {
+	fatal(fmt.Errorf(format, v...))
+}
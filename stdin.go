@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// The source name which reads the source from standard input.
+const stdinName = "-"
+
+// How much of standard input is kept in memory before it is copied to
+// a temporary file instead.
+const stdinMemory = 32 << 20
+
+// Standard input as read the first time the source is opened, as zip
+// needs random access to it and it can only be read once.
+var stdinSource struct {
+	r    io.ReaderAt
+	size int64
+	file *tempFile
+}
+
+// Open standard input for random access, keeping it in memory when it
+// is small and in a temporary file otherwise. It stays around for the
+// next time the source is opened, until closeStdin.
+func openStdin() (io.ReaderAt, int64, io.Closer, error) {
+	if stdinSource.r == nil {
+		if err := bufferStdin(); err != nil {
+			return nil, 0, nil, err
+		}
+	}
+
+	return stdinSource.r, stdinSource.size, closers{}, nil
+}
+
+func bufferStdin() error {
+	if info, err := os.Stdin.Stat(); err == nil &&
+		info.Mode()&os.ModeCharDevice != 0 {
+		return errors.New("No source on standard input.")
+	}
+
+	head, err := io.ReadAll(io.LimitReader(os.Stdin, stdinMemory+1))
+	if err != nil {
+		return err
+	}
+	if len(head) <= stdinMemory {
+		stdinSource.r, stdinSource.size = bytes.NewReader(head), int64(len(head))
+		return nil
+	}
+
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return err
+	}
+	buffered := tempFile{f}
+
+	size, err := io.Copy(buffered, io.MultiReader(bytes.NewReader(head), os.Stdin))
+	if err != nil {
+		buffered.Close()
+		return err
+	}
+	stdinSource.r, stdinSource.size, stdinSource.file = buffered, size, &buffered
+
+	return nil
+}
+
+// Remove the temporary copy of standard input, if any.
+func closeStdin() {
+	if stdinSource.file != nil {
+		stdinSource.file.Close()
+	}
+}
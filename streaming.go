@@ -0,0 +1,249 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/ascheepe/zipsplit/split"
+	"github.com/ascheepe/zipsplit/zipdir"
+)
+
+// Split the source in a single pass over its central directory,
+// writing each part as soon as it is full. Entries keep their order
+// and a part is closed when the next entry does not fit, so only the
+// entries of the part being written are held in memory, however many
+// entries the source has and however large they are. Memory use is
+// therefore bounded by the split size. Entries are copied without
+// checking their data and no manifest is written. Returns the number
+// of parts written.
+func streamSplit(config Config) (int, error) {
+	src, size, closer, err := openSourceFile(config)
+	if err != nil {
+		return 0, withStatus(exitSource, err)
+	}
+	defer closer.Close()
+
+	dir, err := zipdir.Open(src, size)
+	if errors.Is(err, zipdir.ErrFormat) {
+		err = explainOpenError(config.sourceArchive, src, err)
+	}
+	if err != nil {
+		return 0, withStatus(exitSource, err)
+	}
+
+	newZipName, err := partNamer(config)
+	if err != nil {
+		return 0, err
+	}
+
+	overhead := partOverhead(config)
+	if config.splitSize <= overhead {
+		return 0, errors.New("Split size too small.")
+	}
+	capacity := config.splitSize - overhead
+
+	cp, err := loadCheckpoint(config)
+	if err != nil {
+		return 0, err
+	}
+
+	// Without a checkpoint to resume from, what was written of a part
+	// is of no use when splitting stops.
+	var part *streamPart
+	defer func() {
+		if config.checkpoint == "" {
+			part.abort()
+		}
+	}()
+	parts := cp.Parts
+	for range parts - 1 {
+		newZipName()
+	}
+
+	// When resuming, the entries of the interrupted part are copied
+	// from what was written of it before going on.
+	var resumed []*zip.FileHeader
+	resuming := parts > 0
+	resume := func() error {
+		if !resuming {
+			return nil
+		}
+		resuming = false
+		resumedPart, err := resumePart(newZipName(), resumed, config)
+		if err != nil || resumedPart == nil {
+			return err
+		}
+		resumedPart.size = cp.PartSize
+		resumedPart.files = len(resumed)
+		part = resumedPart
+		return nil
+	}
+
+	for index := 0; ; index++ {
+		entry, err := dir.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+
+		convertNames([]*zip.FileHeader{&entry.FileHeader}, config)
+		if !isSelected(entry.Name, config) {
+			continue
+		}
+		kept, err := checkPaths([]*zip.FileHeader{&entry.FileHeader}, config)
+		if err != nil {
+			return 0, err
+		}
+		kept = dropLinks(dropDirs(kept, config), config)
+		if len(kept) == 0 {
+			continue
+		}
+		checkXattrs(kept, config)
+		if config.stripMetadata {
+			stripMetadata(kept)
+		}
+		split.EmptyDirectories(kept)
+
+		if index < cp.Entries {
+			if index >= cp.PartStart {
+				resumed = append(resumed, &entry.FileHeader)
+			}
+			continue
+		}
+		if err := resume(); err != nil {
+			return 0, err
+		}
+
+		room := entrySize(&entry.FileHeader, config)
+		if room > capacity {
+			return 0, withStatus(exitTooLarge, fmt.Errorf(
+				"Can never fit %s (%s).", entry.Name,
+				numberToHuman(entry.CompressedSize64)))
+		}
+
+		if part == nil || part.size+room > capacity ||
+			config.maxFiles > 0 && part.files >= config.maxFiles {
+			if err := part.close(config); err != nil {
+				return 0, err
+			}
+			if err := cp.save(config); err != nil {
+				return 0, err
+			}
+			if part, err = newStreamPart(newZipName(), config); err != nil {
+				return 0, err
+			}
+			parts++
+			cp.Parts = parts
+			cp.PartStart = index
+			cp.PartSize = 0
+			if err := cp.save(config); err != nil {
+				return 0, err
+			}
+		}
+
+		data, err := dir.Open(entry)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", entry.Name, err)
+		}
+		if err := split.WriteEntry(part.w, &entry.FileHeader, data, config.align); err != nil {
+			return 0, fmt.Errorf("%s: %v", entry.Name, err)
+		}
+		part.size += room
+		part.files++
+		emitEvent(event{
+			Event: "entry_copied",
+			Part:  part.name,
+			Entry: entry.Name,
+			Size:  entry.CompressedSize64})
+
+		cp.Entries = index + 1
+		cp.PartSize = part.size
+		if err := cp.saveEvery(part, config); err != nil {
+			return 0, err
+		}
+	}
+
+	if err := resume(); err != nil {
+		return 0, err
+	}
+	if err := part.close(config); err != nil {
+		return 0, err
+	}
+	if err := cp.remove(config); err != nil {
+		return 0, err
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Splitting took %d files.\n", parts)
+	}
+
+	return parts, nil
+}
+
+// The part being written while streaming.
+type streamPart struct {
+	name string
+	dst  io.WriteCloser
+	w    *split.Writer
+	size uint64
+
+	// Entries written to the part.
+	files int
+
+	closed bool
+}
+
+func newStreamPart(name string, config Config) (*streamPart, error) {
+	if config.verbose {
+		fmt.Fprintf(progress, "Creating %s..", name)
+	}
+
+	dst, err := config.partSink.NewPart(name)
+	if err != nil {
+		return nil, explainExisting(name, err)
+	}
+
+	dst = startPart(name, dst)
+
+	w := split.NewWriter(dst)
+	w.ForceZip64 = config.forceZip64
+	w.Password = config.outPassword
+
+	return &streamPart{name: name, dst: dst, w: w}, nil
+}
+
+// Give up on the part when it is still being written.
+func (part *streamPart) abort() {
+	if part == nil || part.closed {
+		return
+	}
+
+	part.closed = true
+	abortPart(part.dst)
+}
+
+func (part *streamPart) close(config Config) error {
+	if part == nil {
+		return nil
+	}
+
+	part.closed = true
+	if err := part.w.Close(); err != nil {
+		abortPart(part.dst)
+		return err
+	}
+	if err := part.dst.Close(); err != nil {
+		return err
+	}
+	closePart(part.name, part.dst)
+
+	if config.verbose {
+		fmt.Fprintln(progress, "done.")
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// What to do with symbolic links.
+const (
+	symlinksPreserve = "preserve"
+	symlinksSkip     = "skip"
+	symlinksFollow   = "follow"
+)
+
+// How many links in a row are followed before giving up on a loop.
+const maxLinkHops = 40
+
+// The longest link target read from an entry.
+const maxLinkTarget = 4096
+
+func isSymlink(file *zip.FileHeader) bool {
+	return file.Mode()&fs.ModeSymlink != 0
+}
+
+// Report whether an entry is a device, pipe or socket, which only
+// extracts to something with the privileges and system for it.
+func isSpecial(file *zip.FileHeader) bool {
+	return file.Mode()&(fs.ModeDevice|fs.ModeCharDevice|fs.ModeNamedPipe|
+		fs.ModeSocket) != 0
+}
+
+// Apply the symbolic link policy to the entries of a zip source, and
+// warn about special entries. A followed link becomes a copy of the
+// entry it points to, under its own name, as long as that is a file in
+// the source. Links which can't be followed are left out.
+func applySymlinks(r *zip.Reader, contents *sourceContents,
+	config Config) error {
+
+	byName := make(map[string]*zip.File, len(r.File))
+	byHeader := make(map[*zip.FileHeader]*zip.File, len(r.File))
+	for _, f := range r.File {
+		if _, ok := byName[f.Name]; !ok {
+			byName[f.Name] = f
+		}
+		byHeader[&f.FileHeader] = f
+	}
+
+	var kept []*zip.FileHeader
+	for _, file := range contents.files {
+		if isSpecial(file) {
+			warnings.Printf("%s is a %s, copied as it is.", file.Name,
+				specialKind(file))
+		}
+		if !isSymlink(file) || config.symlinks == symlinksPreserve {
+			kept = append(kept, file)
+			continue
+		}
+		link, ok := byHeader[file]
+		if config.symlinks == symlinksSkip || !ok {
+			if config.verbose {
+				fmt.Fprintf(progress, "Leaving out the link %s.\n", file.Name)
+			}
+			continue
+		}
+
+		target, err := linkTarget(link, byName)
+		if err != nil {
+			warnings.Printf("Leaving out %s, %v.", file.Name, err)
+			continue
+		}
+
+		followed := target.FileHeader
+		followed.Name = file.Name
+		kept = append(kept, &followed)
+		if contents.links == nil {
+			contents.links = make(map[*zip.FileHeader]*zip.FileHeader)
+		}
+		contents.links[&followed] = &target.FileHeader
+		if digest, ok := contents.digests[&target.FileHeader]; ok {
+			contents.digests[&followed] = digest
+		}
+		if offset, err := target.DataOffset(); err == nil {
+			contents.offsets[&followed] = offset
+		}
+	}
+	contents.files = kept
+
+	return nil
+}
+
+// Return the file a link entry ends up pointing to, following links
+// to links.
+func linkTarget(f *zip.File, byName map[string]*zip.File) (*zip.File, error) {
+	for range maxLinkHops {
+		if !isSymlink(&f.FileHeader) {
+			if f.FileInfo().IsDir() {
+				return nil, errors.New("it links to a directory")
+			}
+			return f, nil
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget))
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		target := string(data)
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(f.Name), target)
+		}
+		if path.IsAbs(target) || target == ".." ||
+			strings.HasPrefix(target, "../") {
+			return nil, fmt.Errorf("it links to %s outside the source",
+				string(data))
+		}
+
+		next, ok := byName[target]
+		if !ok {
+			if _, isDir := byName[target+"/"]; isDir {
+				return nil, errors.New("it links to a directory")
+			}
+			return nil, fmt.Errorf("its target %s is not in the source",
+				target)
+		}
+		f = next
+	}
+
+	return nil, errors.New("it is part of a loop of links")
+}
+
+// Leave out the symbolic links when they are skipped, for sources read
+// an entry at a time.
+func dropLinks(files []*zip.FileHeader, config Config) []*zip.FileHeader {
+	if config.symlinks != symlinksSkip {
+		return files
+	}
+
+	var kept []*zip.FileHeader
+	for _, file := range files {
+		if !isSymlink(file) {
+			kept = append(kept, file)
+		}
+	}
+
+	return kept
+}
+
+func specialKind(file *zip.FileHeader) string {
+	mode := file.Mode()
+	switch {
+	case mode&fs.ModeCharDevice != 0:
+		return "character device"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	}
+
+	return "socket"
+}
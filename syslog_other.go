@@ -0,0 +1,9 @@
+//go:build windows || plan9
+
+package main
+
+import "errors"
+
+func logToSyslog() error {
+	return errors.New("There is no system log to write to.")
+}
@@ -0,0 +1,26 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"log"
+	"log/syslog"
+)
+
+// Send progress, warnings and failures to the system log with their
+// own priorities.
+func logToSyslog() error {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "zipsplit")
+	if err != nil {
+		return err
+	}
+
+	progress = &lineWriter{logLine: w.Info}
+	warnings = log.New(&lineWriter{logLine: w.Warning}, "", 0)
+
+	// The syslog tag already names us.
+	log.SetPrefix("")
+	log.SetOutput(&lineWriter{logLine: w.Err})
+
+	return nil
+}
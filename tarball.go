@@ -0,0 +1,127 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// Report whether a source in the given format is a tarball which can
+// be split after turning it into a zip.
+func isTarball(format string) bool {
+	return format == "tar" || format == "gzip"
+}
+
+// Compress the files of a tar or tar.gz archive into a temporary zip,
+// which is used as the source instead. Entries which have no place in
+// a zip, like devices and hard links, are left out with a warning.
+func convertTarball(r io.ReaderAt, size int64,
+	config Config) (*zip.Reader, io.Closer, error) {
+
+	var src io.Reader = io.NewSectionReader(r, 0, size)
+	if detectFormat(r) == "gzip" {
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer gz.Close()
+		src = gz
+	}
+
+	f, err := os.CreateTemp("", "zipsplit-*.zip")
+	if err != nil {
+		return nil, nil, err
+	}
+	converted := tempFile{f}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Converting %s to zip..", config.sourceArchive)
+	}
+
+	w := zip.NewWriter(converted)
+	if err := tarToZip(w, tar.NewReader(src)); err != nil {
+		converted.Close()
+		return nil, nil, fmt.Errorf("%s: %v", config.sourceArchive, err)
+	}
+	if err := w.Close(); err != nil {
+		converted.Close()
+		return nil, nil, err
+	}
+
+	if config.verbose {
+		fmt.Fprintln(progress, "done.")
+	}
+
+	info, err := converted.Stat()
+	if err != nil {
+		converted.Close()
+		return nil, nil, err
+	}
+
+	zr, err := zip.NewReader(converted, info.Size())
+	if err != nil {
+		converted.Close()
+		return nil, nil, err
+	}
+
+	return zr, converted, nil
+}
+
+// Copy the entries of a tar archive into a zip, compressing the files.
+func tarToZip(w *zip.Writer, tr *tar.Reader) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			warnings.Printf("Leaving out %s, it is not a file, directory "+
+				"or symbolic link.", hdr.Name)
+			continue
+		}
+
+		fh, err := zip.FileInfoHeader(hdr.FileInfo())
+		if err != nil {
+			return err
+		}
+		fh.Name = name
+		fh.Modified = hdr.ModTime
+
+		var data io.Reader = tr
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fh.Name += "/"
+			fh.Method = zip.Store
+		case tar.TypeSymlink:
+			// Like zip tools, store the target as the data of the link.
+			data = strings.NewReader(hdr.Linkname)
+			fh.Method = zip.Store
+		default:
+			fh.Method = zip.Deflate
+		}
+
+		fw, err := w.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(fw, data); err != nil {
+			return err
+		}
+	}
+}
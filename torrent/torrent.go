@@ -0,0 +1,159 @@
+// Package torrent describes a set of files as a multi-file BitTorrent
+// metainfo file, so a split set can be shared over BitTorrent.
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Piece lengths are kept between these, aiming for about this many
+// pieces per torrent.
+const (
+	minPieceLength = 256 << 10
+	maxPieceLength = 16 << 20
+	targetPieces   = 1500
+)
+
+type File struct {
+	Path   string
+	Length int64
+}
+
+type Torrent struct {
+	Announce    string
+	Name        string
+	PieceLength int64
+	Files       []File
+
+	// The concatenated SHA-1 hashes of all pieces.
+	Pieces []byte
+}
+
+// Return a piece length suiting files of the given total size.
+func PieceLength(total int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && total/length > targetPieces {
+		length *= 2
+	}
+
+	return length
+}
+
+// Describe the files at the given paths, in order, hashing their
+// contents as one stream the way BitTorrent clients see them.
+func Create(name string, paths []string, pieceLength int64) (*Torrent, error) {
+	t := &Torrent{Name: name, PieceLength: pieceLength}
+
+	h := sha1.New()
+	var filled int64
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var length int64
+		for {
+			n, err := io.CopyN(h, f, pieceLength-filled)
+			length += n
+			filled += n
+			if filled == pieceLength {
+				t.Pieces = h.Sum(t.Pieces)
+				h.Reset()
+				filled = 0
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+		}
+		f.Close()
+
+		t.Files = append(t.Files, File{filepath.Base(path), length})
+	}
+
+	if filled > 0 {
+		t.Pieces = h.Sum(t.Pieces)
+	}
+
+	return t, nil
+}
+
+// Write the torrent as a metainfo file.
+func (t *Torrent) Save(filename string) error {
+	var files []any
+	for _, file := range t.Files {
+		files = append(files, map[string]any{
+			"length": file.Length,
+			"path":   []any{file.Path}})
+	}
+
+	metainfo := map[string]any{
+		"info": map[string]any{
+			"name":         t.Name,
+			"piece length": t.PieceLength,
+			"pieces":       t.Pieces,
+			"files":        files}}
+	if t.Announce != "" {
+		metainfo["announce"] = t.Announce
+	}
+
+	var buf bytes.Buffer
+	if err := encode(&buf, metainfo); err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// Bencode a value made of maps, lists, strings and integers.
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case int64:
+		buf.WriteString("i" + strconv.FormatInt(v, 10) + "e")
+	case string:
+		encode(buf, []byte(v))
+	case []byte:
+		buf.WriteString(strconv.Itoa(len(v)) + ":")
+		buf.Write(v)
+	case []any:
+		buf.WriteByte('l')
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case map[string]any:
+		// Dictionary keys have to be sorted.
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, key := range keys {
+			encode(buf, key)
+			if err := encode(buf, v[key]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("cannot bencode %T", v)
+	}
+
+	return nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/split"
+)
+
+// Replace the contents of an entry of a split set with those of a
+// local file, rewriting only the part which holds it.
+func updateCommand(args []string) {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+
+	manifestName := flags.String(
+		"manifest",
+		"",
+		"Manifest of the split set to update.")
+
+	verbose := flags.Bool(
+		"v",
+		false,
+		"Show some information about the process.")
+
+	flags.Parse(args)
+
+	if *manifestName == "" || flags.NArg() != 2 {
+		fatal(errors.New(
+			"Usage: zipsplit update -manifest manifest.json entry file"))
+	}
+
+	m, err := manifest.Load(*manifestName)
+	if err != nil {
+		fatal(err)
+	}
+
+	config := Config{
+		splitSize: m.SplitSize,
+		measure:   m.Measure,
+		format:    formatZip,
+		overhead:  split.DefaultOverhead,
+		verbose:   *verbose}
+
+	if err := updateEntry(m, flags.Arg(0), flags.Arg(1), config); err != nil {
+		fatal(err)
+	}
+
+	recordPartSums(m)
+	if err := m.Save(*manifestName); err != nil {
+		fatal(err)
+	}
+}
+
+func updateEntry(m *manifest.Manifest, name, filename string,
+	config Config) error {
+
+	i, j, ok := m.Find(name)
+	if !ok {
+		return fmt.Errorf("%s is not in the split set.", name)
+	}
+	part := &m.Parts[i]
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file.", filename)
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Updating %s in %s..", name, part.Name)
+	}
+
+	keep := func(f *zip.File) bool {
+		return f.Name != name
+	}
+	add := func(w *split.Writer) error {
+		return addFile(w, name, filename, info)
+	}
+	if err := rewritePart(part.Name, keep, add, config.splitSize, config); err != nil {
+		return err
+	}
+
+	fh, err := readEntry(part.Name, name)
+	if err != nil {
+		return err
+	}
+	entry := manifestEntry(fh)
+	if m.Hash != "" {
+		h, err := newHash(m.Hash)
+		if err != nil {
+			return err
+		}
+		if entry.Digest, err = hashFile(filename, h); err != nil {
+			return err
+		}
+	}
+
+	// The new version is written after the other entries of the part
+	// and its data is no longer in the source, so it has no offset
+	// there.
+	part.Entries = append(part.Entries[:j], part.Entries[j+1:]...)
+	part.Entries = append(part.Entries, entry)
+
+	if config.verbose {
+		fmt.Fprintln(progress, "done.")
+	}
+
+	return nil
+}
+
+// Compress a local file into a part as the named entry.
+func addFile(w *split.Writer, name, filename string, info os.FileInfo) error {
+	fh, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.Method = zip.Deflate
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dst, err := w.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, f)
+
+	return err
+}
+
+// Return the header of an entry as written in a part.
+func readEntry(filename, name string) (*zip.FileHeader, error) {
+	r, err := zip.OpenReader(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	for _, f := range r.File {
+		if f.Name == name {
+			fh := f.FileHeader
+			return &fh, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s not found in %s.", name, filename)
+}
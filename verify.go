@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ascheepe/zipsplit/sink"
+	"github.com/ascheepe/zipsplit/zipcrypt"
+)
+
+// Return the local path of a written part, if it has one.
+func partPath(name string, config Config) (string, error) {
+	fileSink, ok := config.partSink.(sink.File)
+	if !ok {
+		return "", errors.New("Only local parts can be verified.")
+	}
+	if fileSink.Dir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(fileSink.Dir, name)
+	}
+
+	return name, nil
+}
+
+// Check the structure of a written part and that its central directory
+// matches the source entries, without decompressing anything.
+func (bucket *Bucket) quickVerify(config Config) error {
+	filename, err := partPath(bucket.Name, config)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	part, err := zip.OpenReader(filename)
+	if err != nil {
+		return fmt.Errorf("%s: %v", bucket.Name, err)
+	}
+	defer part.Close()
+
+	if len(part.File) != len(bucket.Files) {
+		return fmt.Errorf("%s: has %d entries, expected %d.",
+			bucket.Name, len(part.File), len(bucket.Files))
+	}
+
+	// Entries are written in the order planned, which tells apart
+	// entries with the same name.
+	for i, want := range bucket.Files {
+		got := part.File[i]
+		if got.Name != want.Name {
+			return fmt.Errorf("%s: %s is missing.",
+				bucket.Name, want.Name)
+		}
+
+		// Chunks only get their CRC when written.
+		_, isChunk := bucket.Chunks[want]
+
+		if !isChunk && got.CRC32 != want.CRC32 ||
+			got.CompressedSize64 != want.CompressedSize64 ||
+			got.UncompressedSize64 != want.UncompressedSize64 ||
+			got.Method != want.Method {
+			return fmt.Errorf("%s: %s does not match the source.",
+				bucket.Name, want.Name)
+		}
+
+		// Opening the raw data checks the local header.
+		if _, err := got.OpenRaw(); err != nil {
+			return fmt.Errorf("%s: %s: %v", bucket.Name, want.Name, err)
+		}
+		offset, err := got.DataOffset()
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", bucket.Name, want.Name, err)
+		}
+		if offset+int64(got.CompressedSize64) > info.Size() {
+			return fmt.Errorf("%s: %s is truncated.",
+				bucket.Name, want.Name)
+		}
+	}
+
+	return nil
+}
+
+// Read back every entry of a written part, checking that its data has
+// the CRC32 and size of the source entry.
+func (bucket *Bucket) verify(config Config) error {
+	filename, err := partPath(bucket.Name, config)
+	if err != nil {
+		return err
+	}
+
+	part, err := zip.OpenReader(filename)
+	if err != nil {
+		return fmt.Errorf("%s: %v", bucket.Name, err)
+	}
+	defer part.Close()
+
+	for i, want := range bucket.Files {
+		if i >= len(part.File) || part.File[i].Name != want.Name {
+			return fmt.Errorf("%s: %s is missing.", bucket.Name, want.Name)
+		}
+		got := part.File[i]
+
+		sum, size, err := entrySum(got, config.outPassword)
+		if errors.Is(err, zip.ErrAlgorithm) {
+			// Can't decompress it here, so neither could the source
+			// have been checked; the raw data is a copy anyway.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s: %v", bucket.Name, want.Name, err)
+		}
+
+		// Chunks hold a piece of the data, which only gets its own
+		// CRC when written.
+		_, isChunk := bucket.Chunks[want]
+
+		if !isChunk && sum != want.CRC32 || size != want.UncompressedSize64 {
+			return fmt.Errorf("%s: %s does not match the source.",
+				bucket.Name, want.Name)
+		}
+	}
+
+	return nil
+}
+
+// Return the CRC32 and size of the uncompressed data of an entry,
+// decrypting it with the password the parts were encrypted with.
+func entrySum(f *zip.File, password string) (uint32, uint64, error) {
+	if !zipcrypt.IsEncrypted(&f.FileHeader) {
+		rc, err := f.Open()
+		if err != nil {
+			return 0, 0, err
+		}
+		defer rc.Close()
+
+		sum := crc32.NewIEEE()
+		size, err := io.Copy(sum, rc)
+
+		return sum.Sum32(), uint64(size), err
+	}
+
+	if password == "" {
+		return 0, 0, zip.ErrAlgorithm
+	}
+	fh, raw, err := zipcrypt.Open(f, password)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var rc io.ReadCloser
+	switch fh.Method {
+	case zip.Store:
+		rc = io.NopCloser(raw)
+	case zip.Deflate:
+		rc = flate.NewReader(raw)
+	default:
+		return 0, 0, zip.ErrAlgorithm
+	}
+	defer rc.Close()
+
+	sum := crc32.NewIEEE()
+	size, err := io.Copy(sum, rc)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Read what the decompressor left, so the authentication code is
+	// checked.
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return 0, 0, err
+	}
+
+	return sum.Sum32(), uint64(size), nil
+}
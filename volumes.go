@@ -0,0 +1,233 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ascheepe/zipsplit/spanned"
+)
+
+// How the volumes of a split archive are named.
+const (
+	volumeNamesZip  = "zip"
+	volumeNames7Zip = "7zip"
+)
+
+// Return the name of a volume of a split archive. Info-ZIP gives all
+// but the last .z01, .z02 and so on instead of .zip; 7-Zip numbers them
+// all as .001, .002 and so on after the name of the archive.
+func volumeName(name string, n int, config Config) string {
+	if config.volumeNames == volumeNames7Zip {
+		return fmt.Sprintf("%s.%03d", name, n)
+	}
+
+	return fmt.Sprintf("%s.z%02d", strings.TrimSuffix(name, filepath.Ext(name)), n)
+}
+
+// What writes the entries over the volumes.
+type volumeWriter interface {
+	SetComment(comment string) error
+	CopyRaw(file *zip.FileHeader, r io.Reader) error
+	Close() error
+}
+
+// An ordinary zip cut into volumes of a fixed size wherever they fill
+// up, as 7-Zip reads them: the volumes put back together are the zip.
+type rawVolumes struct {
+	zw  *zip.Writer
+	cut *cutWriter
+}
+
+func newRawVolumes(size int64, next func(n int) (io.WriteCloser, error)) *rawVolumes {
+	cut := &cutWriter{next: next, size: size}
+
+	return &rawVolumes{zw: zip.NewWriter(cut), cut: cut}
+}
+
+func (v *rawVolumes) SetComment(comment string) error {
+	return v.zw.SetComment(comment)
+}
+
+func (v *rawVolumes) CopyRaw(file *zip.FileHeader, r io.Reader) error {
+	dst, err := v.zw.CreateRaw(file)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, r)
+
+	return err
+}
+
+func (v *rawVolumes) Close() error {
+	if err := v.zw.Close(); err != nil {
+		return err
+	}
+
+	return v.cut.Close()
+}
+
+// Writes to the volumes it gets from next, in order, starting at 1,
+// going on to the next one whenever one is full.
+type cutWriter struct {
+	next   func(n int) (io.WriteCloser, error)
+	size   int64
+	volume io.WriteCloser
+	n      int
+	offset int64
+}
+
+func (w *cutWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if w.volume == nil || w.offset == w.size {
+			if err := w.Close(); err != nil {
+				return written, err
+			}
+			w.n++
+			volume, err := w.next(w.n)
+			if err != nil {
+				return written, err
+			}
+			w.volume, w.offset = volume, 0
+		}
+
+		n, err := w.volume.Write(p[:min(int64(len(p)), w.size-w.offset)])
+		written += n
+		w.offset += int64(n)
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// Close the volume being written.
+func (w *cutWriter) Close() error {
+	if w.volume == nil {
+		return nil
+	}
+
+	err := w.volume.Close()
+	w.volume = nil
+
+	return err
+}
+
+// A volume being written, kept to report on once it has its final
+// name.
+type volume struct {
+	io.WriteCloser
+	name   string
+	closed bool
+}
+
+func (v *volume) Close() error {
+	v.closed = true
+	return v.WriteCloser.Close()
+}
+
+// Write the entries as one split archive over volumes of the split
+// size, returning how many volumes it took.
+func writeSpanned(files []*zip.FileHeader,
+	openRaw func(*zip.FileHeader) (io.ReadCloser, error),
+	config Config) (int, error) {
+
+	// Only the volume being written is left to give up on when
+	// writing fails, those before it are complete.
+	var volumes []*volume
+	defer func() {
+		if n := len(volumes); n > 0 && !volumes[n-1].closed {
+			abortPart(volumes[n-1].WriteCloser)
+		}
+	}()
+	next := func(n int) (io.WriteCloser, error) {
+		if n > 1 && config.verbose {
+			fmt.Fprintln(progress, "done.")
+		}
+
+		name := volumeName(config.nameTemplate, n, config)
+		if config.verbose {
+			fmt.Fprintf(progress, "Creating %s..", name)
+		}
+		dst, err := config.partSink.NewPart(name)
+		if err != nil {
+			return nil, explainExisting(name, err)
+		}
+		v := &volume{WriteCloser: startPart(name, dst), name: name}
+		volumes = append(volumes, v)
+
+		return v, nil
+	}
+
+	var w volumeWriter = spanned.NewWriter(int64(config.splitSize), next)
+	if config.volumeNames == volumeNames7Zip {
+		w = newRawVolumes(int64(config.splitSize), next)
+	}
+	if config.comment != "" {
+		comment := renderComment(config.comment, 1, 1, config)
+		if err := w.SetComment(comment); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, file := range files {
+		r, err := openRaw(file)
+		if err != nil {
+			return 0, err
+		}
+		err = w.CopyRaw(file, r)
+		r.Close()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %v", file.Name, err)
+		}
+		emitEvent(event{
+			Event: "entry_copied",
+			Part:  volumes[len(volumes)-1].name,
+			Entry: file.Name,
+			Size:  file.CompressedSize64})
+	}
+	if err := w.Close(); err != nil {
+		return 0, err
+	}
+	if config.verbose {
+		fmt.Fprintln(progress, "done.")
+	}
+
+	if config.volumeNames == volumeNames7Zip {
+		for _, v := range volumes {
+			closePart(v.name, v.WriteCloser)
+		}
+		return len(volumes), nil
+	}
+
+	// Only now is it known which volume is the last.
+	last := volumes[len(volumes)-1]
+	from, err := partPath(last.name, config)
+	if err != nil {
+		return 0, err
+	}
+	to, err := partPath(config.nameTemplate, config)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := os.Stat(to); err == nil && !config.overwrite {
+		return 0, fmt.Errorf("%s already exists, overwrite it with -force.",
+			to)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return 0, err
+	}
+	last.name = config.nameTemplate
+
+	for _, v := range volumes {
+		closePart(v.name, v.WriteCloser)
+	}
+
+	return len(volumes), nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// What to do with extra fields carrying extended attributes.
+const (
+	xattrKeep  = "keep"
+	xattrStrip = "strip"
+)
+
+// Extra fields which carry extended attributes, ACLs or other file
+// system metadata beyond the basic permissions.
+var xattrExtraIDs = map[uint16]string{
+	0x0009: "OS/2 extended attributes",
+	0x000c: "VMS attributes",
+	0x0065: "IBM S/390 attributes",
+	0x07c8: "Macintosh attributes",
+	0x2605: "ZipIt Macintosh attributes",
+	0x334d: "Info-ZIP Macintosh attributes",
+	0x4453: "Windows NT security descriptor",
+	0x4c41: "OS/2 access control list",
+	0x6542: "BeOS attributes",
+	0x7441: "AtheOS attributes",
+}
+
+// Split an extra field into its records, returning the kinds of
+// extended attributes found and the extra field without them.
+func splitXattrs(extra []byte) ([]string, []byte) {
+	var kinds []string
+	var rest []byte
+
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := 4 + int(binary.LittleEndian.Uint16(extra[2:]))
+		if size > len(extra) {
+			// Keep what we can't make sense of as it is.
+			break
+		}
+
+		if kind, ok := xattrExtraIDs[id]; ok {
+			kinds = append(kinds, kind)
+		} else {
+			rest = append(rest, extra[:size]...)
+		}
+		extra = extra[size:]
+	}
+
+	return kinds, append(rest, extra...)
+}
+
+// Report the entries carrying extended attributes, stripping them
+// when asked to. Like renaming unsafe paths this changes the shared
+// headers, so the entries are written without them.
+func checkXattrs(files []*zip.FileHeader, config Config) {
+	for _, file := range files {
+		kinds, rest := splitXattrs(file.Extra)
+		if len(kinds) == 0 {
+			continue
+		}
+
+		if config.verbose {
+			fmt.Fprintf(progress, "%s carries %s.\n",
+				file.Name, strings.Join(kinds, ", "))
+		}
+		if config.xattrs == xattrStrip {
+			file.Extra = rest
+		}
+	}
+}
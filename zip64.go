@@ -0,0 +1,51 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"math"
+)
+
+// The most a zip archive without zip64 records can take up, and the
+// most entries it can hold.
+const (
+	maxZip32Size    = math.MaxUint32 - 1
+	maxZip32Entries = math.MaxUint16 - 1
+)
+
+// Keep the parts within what a zip archive can be without zip64
+// records, on top of any limits already set.
+func limitToZip32(config *Config) {
+	config.splitSize = min(config.splitSize, maxZip32Size)
+	if config.maxFileSize == 0 || config.maxFileSize > maxZip32Size {
+		config.maxFileSize = maxZip32Size
+	}
+	if config.maxFiles == 0 || config.maxFiles > maxZip32Entries {
+		config.maxFiles = maxZip32Entries
+	}
+}
+
+// Report whether the zip.Writer needs a zip64 extra field for an
+// entry, whatever its offset.
+func needsZip64(file *zip.FileHeader) bool {
+	return file.CompressedSize64 >= math.MaxUint32 ||
+		file.UncompressedSize64 >= math.MaxUint32
+}
+
+// Check that none of the planned parts need zip64, which packing within
+// the limits leaves to entries too large for it.
+func checkZip32(buckets []*Bucket) error {
+	for _, bucket := range buckets {
+		if len(bucket.Files) > maxZip32Entries {
+			return fmt.Errorf("%s would hold %d entries, which needs zip64.",
+				bucket.Name, len(bucket.Files))
+		}
+		for _, file := range bucket.Files {
+			if needsZip64(file) {
+				return fmt.Errorf("%s needs zip64.", file.Name)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package zipcrypt
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"hash"
+	"io"
+)
+
+const (
+	aesIterations  = 1000
+	aesVerifierLen = 2
+	aesAuthLen     = 10
+	aesExtraLen    = 4 + 7
+
+	// Entries are encrypted with AES-256, leaving out their CRC.
+	aesStrength = 3
+	aesVersion  = 2
+
+	// Readers need to know of AES to read the entries.
+	zipVersion51 = 51
+)
+
+// AESOverhead is how much more room an entry takes up in an archive
+// once encrypted: the salt, password verifier and authentication code
+// around its data, and the AES extra field in both its headers.
+const AESOverhead = 16 + aesVerifierLen + aesAuthLen + 2*aesExtraLen
+
+// A WinZip AES extra field.
+type aesExtra struct {
+	version  uint16
+	strength byte
+	method   uint16
+}
+
+func parseAESExtra(data []byte) (aesExtra, bool) {
+	if len(data) < 7 || string(data[2:4]) != "AE" {
+		return aesExtra{}, false
+	}
+
+	extra := aesExtra{
+		version:  binary.LittleEndian.Uint16(data),
+		strength: data[4],
+		method:   binary.LittleEndian.Uint16(data[5:])}
+	if extra.strength < 1 || extra.strength > 3 {
+		return aesExtra{}, false
+	}
+
+	return extra, true
+}
+
+// Return the length of the key, and of the salt, for a strength of 1
+// to 3, meaning AES-128, AES-192 or AES-256.
+func aesSizes(strength byte) (int, int) {
+	keyLen := 8 + 8*int(strength)
+	return keyLen, keyLen / 2
+}
+
+// Derive the encryption and authentication keys, and the password
+// verifier, from a password.
+func aesKeys(password string, salt []byte,
+	keyLen int) ([]byte, []byte, []byte, error) {
+
+	key, err := pbkdf2.Key(sha1.New, password, salt, aesIterations,
+		2*keyLen+aesVerifierLen)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return key[:keyLen], key[keyLen : 2*keyLen], key[2*keyLen:], nil
+}
+
+// WinZip AES runs the block cipher in counter mode, with a little
+// endian counter starting at 1.
+type aesCTR struct {
+	block     cipher.Block
+	counter   [aes.BlockSize]byte
+	keystream [aes.BlockSize]byte
+	used      int
+}
+
+func newAESCTR(block cipher.Block) *aesCTR {
+	return &aesCTR{block: block, used: aes.BlockSize}
+}
+
+func (ctr *aesCTR) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if ctr.used == aes.BlockSize {
+			for j := range ctr.counter {
+				ctr.counter[j]++
+				if ctr.counter[j] != 0 {
+					break
+				}
+			}
+			ctr.block.Encrypt(ctr.keystream[:], ctr.counter[:])
+			ctr.used = 0
+		}
+		dst[i] = src[i] ^ ctr.keystream[ctr.used]
+		ctr.used++
+	}
+}
+
+// Decrypts the data of an entry, checking the authentication code
+// which follows it once all of it is read.
+type aesReader struct {
+	r    io.Reader
+	ctr  *aesCTR
+	mac  hash.Hash
+	left uint64
+}
+
+func (ar *aesReader) Read(p []byte) (int, error) {
+	if ar.left == 0 {
+		var code [aesAuthLen]byte
+		if _, err := io.ReadFull(ar.r, code[:]); err != nil {
+			return 0, err
+		}
+		sum := ar.mac.Sum(nil)[:aesAuthLen]
+		if subtle.ConstantTimeCompare(sum, code[:]) != 1 {
+			return 0, ErrAuth
+		}
+		return 0, io.EOF
+	}
+
+	if uint64(len(p)) > ar.left {
+		p = p[:ar.left]
+	}
+	n, err := ar.r.Read(p)
+	ar.mac.Write(p[:n])
+	ar.ctr.XORKeyStream(p[:n], p[:n])
+	ar.left -= uint64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func openAES(f *zip.File, password string) (*zip.FileHeader, io.Reader, error) {
+	extraField, data := cutExtra(f.Extra, aesExtraID)
+	extra, ok := parseAESExtra(data)
+	if !ok {
+		return nil, nil, ErrAlgorithm
+	}
+
+	keyLen, saltLen := aesSizes(extra.strength)
+	overhead := uint64(saltLen + aesVerifierLen + aesAuthLen)
+	if f.CompressedSize64 < overhead {
+		return nil, nil, ErrFormat
+	}
+
+	r, err := f.OpenRaw()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, saltLen+aesVerifierLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	encKey, authKey, verifier, err := aesKeys(password, header[:saltLen], keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare(verifier, header[saltLen:]) != 1 {
+		return nil, nil, ErrPassword
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decrypted := f.FileHeader
+	decrypted.Flags &^= encryptedFlag
+	decrypted.Method = extra.method
+	decrypted.Extra = extraField
+	decrypted.CompressedSize64 -= overhead
+
+	// Version 1 keeps the CRC, which version 2 leaves out as it gives
+	// away something about the data.
+	if extra.version != 1 {
+		decrypted.CRC32 = 0
+	}
+
+	return &decrypted, &aesReader{
+		r:    r,
+		ctr:  newAESCTR(block),
+		mac:  hmac.New(sha1.New, authKey),
+		left: decrypted.CompressedSize64}, nil
+}
+
+// Encrypts the data of an entry, following it with the authentication
+// code.
+type aesEncrypter struct {
+	r    io.Reader
+	ctr  *aesCTR
+	mac  hash.Hash
+	done bool
+
+	// What is left of the authentication code, once r is read.
+	code []byte
+}
+
+func (ae *aesEncrypter) Read(p []byte) (int, error) {
+	if ae.done {
+		if len(ae.code) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(p, ae.code)
+		ae.code = ae.code[n:]
+		return n, nil
+	}
+
+	n, err := ae.r.Read(p)
+	ae.ctr.XORKeyStream(p[:n], p[:n])
+	ae.mac.Write(p[:n])
+	if err == io.EOF {
+		ae.done = true
+		ae.code = ae.mac.Sum(nil)[:aesAuthLen]
+		err = nil
+	}
+
+	return n, err
+}
+
+// Encrypt returns the header of an entry once encrypted with AES-256,
+// and a reader for its encrypted raw data, given its raw data.
+func Encrypt(fh *zip.FileHeader, raw io.Reader,
+	password string) (*zip.FileHeader, io.Reader, error) {
+
+	keyLen, saltLen := aesSizes(aesStrength)
+	header := make([]byte, saltLen, saltLen+aesVerifierLen)
+	if _, err := rand.Read(header); err != nil {
+		return nil, nil, err
+	}
+	encKey, authKey, verifier, err := aesKeys(password, header, keyLen)
+	if err != nil {
+		return nil, nil, err
+	}
+	header = append(header, verifier...)
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	extra, _ := cutExtra(fh.Extra, aesExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, aesExtraID)
+	extra = binary.LittleEndian.AppendUint16(extra, aesExtraLen-4)
+	extra = binary.LittleEndian.AppendUint16(extra, aesVersion)
+	extra = append(extra, 'A', 'E', aesStrength)
+	extra = binary.LittleEndian.AppendUint16(extra, fh.Method)
+
+	encrypted := *fh
+	encrypted.Flags |= encryptedFlag
+	encrypted.Method = methodAES
+	encrypted.Extra = extra
+	encrypted.CRC32 = 0
+	encrypted.ReaderVersion = max(encrypted.ReaderVersion, zipVersion51)
+	encrypted.CompressedSize64 += uint64(saltLen + aesVerifierLen +
+		aesAuthLen)
+
+	return &encrypted, io.MultiReader(bytes.NewReader(header),
+		&aesEncrypter{
+			r:   raw,
+			ctr: newAESCTR(block),
+			mac: hmac.New(sha1.New, authKey)}), nil
+}
@@ -0,0 +1,83 @@
+package zipcrypt
+
+import (
+	"archive/zip"
+	"hash/crc32"
+	"io"
+)
+
+// The traditional encryption puts 12 encrypted bytes before the data,
+// the last of which checks the password.
+const traditionalHeaderLen = 12
+
+var crcTable = crc32.MakeTable(crc32.IEEE)
+
+// The three keys of the traditional encryption, which every plain
+// byte updates.
+type traditionalKeys [3]uint32
+
+func newTraditionalKeys(password string) *traditionalKeys {
+	keys := &traditionalKeys{305419896, 591751049, 878082192}
+	for i := 0; i < len(password); i++ {
+		keys.update(password[i])
+	}
+
+	return keys
+}
+
+func (keys *traditionalKeys) update(b byte) {
+	keys[0] = crcTable[byte(keys[0])^b] ^ keys[0]>>8
+	keys[1] = (keys[1]+keys[0]&0xff)*134775813 + 1
+	keys[2] = crcTable[byte(keys[2])^byte(keys[1]>>24)] ^ keys[2]>>8
+}
+
+func (keys *traditionalKeys) decrypt(p []byte) {
+	for i, c := range p {
+		k := keys[2] | 2
+		p[i] = c ^ byte(k*(k^1)>>8)
+		keys.update(p[i])
+	}
+}
+
+type traditionalReader struct {
+	r    io.Reader
+	keys *traditionalKeys
+}
+
+func (tr traditionalReader) Read(p []byte) (int, error) {
+	n, err := tr.r.Read(p)
+	tr.keys.decrypt(p[:n])
+
+	return n, err
+}
+
+func openTraditional(fh *zip.FileHeader, r io.Reader,
+	password string) (*zip.FileHeader, io.Reader, error) {
+
+	if fh.CompressedSize64 < traditionalHeaderLen {
+		return nil, nil, ErrFormat
+	}
+
+	keys := newTraditionalKeys(password)
+	var header [traditionalHeaderLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, nil, err
+	}
+	keys.decrypt(header[:])
+
+	// With a data descriptor the CRC is not known up front, so the
+	// time is checked instead.
+	check := byte(fh.CRC32 >> 24)
+	if fh.Flags&dataDescriptorFlag != 0 {
+		check = byte(fh.ModifiedTime >> 8)
+	}
+	if header[traditionalHeaderLen-1] != check {
+		return nil, nil, ErrPassword
+	}
+
+	decrypted := *fh
+	decrypted.Flags &^= encryptedFlag
+	decrypted.CompressedSize64 -= traditionalHeaderLen
+
+	return &decrypted, traditionalReader{r, keys}, nil
+}
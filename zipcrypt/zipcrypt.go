@@ -0,0 +1,127 @@
+// Package zipcrypt decrypts the entries of zip archives encrypted with
+// the traditional PKWARE scheme, known as ZipCrypto, or with WinZip AES,
+// and encrypts them with WinZip AES-256.
+//
+// Entries are decrypted to, and encrypted from, their raw, still
+// compressed, data so they can be copied into another archive without
+// compressing them again.
+package zipcrypt
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	encryptedFlag      = 0x1
+	dataDescriptorFlag = 0x8
+
+	// The compression method of an entry encrypted with AES, whose
+	// real method is in the AES extra field.
+	methodAES = 99
+
+	aesExtraID = 0x9901
+)
+
+var (
+	ErrPassword  = errors.New("zipcrypt: wrong password")
+	ErrAuth      = errors.New("zipcrypt: authentication failed")
+	ErrFormat    = errors.New("zipcrypt: not a valid encrypted entry")
+	ErrAlgorithm = errors.New("zipcrypt: unsupported encryption")
+)
+
+// IsEncrypted reports whether the data of an entry is encrypted.
+func IsEncrypted(fh *zip.FileHeader) bool {
+	return fh.Flags&encryptedFlag != 0
+}
+
+// Open returns the header an encrypted entry has once decrypted, and a
+// reader for its decrypted raw data. The reader fails at the end when
+// the data turns out to have been tampered with, as far as the scheme
+// can tell.
+func Open(f *zip.File, password string) (*zip.FileHeader, io.Reader, error) {
+	if !IsEncrypted(&f.FileHeader) {
+		return nil, nil, ErrFormat
+	}
+
+	if f.Method != methodAES {
+		r, err := f.OpenRaw()
+		if err != nil {
+			return nil, nil, err
+		}
+		return openTraditional(&f.FileHeader, r, password)
+	}
+
+	fh, r, err := openAES(f, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Version 2 leaves out the CRC, it has to be worked out from the
+	// decompressed data before it can be copied.
+	if fh.CRC32 == 0 && fh.UncompressedSize64 != 0 {
+		if fh.CRC32, err = checksum(fh, r); err != nil {
+			return nil, nil, err
+		}
+		if _, r, err = openAES(f, password); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return fh, r, nil
+}
+
+// Return the CRC of the data of an entry, given its raw data.
+func checksum(fh *zip.FileHeader, raw io.Reader) (uint32, error) {
+	var rc io.ReadCloser
+	switch fh.Method {
+	case zip.Store:
+		rc = io.NopCloser(raw)
+	case zip.Deflate:
+		rc = flate.NewReader(raw)
+	default:
+		return 0, zip.ErrAlgorithm
+	}
+	defer rc.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, rc); err != nil {
+		return 0, err
+	}
+
+	// Read what the decompressor left, so the authentication code is
+	// checked.
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		return 0, err
+	}
+
+	return h.Sum32(), nil
+}
+
+// Return the extra field without the fields of the given ID, and the
+// data of the first of those.
+func cutExtra(extra []byte, id uint16) ([]byte, []byte) {
+	var kept, found []byte
+
+	for len(extra) >= 4 {
+		fieldID := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		if fieldID == id {
+			if found == nil {
+				found = extra[4 : 4+size]
+			}
+		} else {
+			kept = append(kept, extra[:4+size]...)
+		}
+		extra = extra[4+size:]
+	}
+
+	return append(kept, extra...), found
+}
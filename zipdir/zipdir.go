@@ -0,0 +1,252 @@
+// Package zipdir reads the central directory of a zip archive one
+// entry at a time, so archives with any number of entries can be
+// processed in constant memory.
+package zipdir
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	directoryEndSignature    = 0x06054b50
+	directory64LocSignature  = 0x07064b50
+	directory64EndSignature  = 0x06064b50
+	directoryHeaderSignature = 0x02014b50
+	fileHeaderSignature      = 0x04034b50
+	directoryEndLen          = 22
+	directory64LocLen        = 20
+	directoryHeaderLen       = 46
+	fileHeaderLen            = 30
+	zip64ExtraID             = 0x0001
+	maxCommentLen            = 65535
+	uint32max                = 0xffffffff
+	uint16max                = 0xffff
+)
+
+var ErrFormat = errors.New("Not a valid zip file.")
+
+// An Entry is a file in the archive and where its local header is.
+type Entry struct {
+	zip.FileHeader
+	HeaderOffset int64
+}
+
+// Reader walks the central directory of an archive.
+type Reader struct {
+	r       io.ReaderAt
+	dir     *bufio.Reader
+	entries uint64
+	read    uint64
+}
+
+// Open finds the central directory of the archive in r.
+func Open(r io.ReaderAt, size int64) (*Reader, error) {
+	tailLen := min(size, directoryEndLen+maxCommentLen)
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	end := -1
+	for i := len(tail) - directoryEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == directoryEndSignature {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return nil, ErrFormat
+	}
+
+	record := tail[end:]
+	entries := uint64(binary.LittleEndian.Uint16(record[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(record[12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(record[16:]))
+
+	endOffset := size - tailLen + int64(end)
+	if entries == uint16max || dirSize == uint32max || dirOffset == uint32max {
+		var err error
+		entries, dirSize, dirOffset, err = readDirectory64End(r, endOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if dirOffset+dirSize > uint64(size) {
+		return nil, ErrFormat
+	}
+
+	section := io.NewSectionReader(r, int64(dirOffset), int64(dirSize))
+	return &Reader{
+		r:       r,
+		dir:     bufio.NewReader(section),
+		entries: entries}, nil
+}
+
+// Read the zip64 end of central directory record, which sits before
+// its locator, right before the end of central directory record.
+func readDirectory64End(r io.ReaderAt,
+	endOffset int64) (uint64, uint64, uint64, error) {
+
+	if endOffset < directory64LocLen {
+		return 0, 0, 0, ErrFormat
+	}
+	loc := make([]byte, directory64LocLen)
+	if _, err := r.ReadAt(loc, endOffset-directory64LocLen); err != nil {
+		return 0, 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(loc) != directory64LocSignature {
+		return 0, 0, 0, ErrFormat
+	}
+
+	record := make([]byte, 56)
+	offset := int64(binary.LittleEndian.Uint64(loc[8:]))
+	if _, err := r.ReadAt(record, offset); err != nil {
+		return 0, 0, 0, err
+	}
+	if binary.LittleEndian.Uint32(record) != directory64EndSignature {
+		return 0, 0, 0, ErrFormat
+	}
+
+	return binary.LittleEndian.Uint64(record[32:]),
+		binary.LittleEndian.Uint64(record[40:]),
+		binary.LittleEndian.Uint64(record[48:]),
+		nil
+}
+
+// Next returns the next entry of the central directory, or io.EOF
+// after the last one.
+func (d *Reader) Next() (*Entry, error) {
+	if d.read == d.entries {
+		return nil, io.EOF
+	}
+
+	fixed := make([]byte, directoryHeaderLen)
+	if _, err := io.ReadFull(d.dir, fixed); err != nil {
+		return nil, ErrFormat
+	}
+	if binary.LittleEndian.Uint32(fixed) != directoryHeaderSignature {
+		return nil, ErrFormat
+	}
+
+	le := binary.LittleEndian
+	nameLen := int(le.Uint16(fixed[28:]))
+	extraLen := int(le.Uint16(fixed[30:]))
+	commentLen := int(le.Uint16(fixed[32:]))
+
+	variable := make([]byte, nameLen+extraLen+commentLen)
+	if _, err := io.ReadFull(d.dir, variable); err != nil {
+		return nil, ErrFormat
+	}
+
+	e := &Entry{}
+	e.CreatorVersion = le.Uint16(fixed[4:])
+	e.ReaderVersion = le.Uint16(fixed[6:])
+	e.Flags = le.Uint16(fixed[8:])
+	e.Method = le.Uint16(fixed[10:])
+	e.ModifiedTime = le.Uint16(fixed[12:])
+	e.ModifiedDate = le.Uint16(fixed[14:])
+	e.CRC32 = le.Uint32(fixed[16:])
+	e.CompressedSize = le.Uint32(fixed[20:])
+	e.UncompressedSize = le.Uint32(fixed[24:])
+	e.ExternalAttrs = le.Uint32(fixed[38:])
+	e.CompressedSize64 = uint64(e.CompressedSize)
+	e.UncompressedSize64 = uint64(e.UncompressedSize)
+	e.HeaderOffset = int64(le.Uint32(fixed[42:]))
+
+	e.Name = string(variable[:nameLen])
+	e.Comment = string(variable[nameLen+extraLen:])
+	e.NonUTF8 = e.Flags&0x800 == 0
+
+	extra := variable[nameLen : nameLen+extraLen]
+	if err := e.readExtra(extra); err != nil {
+		return nil, err
+	}
+
+	d.read++
+	return e, nil
+}
+
+// Take the real sizes and offset from the zip64 extra field and keep
+// the other fields; a zip64 field is added again when writing when
+// one is needed.
+func (e *Entry) readExtra(extra []byte) error {
+	le := binary.LittleEndian
+
+	for len(extra) >= 4 {
+		id := le.Uint16(extra)
+		size := int(le.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			return ErrFormat
+		}
+		field := extra[4 : 4+size]
+
+		if id != zip64ExtraID {
+			e.Extra = append(e.Extra, extra[:4+size]...)
+			extra = extra[4+size:]
+			continue
+		}
+
+		next := func() (uint64, bool) {
+			if len(field) < 8 {
+				return 0, false
+			}
+			v := le.Uint64(field)
+			field = field[8:]
+			return v, true
+		}
+
+		var ok bool
+		if e.UncompressedSize == uint32max {
+			if e.UncompressedSize64, ok = next(); !ok {
+				return ErrFormat
+			}
+		}
+		if e.CompressedSize == uint32max {
+			if e.CompressedSize64, ok = next(); !ok {
+				return ErrFormat
+			}
+		}
+		if e.HeaderOffset == uint32max {
+			offset, ok := next()
+			if !ok {
+				return ErrFormat
+			}
+			e.HeaderOffset = int64(offset)
+		}
+		extra = extra[4+size:]
+	}
+
+	return nil
+}
+
+// DataOffset returns where the data of the entry starts, after its
+// local header.
+func (d *Reader) DataOffset(e *Entry) (int64, error) {
+	header := make([]byte, fileHeaderLen)
+	if _, err := d.r.ReadAt(header, e.HeaderOffset); err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(header) != fileHeaderSignature {
+		return 0, ErrFormat
+	}
+
+	nameLen := int64(binary.LittleEndian.Uint16(header[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(header[28:]))
+
+	return e.HeaderOffset + fileHeaderLen + nameLen + extraLen, nil
+}
+
+// Open returns the raw, still compressed, data of the entry.
+func (d *Reader) Open(e *Entry) (io.Reader, error) {
+	offset, err := d.DataOffset(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(d.r, offset, int64(e.CompressedSize64)), nil
+}
@@ -2,123 +2,343 @@ package main
 
 import (
 	"archive/zip"
+	"compress/flate"
+	"crypto/ed25519"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"os"
-	"sort"
-	"strconv"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
+
+	"github.com/ascheepe/zipsplit/manifest"
+	"github.com/ascheepe/zipsplit/pack"
+	"github.com/ascheepe/zipsplit/sink"
+	"github.com/ascheepe/zipsplit/split"
+	"github.com/ascheepe/zipsplit/zipcrypt"
 )
 
 type Config struct {
 	sourceArchive string
 	nameTemplate  string
+	manifestName  string
+	onCorrupt     string
+	dedupe        string
+	dirs          string
+	symlinks      string
+	encoding      string
+	align         uint64
+	format        string
+	retries       int
+	quickVerify   bool
+	verify        bool
+	unsafePaths   string
+	sanitize      string
+	include       []string
+	exclude       []string
+	bombRatio     uint64
+	maxExpansion  uint64
+	abortOnBomb   bool
+	maxMemory     uint64
+	exactSize     bool
+	comment       string
+	appendComment bool
+	hash          string
+	recurseDepth  int
+	torrentName   string
+	announce      string
+	joinScripts   bool
+	checksum      string
+	checksumFiles bool
+	maxFileSize   uint64
+	clusterSize   uint64
+	overhead      split.Overhead
+	strategy      pack.Strategy
+	preserveOrder bool
+	balance       bool
+	groupDepth    int
+	groupBy       string
+	groups        []groupRule
+	splitLarge    bool
+	maxFiles      int
+	password      string
+	outPassword   string
+	stripMetadata bool
+	reproducible  bool
+	overwrite     bool
+	skipExisting  bool
+	resume        bool
+	sourceComment string
+	recompress    bool
+	method        uint16
+	level         int
+	forceZip64    bool
+	noZip64       bool
+	jobs          int
+	xattrs        string
+	sealKey       ed25519.PrivateKey
+	setID         string
+	checkpoint    string
+	startTime     time.Time
+	entryTime     time.Time
+	partSink      sink.PartSink
+	volumeNames   string
 	splitSize     uint64
+	measure       string
 	verbose       bool
 }
 
+// A part as planned by the command, which writes it in the format and
+// to the place asked for.
 type Bucket struct {
-	config   Config
-	filename string
-	size     uint64
-	files    []*zip.FileHeader
-}
+	split.Part
 
-type bySize []*zip.FileHeader
-
-func (a bySize) Len() int {
-	return len(a)
+	// The number of the part, when named after a template with
+	// {variables}.
+	num int
 }
 
-func (a bySize) Swap(i, j int) {
-	a[i], a[j] = a[j], a[i]
+// A zip entry as seen by the packer; its size includes the
+// headers it will need in the output archive.
+type zipItem struct {
+	file *zip.FileHeader
+	size uint64
 }
 
-func (a bySize) Less(i, j int) bool {
-	return a[i].CompressedSize64 < a[j].CompressedSize64
+func (item zipItem) Size() uint64 {
+	return item.size
 }
 
-// Return a function which increases the number used
-// for the format string each time it is called.
-func numberedFileNamer(template string) (func() string, error) {
-	// The provided template should change when provided
-	// with different numbers but not contain the error
-	// format string.
-	a := fmt.Sprintf(template, 0)
-	b := fmt.Sprintf(template, 1)
-	if a == b || strings.Contains(a, "%!") {
-		return nil, errors.New("Invalid template.")
-	}
-
-	n := 1
-	return func() string {
-		name := fmt.Sprintf(template, n)
-		n = n + 1
-		return name
-	}, nil
+// What to do with entries whose data can't be read back intact.
+const (
+	corruptAbort      = "abort"
+	corruptSkip       = "skip"
+	corruptCopyAnyway = "copy-anyway"
+)
+
+// What to do with entries whose name an earlier or later entry has as
+// well.
+const (
+	dedupeKeepFirst = "keep-first"
+	dedupeKeepLast  = "keep-last"
+	dedupeKeepAll   = "keep-all"
+)
+
+// What checking the entries of the source archive found.
+type sourceContents struct {
+	files   []*zip.FileHeader
+	damaged []manifest.Damaged
+
+	// Digests of the uncompressed entries, when asked for.
+	digests map[*zip.FileHeader]string
+
+	// Where the data of the entries starts in the source archive.
+	offsets map[*zip.FileHeader]int64
+
+	// The entries followed links were made a copy of.
+	links map[*zip.FileHeader]*zip.FileHeader
 }
 
-func getZipContents(zipfile string) ([]*zip.FileHeader, error) {
-	var files []*zip.FileHeader
+func getZipContents(r *zip.Reader, config Config) (*sourceContents, error) {
+	contents := &sourceContents{
+		digests: make(map[*zip.FileHeader]string),
+		offsets: make(map[*zip.FileHeader]int64)}
 
-	r, err := zip.OpenReader(zipfile)
-	if err != nil {
-		return nil, err
+	headers := make([]*zip.FileHeader, len(r.File))
+	for i, f := range r.File {
+		headers[i] = &f.FileHeader
 	}
-	defer r.Close()
+	convertNames(headers, config)
 
+	var selected []*zip.File
 	for _, f := range r.File {
-		files = append(files, &f.FileHeader)
+		if isSelected(f.Name, config) {
+			selected = append(selected, f)
+		}
+	}
+	selected = dedupeEntries(selected, config)
+
+	errs, digests := checkEntries(selected, config)
+
+	for i, f := range selected {
+		if err := errs[i]; err != nil {
+			if config.onCorrupt == corruptAbort {
+				return nil, fmt.Errorf("%s: %v", f.Name, err)
+			}
+
+			skipped := config.onCorrupt == corruptSkip
+			contents.damaged = append(contents.damaged, manifest.Damaged{
+				Name:    f.Name,
+				Error:   err.Error(),
+				Skipped: skipped})
+
+			if config.verbose {
+				fmt.Fprintf(progress, "Damaged entry %s: %v\n", f.Name, err)
+			}
+			if skipped {
+				continue
+			}
+		}
+		contents.files = append(contents.files, &f.FileHeader)
+		if digests[i] != "" {
+			contents.digests[&f.FileHeader] = digests[i]
+		}
+		if offset, err := f.DataOffset(); err == nil {
+			contents.offsets[&f.FileHeader] = offset
+		}
 	}
 
-	return files, nil
+	return contents, applySymlinks(r, contents, config)
 }
 
-func (bucket *Bucket) makeZip(config Config) error {
-	sourceReader, err := zip.OpenReader(config.sourceArchive)
-	if err != nil {
-		return err
+// Check all entries using a worker per CPU, returning the error found
+// for each entry and, when asked for, their digests.
+func checkEntries(files []*zip.File, config Config) ([]error, []string) {
+	errs := make([]error, len(files))
+	digests := make([]string, len(files))
+
+	workers := checkWorkers(config)
+
+	var failed atomic.Bool
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				// Once aborting there is no need to look further.
+				if failed.Load() && config.onCorrupt == corruptAbort {
+					continue
+				}
+				digests[i], errs[i] = checkEntry(files[i], config.hash)
+				if errs[i] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs, digests
+}
+
+// Check that the data of an entry can be read back and matches its
+// CRC, returning its digest with the named hash when given one.
+// Entries using a compression method we can't decode are copied as-is,
+// so they are not considered damaged.
+func checkEntry(f *zip.File, hashName string) (string, error) {
+	// So are encrypted entries, when there is no password to decrypt
+	// them with.
+	if zipcrypt.IsEncrypted(&f.FileHeader) {
+		return "", nil
 	}
-	defer sourceReader.Close()
 
-	zipDestination, err := os.Create(bucket.filename)
+	rc, err := f.Open()
+	if errors.Is(err, zip.ErrAlgorithm) {
+		return "", nil
+	}
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer zipDestination.Close()
+	defer rc.Close()
 
-	if config.verbose {
-		fmt.Printf("Creating %s..", bucket.filename)
+	if hashName == "" {
+		_, err = io.Copy(io.Discard, rc)
+		return "", err
 	}
 
-	w := zip.NewWriter(zipDestination)
-	defer w.Close()
+	h, err := newHash(hashName)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, rc); err != nil {
+		return "", err
+	}
 
-	for _, bucketFile := range bucket.files {
-		for _, sourceFile := range sourceReader.File {
-			if bucketFile.Name == sourceFile.Name {
-				err := w.Copy(sourceFile)
-				if err != nil {
-					return err
-				}
-				break
-			}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (bucket *Bucket) makeZip(source *split.Source, config Config) error {
+	return bucket.writePart(config, func(dst io.Writer) error {
+		if config.format == formatCpio {
+			return bucket.copyFilesCpio(source, dst)
 		}
+		return bucket.copyFiles(source, dst, config)
+	})
+}
+
+// Create the part and fill it using the given function.
+func (bucket *Bucket) writePart(config Config,
+	fill func(dst io.Writer) error) error {
+
+	name := bucket.writeName(config)
+	zipDestination, err := config.partSink.NewPart(name)
+	if err != nil {
+		return explainExisting(name, err)
 	}
+	zipDestination = startPart(name, zipDestination)
 
-	if config.verbose {
-		fmt.Println("done.")
+	// Parts written at once would mix up their messages.
+	parallel := config.jobs > 1
+	if config.verbose && !parallel {
+		fmt.Fprintf(progress, "Creating %s..", name)
+	}
+
+	if err := fill(zipDestination); err != nil {
+		abortPart(zipDestination)
+		return err
+	}
+	err = zipDestination.Close()
+	if err == nil {
+		err = bucket.nameByContents(name, zipDestination, config)
+	}
+	if err != nil {
+		return err
+	}
+	closePart(bucket.Name, zipDestination)
+
+	if config.verbose && parallel {
+		fmt.Fprintf(progress, "Created %s.\n", bucket.Name)
+	} else if config.verbose {
+		fmt.Fprintln(progress, "done.")
 	}
 
 	return nil
 }
 
+func (bucket *Bucket) copyFiles(source *split.Source,
+	zipDestination io.Writer, config Config) error {
+
+	bucket.ForceZip64 = config.forceZip64
+	bucket.Password = config.outPassword
+	return bucket.WriteFrom(source, zipDestination, config.align,
+		func(f *zip.File) {
+			emitEvent(event{
+				Event: "entry_copied",
+				Part:  bucket.Name,
+				Entry: f.Name,
+				Size:  f.CompressedSize64})
+		})
+}
+
 // byte sizes
 const (
-	_ = iota
+	_     = iota
 	KByte = 1 << (iota * 10)
 	MByte
 	GByte
@@ -126,19 +346,40 @@ const (
 	EByte
 )
 
-var sizeTable = map[string]uint64{
-	"":   1,
-	"b":  1,
-	"k":  KByte,
-	"kb": KByte,
-	"m":  MByte,
-	"mb": MByte,
-	"g":  GByte,
-	"gb": GByte,
-	"t":  TByte,
-	"tb": TByte,
-	"e":  EByte,
-	"eb": EByte,
+// Prefixes of size units, each a factor of 1000 or 1024 larger than
+// the one before.
+const sizePrefixes = "kmgtpe"
+
+// Return what a size unit multiplies by. SI units like kB and MB count
+// in powers of 1000 and IEC units like KiB and MiB in powers of 1024.
+// Bare prefixes and a lowercase b, like the 10Mb of old, count in
+// powers of 1024 as well.
+func unitFactor(unit string) (uint64, bool) {
+	if unit == "" || unit == "b" || unit == "B" {
+		return 1, true
+	}
+
+	power := strings.IndexByte(sizePrefixes, byte(unicode.ToLower(rune(unit[0])))) + 1
+	if power == 0 {
+		return 0, false
+	}
+
+	var base uint64
+	switch unit[1:] {
+	case "", "b", "iB", "ib":
+		base = 1024
+	case "B":
+		base = 1000
+	default:
+		return 0, false
+	}
+
+	factor := uint64(1)
+	for range power {
+		factor *= base
+	}
+
+	return factor, true
 }
 
 func numberToHuman(n uint64) string {
@@ -153,80 +394,242 @@ func numberToHuman(n uint64) string {
 	return fmt.Sprintf("%.2f%s", value, units[i])
 }
 
-func humanToNumber(s string) uint64 {
-	var p uint64
-
-	p = 0
-	for _, r := range s {
-		if !unicode.IsDigit(r) {
-			break
-		}
-		p++
+// Parse a size like 700MB, 1.5GiB or 4096, failing on anything it
+// can't make sense of rather than guessing.
+func humanToNumber(s string) (uint64, error) {
+	p := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.'
+	})
+	if p < 0 {
+		p = len(s)
 	}
-	number, err := strconv.ParseUint(s[:p], 10, 64)
-	if err != nil {
-		return 0
+	number, unit := s[:p], strings.TrimSpace(s[p:])
+
+	whole, fraction, _ := strings.Cut(number, ".")
+	factor, ok := unitFactor(unit)
+	if whole == "" || strings.Contains(fraction, ".") || !ok {
+		return 0, fmt.Errorf("Invalid size %q.", s)
 	}
 
-	suffix := strings.ToLower(strings.TrimSpace(s[p:]))
-	factor := sizeTable[suffix]
-	number *= factor
+	// Work it out exactly, so 1.5GiB is exactly what it says.
+	value, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return 0, fmt.Errorf("Invalid size %q.", s)
+	}
+	value.Mul(value, new(big.Rat).SetUint64(factor))
+	size := new(big.Int).Quo(value.Num(), value.Denom())
+	if !size.IsUint64() {
+		return 0, fmt.Errorf("Size %q is too large.", s)
+	}
 
-	return number
+	return size.Uint64(), nil
 }
 
 func fit(files []*zip.FileHeader, config Config) ([]*Bucket, error) {
-	var buckets []*Bucket
+	newZipName, err := partNamer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := partOverhead(config)
+	if config.splitSize <= overhead {
+		return nil, errors.New("Split size too small.")
+	}
+	capacity := config.splitSize - overhead
+
+	items, leading, err := packItems(files, capacity, config)
+	if err != nil {
+		return nil, err
+	}
+	if leading != nil {
+		items = append(items, leading)
+	}
 
-	newZipName, err := numberedFileNamer(config.nameTemplate)
+	strategy := packStrategy(config)
+	bins, err := strategy(items, capacity)
 	if err != nil {
 		return nil, err
 	}
+	if config.balance {
+		bins = balanceBins(bins, items, capacity, strategy, config)
+	}
+	moveLeadingFirst(bins)
+
+	buckets := binsToBuckets(bins, newZipName)
+	if config.exactSize && config.format == formatZip {
+		return exactFit(buckets, capacity, newZipName, config)
+	}
+
+	return buckets, nil
+}
+
+// Return the packing strategy picked, or the default one.
+func packStrategy(config Config) pack.Strategy {
+	strategy := config.strategy
+	if strategy == nil {
+		strategy = pack.Strategies[pack.DefaultStrategy]
+	}
+	switch {
+	case config.maxFiles > 0 && config.preserveOrder:
+		strategy = nextFitFiles(config.maxFiles)
+	case config.maxFiles > 0:
+		strategy = limitFiles(strategy, config.maxFiles)
+	}
+
+	return strategy
+}
+
+// Turn the files into items to pack in parts of the given capacity,
+// returning the entries which have to lead the first part as a group
+// of their own, if any.
+func packItems(files []*zip.FileHeader, capacity uint64,
+	config Config) ([]pack.Item, *zipGroup, error) {
 
+	var items []pack.Item
+	leading := &zipGroup{leading: true}
 	for _, file := range files {
-		added := false
-
-		// Account for the overhead a zip64 file has;
-		// local file header is 45 bytes,
-		// central directory file header is 66 bytes
-		// so:
-		//     45 + filename size +
-		//     66 + filename size +
-		//     extra field size
-		//     comment size
-		// per file.
-		//
-		totalSize := uint64(45+66) +
-			uint64(len(file.Name))*2 +
-			uint64(len(file.Extra)) +
-			uint64(len(file.Comment)) +
-			uint64(file.CompressedSize64)
-
-		// The end of the central directory record is 30 bytes.
-		if config.splitSize <= 30 || totalSize > config.splitSize-30 {
-			return nil, fmt.Errorf("Can never fit %s (%s).",
-				file.Name,
-				numberToHuman(file.CompressedSize64))
+		item := zipItem{file, entrySize(file, config) +
+			parentDirsSize(file, config)}
+		if item.Size() > capacity && config.splitLarge {
+			chunks, err := chunkItems(file, capacity, config)
+			if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, chunks...)
+			continue
+		}
+		if item.Size() > capacity {
+			return nil, nil, withStatus(exitTooLarge, fmt.Errorf(
+				"Can never fit %s (%s).", file.Name,
+				numberToHuman(file.CompressedSize64)))
 		}
 
-		for _, bucket := range buckets {
-			if bucket.size+totalSize <= config.splitSize-30 {
-				bucket.size += totalSize
-				bucket.files = append(bucket.files, file)
-				added = true
-				break
+		if leadingRank(file, config) >= 0 {
+			leading.items = append(leading.items, item)
+		} else {
+			items = append(items, item)
+		}
+	}
+
+	if len(config.groups) > 0 {
+		var err error
+		if items, err = groupByRules(items, capacity, config); err != nil {
+			return nil, nil, err
+		}
+	}
+	if config.groupDepth > 0 {
+		items = groupByDir(items, capacity, config)
+	}
+	if config.groupBy == groupExt || config.groupBy == groupMime {
+		items = groupByType(items, capacity, config)
+	}
+	if config.dirs == dirsWithChildren {
+		items = groupDirsWithChildren(items, capacity)
+	}
+
+	if len(leading.items) == 0 {
+		return items, nil, nil
+	}
+	if leading.Size() > capacity {
+		return nil, nil, withStatus(exitTooLarge,
+			errors.New("Can never fit the leading entries."))
+	}
+	sortLeading(leading, config)
+
+	return items, leading, nil
+}
+
+func binsToBuckets(bins []*pack.Bin, newZipName func() string) []*Bucket {
+	var buckets []*Bucket
+
+	for _, bin := range bins {
+		bucket := &Bucket{Part: split.Part{
+			Name: newZipName(),
+			Size: bin.Size}}
+		for _, item := range bin.Items {
+			switch item := item.(type) {
+			case zipItem:
+				bucket.Files = append(bucket.Files, item.file)
+			case chunkItem:
+				bucket.Files = append(bucket.Files, item.file)
+				if bucket.Chunks == nil {
+					bucket.Chunks = make(map[*zip.FileHeader]split.Chunk)
+				}
+				bucket.Chunks[item.file] = item.chunk
+			case *zipGroup:
+				for _, groupItem := range item.items {
+					bucket.Files = append(bucket.Files, groupItem.file)
+				}
 			}
 		}
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+func makeManifest(buckets []*Bucket, contents *sourceContents,
+	config Config) *manifest.Manifest {
+
+	m := &manifest.Manifest{
+		Source:       config.sourceArchive,
+		SplitSize:    config.splitSize,
+		Measure:      config.measure,
+		NameTemplate: config.nameTemplate,
+		Hash:         config.hash,
+		SetID:        config.setID,
+		Damaged:      contents.damaged}
+
+	for _, bucket := range buckets {
+		part := manifest.Part{Name: bucket.Name}
+		for _, file := range bucket.Files {
+			entry := manifestEntry(file)
+			entry.Digest = contents.digests[file]
+			entry.Offset = contents.offsets[file]
+			part.Entries = append(part.Entries, entry)
+		}
+		m.Parts = append(m.Parts, part)
+	}
+	recordPartSums(m)
 
-		if !added {
-			buckets = append(buckets, &Bucket{
-				filename: newZipName(),
-				size:     file.CompressedSize64,
-				files:    []*zip.FileHeader{file}})
+	return m
+}
+
+// Fill in the size and CRC32 of the parts of the manifest which were
+// written in this run, or kept from an earlier one.
+func recordPartSums(m *manifest.Manifest) {
+	for _, written := range append(keptParts, writtenParts...) {
+		for i := range m.Parts {
+			if m.Parts[i].Name == written.Name {
+				m.Parts[i].Size = written.Size
+				m.Parts[i].CRC32 = written.CRC32
+			}
 		}
 	}
+}
 
-	return buckets, nil
+func manifestEntry(file *zip.FileHeader) manifest.Entry {
+	return manifest.Entry{
+		Name:             file.Name,
+		CRC32:            file.CRC32,
+		CompressedSize:   file.CompressedSize64,
+		UncompressedSize: file.UncompressedSize64}
+}
+
+// Subcommands, run as zipsplit <command> [options].
+var commands = map[string]func(args []string){
+	"append":      appendCommand,
+	"update":      updateCommand,
+	"remove":      removeCommand,
+	"list":        listCommand,
+	"find":        findCommand,
+	"ratio":       ratioCommand,
+	"plan":        planCommand,
+	"history":     historyCommand,
+	"join":        joinCommand,
+	"merge":       mergeCommand,
+	"seal-keygen": sealKeygenCommand,
+	"verify-seal": verifySealCommand,
 }
 
 func main() {
@@ -234,57 +637,1488 @@ func main() {
 	// Disable timestamps
 	log.SetFlags(0)
 
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
+
 	sourceArchive := flag.String(
 		"in",
 		"",
-		"Input archive name.")
+		"Input archive name, a zip, tar or tar.gz, or a directory;\n"+
+			"- to read it from standard input. May be an http(s), s3://\n"+
+			"or gs:// URL or an rclone remote:path.")
 
 	splitSizeString := flag.String(
 		"s",
 		"10Mb",
-		"Maximum size per part.")
+		"Maximum size per part, like 700MB or 4GiB.")
+
+	measure := flag.String(
+		"measure",
+		measureCompressed,
+		"What the size of a part is measured by: its compressed size,\n"+
+			"or the uncompressed size its entries take up once extracted,\n"+
+			"in whole clusters with -fs.")
+
+	marginString := flag.String(
+		"margin",
+		"",
+		"Leave this much room in every part, in bytes or as a\n"+
+			"percentage like 2%, for what the filesystem or transport adds.")
+
+	presetName := flag.String(
+		"preset",
+		"",
+		"Take the split size from a preset for a common target:\n"+
+			strings.Join(presetNames(), ", ")+".")
 
 	nameTemplate := flag.String(
 		"out",
 		"out-%03d.zip",
-		"Output name template in printf format.")
+		"Output name template, in printf format or with {num},\n"+
+			"{num:03} for a width, {total}, {source}, {date}, {size} and\n"+
+			"{sha256:8}; or - to write the parts to standard output as\n"+
+			"a tar stream. Parts go to\n"+
+			"object storage with an s3:// or gs:// URL, using the AWS_*\n"+
+			"or GS_ACCESS_KEY_ID and GS_SECRET_ACCESS_KEY variables.")
 
-	verbose := flag.Bool(
-		"v",
+	outDir := flag.String(
+		"outdir",
+		"",
+		"Directory to write the parts to, created when needed; -out\n"+
+			"then only names the parts.")
+
+	force := flag.Bool(
+		"force",
 		false,
-		"Show some information about the process.")
+		"Overwrite parts which are already there.")
 
-	flag.Parse()
+	skipExisting := flag.Bool(
+		"skip-existing",
+		false,
+		"Keep parts which are already there, as long as they hold the\n"+
+			"entries planned for them, to finish an interrupted split.")
 
-	if *sourceArchive == "" {
-		log.Fatal(errors.New("Please supply an input archive."))
-	}
+	resume := flag.Bool(
+		"resume",
+		false,
+		"Finish an interrupted split, keeping the parts written as\n"+
+			"planned, checked against the -manifest of an earlier run\n"+
+			"when there is one or else read again, and writing the others.")
 
-	config := Config{
-		sourceArchive: *sourceArchive,
-		nameTemplate:  *nameTemplate,
-		splitSize:     humanToNumber(*splitSizeString),
-		verbose:       *verbose}
+	manifestName := flag.String(
+		"manifest",
+		"",
+		"Write a JSON manifest of the split set to this file.")
 
-	files, err := getZipContents(config.sourceArchive)
-	if err != nil {
-		log.Fatal(err)
-	}
-	sort.Sort(sort.Reverse(bySize(files)))
+	onCorrupt := flag.String(
+		"on-corrupt",
+		corruptAbort,
+		"What to do with damaged entries: abort, skip or copy-anyway.")
 
-	buckets, err := fit(files, config)
-	if err != nil {
-		log.Fatal(err)
-	}
+	dedupe := flag.String(
+		"dedupe",
+		dedupeKeepAll,
+		"What to do with entries sharing a name: keep-first, keep-last\n"+
+			"or keep-all.")
 
-	if config.verbose {
-		fmt.Printf("Splitting takes %d files.\n", len(buckets))
-	}
+	dirs := flag.String(
+		"dirs",
+		dirsKeep,
+		"What to do with directory entries: keep them, drop them, keep\n"+
+			"them with-children in the same part, or recreate them in\n"+
+			"every part with entries under them.")
 
-	for _, bucket := range buckets {
-		err := bucket.makeZip(config)
-		if err != nil {
-			log.Fatal(err)
+	symlinks := flag.String(
+		"symlinks",
+		symlinksPreserve,
+		"What to do with symbolic links: preserve them, skip them, or\n"+
+			"follow them to the file they point to in the source.")
+
+	encoding := flag.String(
+		"encoding",
+		encodingKeep,
+		"What entry names without the UTF-8 flag are in: keep them as\n"+
+			"they are, or convert them to UTF-8 from cp437, shift-jis,\n"+
+			"gbk or the one auto detects, setting the flag.")
+
+	align := flag.Uint64(
+		"align",
+		0,
+		"Align stored entries to this many bytes, 0 to align only\n"+
+			"Android archives to 4 bytes and 1 to never align.")
+
+	format := flag.String(
+		"format",
+		formatZip,
+		"Format of the parts: zip or cpio.")
+
+	retries := flag.Int(
+		"retries",
+		5,
+		"How often to retry failed requests for remote archives.")
+
+	unsafePaths := flag.String(
+		"unsafe-paths",
+		unsafeWarn,
+		"What to do with entries with absolute or .. paths:\n"+
+			"warn, sanitize, exclude or abort.")
+
+	sanitize := flag.String(
+		"sanitize",
+		sanitizeOff,
+		"What to do with entries unsafe to extract on Windows, with\n"+
+			"backslashes, drive letters, .. components or reserved names\n"+
+			"like CON: off, reject them, rename the parts which are\n"+
+			"unsafe, or normalize them as Windows paths.")
+
+	var include, exclude patternList
+	flag.Var(&include, "include",
+		"Only split entries matching this glob pattern, where ** matches\n"+
+			"any number of directories. May be given more than once.")
+	flag.Var(&exclude, "exclude",
+		"Leave out entries matching this glob pattern, like -include.\n"+
+			"May be given more than once.")
+
+	excludeFrom := flag.String(
+		"exclude-from",
+		"",
+		"Leave out entries matching the patterns in this file, one\n"+
+			"per line.")
+
+	bombRatio := flag.Uint64(
+		"bomb-ratio",
+		100,
+		"Warn about entries compressed more than this ratio, 0 to\n"+
+			"not check.")
+
+	maxExpansion := flag.Uint64(
+		"max-expansion",
+		100,
+		"Warn when all entries expand to more than this multiple of\n"+
+			"the archive size, 0 to not check.")
+
+	abortOnBomb := flag.Bool(
+		"abort-on-bomb",
+		false,
+		"Abort instead of warning about possible zip bombs.")
+
+	maxMemoryString := flag.String(
+		"max-memory",
+		"",
+		"Try to stay within this much memory.")
+
+	exactSize := flag.Bool(
+		"exact-size",
+		false,
+		"Measure parts by simulating writing them instead of\n"+
+			"estimating their size.")
+
+	comment := flag.String(
+		"comment",
+		"",
+		"Archive comment for every part, like \"part {num} of {total}\",\n"+
+			"where {num} or {part}, {total}, {source} and {date} are\n"+
+			"filled in; the comment of the source when left out.")
+
+	appendComment := flag.Bool(
+		"append-comment",
+		false,
+		"Put the -comment after the comment of the source instead of\n"+
+			"replacing it.")
+
+	stripMetadataFlag := flag.Bool(
+		"strip-metadata",
+		false,
+		"Leave out comments, extra fields, times and permissions of the\n"+
+			"entries, and the comment of the source, for sharing.")
+
+	reproducible := flag.Bool(
+		"reproducible",
+		false,
+		"Write the same parts every time for the same entries: in\n"+
+			"order of name and with the time in SOURCE_DATE_EPOCH, or\n"+
+			"1980-01-01 when it is not set.")
+
+	recurseArchives := flag.Bool(
+		"recurse-archives",
+		false,
+		"Pack the entries of zips inside the source one by one.")
+
+	recurseDepth := flag.Int(
+		"recurse-depth",
+		1,
+		"How many levels of nested zips to expand.")
+
+	torrentName := flag.String(
+		"torrent",
+		"",
+		"Also write a torrent covering all parts to this file.")
+
+	announce := flag.String(
+		"announce",
+		"",
+		"The tracker URL to put in the torrent.")
+
+	fileSystem := flag.String(
+		"fs",
+		"",
+		"Make parts fit on this filesystem, fat32 or exfat.")
+
+	clusterSizeString := flag.String(
+		"cluster-size",
+		"",
+		"The cluster size of the filesystem, when not the usual one.")
+
+	xattrs := flag.String(
+		"xattrs",
+		xattrKeep,
+		"What to do with extra fields holding extended attributes, keep or strip.")
+
+	overheadLocal := flag.Uint64(
+		"overhead-local",
+		split.DefaultOverhead.Local,
+		"Bytes to assume for the local header of an entry.")
+
+	overheadCentral := flag.Uint64(
+		"overhead-central",
+		split.DefaultOverhead.Central,
+		"Bytes to assume for the central directory header of an entry.")
+
+	overheadExtra := flag.Uint64(
+		"overhead-extra",
+		split.DefaultOverhead.Extra,
+		"Bytes to assume on top of that for every entry.")
+
+	forceZip64 := flag.Bool(
+		"force-zip64",
+		false,
+		"End every part with zip64 records, even when it doesn't need them.")
+
+	noZip64 := flag.Bool(
+		"no-zip64",
+		false,
+		"Keep every part small enough to do without zip64, for old unzip\n"+
+			"tools: below 4GiB, with fewer than 65535 entries of less than\n"+
+			"4GiB each.")
+
+	streaming := flag.Bool(
+		"streaming",
+		false,
+		"Split in a single pass using memory bounded by the split size,\n"+
+			"keeping entries in order and leaving out checks, the manifest\n"+
+			"and anything else needing all entries at once.")
+
+	sealKeyName := flag.String(
+		"seal",
+		"",
+		"Seal every part with the key in this file, see seal-keygen.")
+
+	password := flag.String(
+		"password",
+		"",
+		"Decrypt the encrypted entries of the source with this password,\n"+
+			"- to be asked for it, or taken from $"+passwordEnv+" when left out,\n"+
+			"which keeps it out of the process list and shell history.")
+
+	recompress := flag.Bool(
+		"recompress",
+		false,
+		"Compress the entries anew instead of copying them as they are.")
+
+	level := flag.Int(
+		"level",
+		flate.DefaultCompression,
+		"Deflate level to recompress with, 0 to 9, or -1 for the default.")
+
+	methodName := flag.String(
+		"method",
+		methodDeflate,
+		"Method to recompress with, deflate, store or zstd. Not\n"+
+			"every unzip can extract zstd entries.")
+
+	encrypt := flag.Bool(
+		"encrypt",
+		false,
+		"Encrypt the entries of the parts with AES-256.")
+
+	outPassword := flag.String(
+		"out-password",
+		"",
+		"Password to encrypt the parts with, - to be asked for it, or\n"+
+			"taken from $"+outPasswordEnv+" when left out, which keeps it\n"+
+			"out of the process list and shell history.")
+
+	recoverEntries := flag.Bool(
+		"recover",
+		false,
+		"When the central directory of the source is damaged, salvage\n"+
+			"the entries which can be found from their local headers.")
+
+	eventFormat := flag.String(
+		"events",
+		"",
+		"Write an event stream for programs to stdout, ndjson.")
+
+	reportFormat := flag.String(
+		"report",
+		"",
+		"Print the parts written to stdout once done, json, with their\n"+
+			"path, size and entries.")
+
+	progressFormat := flag.String(
+		"progress",
+		progressNone,
+		"Show how far writing the parts got on stderr: none, bar,\n"+
+			"plain or json.")
+
+	checkpointName := flag.String(
+		"checkpoint",
+		"",
+		"With -streaming, save progress to this file now and then and\n"+
+			"resume from it when it exists.")
+
+	logTarget := flag.String(
+		"log-target",
+		logStderr,
+		"Where to log progress and failures, stderr or syslog.")
+
+	jsonErrorsFlag := flag.Bool(
+		"json-errors",
+		false,
+		"Report a failure on stderr as a JSON object with the error,\n"+
+			"its kind and the exit status: 1 for failure, 2 usage, 3\n"+
+			"source, 4 too_large, 5 write and 6 verify.")
+
+	destinations := flag.String(
+		"dest",
+		"",
+		"Fill these dir=size destinations in turn, separated by commas.")
+
+	strategyName := flag.String(
+		"strategy",
+		pack.DefaultStrategy,
+		"How to pack entries into parts: first-fit, first-fit-decreasing,\n"+
+			"best-fit, worst-fit, next-fit or original-order.")
+
+	preserveOrder := flag.Bool(
+		"preserve-order",
+		false,
+		"Keep the entries in the order of the source, starting a new\n"+
+			"part whenever the next one doesn't fit.")
+
+	balance := flag.Bool(
+		"balance",
+		false,
+		"Make the parts about equally large, keeping their number to\n"+
+			"the least needed, instead of filling all but the last.")
+
+	groupByDir := flag.Bool(
+		"group-by-dir",
+		false,
+		"Keep the entries under the same directory in one part when\n"+
+			"they fit.")
+
+	groupDepth := flag.Int(
+		"group-depth",
+		1,
+		"How many directories deep -group-by-dir groups entries.")
+
+	groupBy := flag.String(
+		"group-by",
+		"",
+		"Keep entries of the same kind in as few parts as they fit in:\n"+
+			"dir like -group-by-dir, ext for the same file extension or\n"+
+			"mime for the same kind of MIME type, like image or text.")
+
+	groupsName := flag.String(
+		"groups",
+		"",
+		"Keep the entries of every group in this file in one part. Its\n"+
+			"lines have a group name and a glob pattern of its entries.")
+
+	spannedOutput := flag.Bool(
+		"spanned",
+		false,
+		"Write one split archive in volumes of the split size, named\n"+
+			"like out.z01, out.z02 and out.zip, instead of separate zips.")
+
+	volumeNames := flag.String(
+		"volume-names",
+		volumeNamesZip,
+		"How to name the volumes of -spanned: zip for out.z01, out.z02\n"+
+			"and out.zip, or 7zip for out.zip.001, out.zip.002 and so on,\n"+
+			"which 7-Zip reads as one archive.")
+
+	splitLarge := flag.Bool(
+		"split-large-files",
+		false,
+		"Cut entries too large for a part into chunks spread over\n"+
+			"several parts, see zipsplit join.")
+
+	maxParts := flag.Int(
+		"max-parts",
+		0,
+		"Grow the split size when needed to make at most this many parts.")
+
+	parts := flag.Int(
+		"parts",
+		0,
+		"Spread the entries evenly over exactly this many parts,\n"+
+			"picking the split size to match instead of taking -s.")
+
+	jobs := flag.Int(
+		"jobs",
+		1,
+		"Write this many parts at once, from a local source to local\n"+
+			"parts, and with -recompress compress this many entries at\n"+
+			"once.")
+
+	maxFiles := flag.Int(
+		"max-files",
+		0,
+		"Put at most this many entries in a part, 0 for no limit.")
+
+	joinScripts := flag.Bool(
+		"join-scripts",
+		false,
+		"Also write join.sh and join.cmd which extract all parts\n"+
+			"into one directory and check the files.")
+
+	checksum := flag.String(
+		"checksum",
+		"",
+		"Also write a SHA256SUMS style file for the parts using this\n"+
+			"hash, sha256, sha1, md5 or crc32.")
+
+	checksumFiles := flag.Bool(
+		"checksum-files",
+		false,
+		"Also give every part a checksum file of its own, like\n"+
+			"out-001.zip.sha256.")
+
+	digests := flag.Bool(
+		"sha256",
+		false,
+		"Record the SHA-256 digest of every entry in the manifest,\n"+
+			"like -hash sha256.")
+
+	hashName := flag.String(
+		"hash",
+		"",
+		"Record a digest of every entry in the manifest using this\n"+
+			"hash, crc32, sha1, sha256 or blake3.")
+
+	quickVerify := flag.Bool(
+		"quick-verify",
+		false,
+		"Check the written parts against the source without\n"+
+			"decompressing them.")
+
+	verifyParts := flag.Bool(
+		"verify",
+		false,
+		"Read back the written parts and check the CRC32 and size\n"+
+			"of every entry against the source.")
+
+	dryRun := flag.Bool(
+		"dry-run",
+		false,
+		"Only show which entries would go in which part.")
+	flag.BoolVar(dryRun, "n", false, "Short for -dry-run.")
+
+	analyze := flag.Bool(
+		"analyze",
+		false,
+		"Only show what the source holds, its largest entries, how\n"+
+			"many parts it takes and which entries never fit in one.")
+
+	journal := flag.String(
+		"journal",
+		"",
+		"Record the run in this journal, like "+defaultJournal()+",\n"+
+			"for zipsplit history. Secrets like passwords are left out.")
+
+	cpuProfile := flag.String(
+		"cpuprofile",
+		"",
+		"Write a CPU profile to this file.")
+
+	memProfile := flag.String(
+		"memprofile",
+		"",
+		"Write a memory profile to this file.")
+
+	verbose := flag.Bool(
+		"v",
+		false,
+		"Show some information about the process, like which part\n"+
+			"every entry goes in.")
+
+	quiet := flag.Bool(
+		"q",
+		false,
+		"Show nothing but failures.")
+
+	debug := flag.Bool(
+		"debug",
+		false,
+		"Also show how the sizes of entries and parts are worked out.")
+
+	flag.Parse()
+
+	// Failures are down to the options given, until the source is
+	// opened.
+	jsonErrors = *jsonErrorsFlag
+	exitStatus = exitUsage
+
+	switch *logTarget {
+	case logStderr:
+	case logSyslog:
+		if err := logToSyslog(); err != nil {
+			fatal(err)
+		}
+	default:
+		fatalf("Invalid log target %q.", *logTarget)
+	}
+
+	switch *eventFormat {
+	case "":
+	case eventsNDJSON:
+		enableEvents()
+	default:
+		fatalf("Invalid event format %q.", *eventFormat)
+	}
+
+	switch *reportFormat {
+	case "":
+	case reportJSON:
+		if *eventFormat != "" || *nameTemplate == stdoutName {
+			fatal(errors.New("The report cannot share standard output " +
+				"with events or the parts."))
+		}
+		if *streaming || *spannedOutput || *dryRun || *analyze {
+			fatal(errors.New("Only planned parts which are written can " +
+				"be reported, without -streaming, -spanned, -dry-run " +
+				"or -analyze."))
+		}
+		if progress == io.Writer(os.Stdout) {
+			progress = os.Stderr
+		}
+	default:
+		fatalf("Invalid report format %q.", *reportFormat)
+	}
+
+	switch {
+	case *quiet && (*verbose || *debug):
+		fatal(errors.New("-q cannot be combined with -v or -debug."))
+	case *quiet:
+		setLogLevel(levelQuiet)
+	case *debug:
+		setLogLevel(levelDebug)
+	case *verbose:
+		setLogLevel(levelVerbose)
+	}
+
+	switch *progressFormat {
+	case progressNone, progressBar, progressPlain, progressJSON:
+	default:
+		fatalf("Invalid progress format %q.", *progressFormat)
+	}
+
+	if *sourceArchive == "" {
+		fatal(errors.New("Please supply an input archive."))
+	}
+
+	warnPasswordArg("password", *password, passwordEnv)
+	warnPasswordArg("out-password", *outPassword, outPasswordEnv)
+
+	if *excludeFrom != "" {
+		if err := exclude.load(*excludeFrom); err != nil {
+			fatal(err)
+		}
+	}
+
+	var groups []groupRule
+	if *groupsName != "" {
+		var err error
+		if groups, err = loadGroups(*groupsName); err != nil {
+			fatal(err)
+		}
+	}
+
+	if sink.IsObjectURL(*nameTemplate) {
+		if _, _, err := sink.ParseObjectURL(*nameTemplate); err != nil {
+			fatal(err)
+		}
+	}
+
+	// Parts written to standard output keep their usual names inside
+	// the tar stream.
+	toStdout := *nameTemplate == stdoutName
+	switch *format {
+	case formatZip:
+		if toStdout {
+			*nameTemplate = "out-%03d.zip"
+		}
+	case formatCpio:
+		if !isFlagSet("out") || toStdout {
+			*nameTemplate = "out-%03d.cpio"
+		}
+	default:
+		fatalf("Invalid part format %q.", *format)
+	}
+
+	switch *unsafePaths {
+	case unsafeWarn, unsafeSanitize, unsafeExclude, unsafeAbort:
+	default:
+		fatalf("Invalid unsafe path policy %q.", *unsafePaths)
+	}
+
+	switch *sanitize {
+	case sanitizeOff, sanitizeReject, sanitizeRename, sanitizeNormalize:
+	default:
+		fatalf("Invalid Windows path policy %q.", *sanitize)
+	}
+
+	if *comment != "" && *format != formatZip {
+		fatal(errors.New("Only zip parts can have a comment."))
+	}
+	if err := checkCommentTemplate(*comment); err != nil {
+		fatal(err)
+	}
+	if *appendComment && *comment == "" {
+		fatal(errors.New("-append-comment needs a -comment to append."))
+	}
+
+	if *torrentName != "" && isRemote(*nameTemplate) {
+		fatal(errors.New("Only local parts can be put in a torrent."))
+	}
+
+	if *joinScripts && (*format != formatZip || isRemote(*nameTemplate)) {
+		fatal(errors.New("Only local zip parts can be joined by scripts."))
+	}
+
+	if toStdout {
+		if *eventFormat != "" {
+			fatal(errors.New("Events cannot share standard output " +
+				"with the parts."))
+		}
+		if *torrentName != "" || *joinScripts || *checksum != "" ||
+			*checkpointName != "" || *spannedOutput || *quickVerify ||
+			*verifyParts || *destinations != "" {
+			fatal(errors.New("Parts on standard output are not kept, " +
+				"without options reading them back or placing them."))
+		}
+		if progress == io.Writer(os.Stdout) {
+			progress = os.Stderr
+		}
+	}
+
+	if *outDir != "" {
+		if isRemote(*nameTemplate) || toStdout ||
+			filepath.IsAbs(*nameTemplate) || *destinations != "" {
+			fatal(errors.New("An output directory needs -out to be " +
+				"a relative file name, without -destinations."))
+		}
+		if !*dryRun && !*analyze {
+			if err := os.MkdirAll(*outDir, 0755); err != nil {
+				fatal(err)
+			}
+		}
+		dir := *outDir
+		if !isNameTemplate(*nameTemplate) {
+			dir = strings.ReplaceAll(dir, "%", "%%")
+		}
+		*nameTemplate = filepath.Join(dir, *nameTemplate)
+	}
+
+	if isNameTemplate(*nameTemplate) {
+		if err := checkNameTemplate(*nameTemplate, *streaming); err != nil {
+			fatal(err)
+		}
+		if *spannedOutput || *destinations != "" {
+			fatal(errors.New("Parts named with {variables} cannot be " +
+				"spanned or spread over -destinations."))
+		}
+		if namedByContents(*nameTemplate) && isRemote(*nameTemplate) {
+			fatal(errors.New(
+				"Only local parts can be named by their size or digest."))
+		}
+	}
+
+	if *checksum != "" {
+		if _, err := newChecksum(*checksum); err != nil {
+			fatal(err)
+		}
+		if isRemote(*nameTemplate) || *destinations != "" {
+			fatal(errors.New(
+				"Only local parts in one directory can have checksums."))
+		}
+	}
+	if *force && *skipExisting || *force && *resume ||
+		*skipExisting && *resume {
+		fatal(errors.New("Pick one of -force, -skip-existing and -resume."))
+	}
+	if *resume && *streaming {
+		fatal(errors.New("Streamed splits are resumed from a -checkpoint."))
+	}
+	if *resume && (isRemote(*nameTemplate) || *destinations != "" ||
+		*nameTemplate == stdoutName) {
+		fatal(errors.New("Only local parts can be resumed."))
+	}
+	if (*skipExisting || *resume) && (*format != formatZip || *encrypt || *streaming ||
+		*spannedOutput) {
+		fatal(errors.New("Only zip parts can be kept, without " +
+			"-encrypt, -streaming or -spanned."))
+	}
+
+	if *checksumFiles && *checksum == "" {
+		fatal(errors.New("Checksum files need -checksum."))
+	}
+
+	if *maxParts < 0 {
+		fatal(errors.New("The maximum number of parts cannot be negative."))
+	}
+	if *parts < 0 {
+		fatal(errors.New("The number of parts cannot be negative."))
+	}
+	if *parts > 0 && (isFlagSet("s") || *presetName != "" ||
+		*marginString != "" || *maxParts > 0 || *maxFiles > 0 ||
+		*exactSize || *splitLarge || *strategyName != pack.DefaultStrategy) {
+		fatal(errors.New("-parts picks the split size itself, without " +
+			"-s, -preset, -margin, -max-parts, -max-files, -exact-size, " +
+			"-split-large-files or -strategy."))
+	}
+	if *jobs < 1 {
+		fatal(errors.New("The number of jobs must be at least 1."))
+	}
+	if *jobs > 1 && (isRemote(*sourceArchive) || isRemote(*nameTemplate)) {
+		fatal(errors.New("Only local parts from a local source can " +
+			"be written in parallel."))
+	}
+	if *maxFiles < 0 {
+		fatal(errors.New("The maximum number of entries cannot be negative."))
+	}
+	if *forceZip64 && *noZip64 {
+		fatal(errors.New("Pick one of -force-zip64 and -no-zip64."))
+	}
+	if (*forceZip64 || *noZip64) && *format != formatZip {
+		fatal(errors.New("Only zip parts can use zip64."))
+	}
+	if (isFlagSet("level") || isFlagSet("method")) && !*recompress {
+		fatal(errors.New("-level and -method are for -recompress."))
+	}
+	if *level < flate.DefaultCompression || *level > flate.BestCompression {
+		fatal(errors.New("The level must be between -1 and 9."))
+	}
+	method, err := parseMethod(*methodName)
+	if err != nil {
+		fatal(err)
+	}
+	if *outPassword != "" && !*encrypt {
+		fatal(errors.New("A password for the parts needs -encrypt."))
+	}
+	if *encrypt && (*format != formatZip || *splitLarge || *quickVerify ||
+		*spannedOutput || *align > 1) {
+		fatal(errors.New("Only whole entries of zip parts can be " +
+			"encrypted, without -split-large-files, -quick-verify, " +
+			"-spanned or -align."))
+	}
+	if *encrypt && *sealKeyName != "" {
+		fatal(errors.New("Encrypted parts cannot be sealed, verify-seal " +
+			"has no password to read them with."))
+	}
+	if *reproducible && (*encrypt || *sealKeyName != "" || *streaming) {
+		fatal(errors.New("Reproducible parts cannot be encrypted, " +
+			"sealed or streamed."))
+	}
+
+	var dests []destination
+	if *destinations != "" {
+		var err error
+		if dests, err = parseDestinations(*destinations); err != nil {
+			fatal(err)
+		}
+		if isRemote(*nameTemplate) {
+			fatal(errors.New("Destinations have to be local."))
+		}
+		if *maxParts > 0 || *parts > 0 || *maxFiles > 0 || *exactSize ||
+			*strategyName != pack.DefaultStrategy || *balance {
+			fatal(errors.New("Destinations cannot be combined with " +
+				"-max-parts, -parts, -max-files, -exact-size, -strategy " +
+				"or -balance."))
+		}
+	}
+
+	sourceDir := isSourceDir(*sourceArchive)
+	if sourceDir && (*format != formatZip || *streaming ||
+		*recurseArchives || *recoverEntries || *recompress) {
+		fatal(errors.New(
+			"Directories are split into zip parts, without -streaming, " +
+				"-recurse-archives, -recover or -recompress."))
+	}
+
+	switch *groupBy {
+	case "":
+	case groupDir:
+		*groupByDir = true
+	case groupExt, groupMime:
+		if *groupByDir {
+			fatal(errors.New("Pick one of -group-by-dir and -group-by."))
+		}
+	default:
+		fatalf("Invalid grouping %q.", *groupBy)
+	}
+
+	if *streaming && (*format != formatZip || *manifestName != "" ||
+		*exactSize || *comment != "" || *digests || *hashName != "" ||
+		*recurseArchives || *recoverEntries || *sealKeyName != "" ||
+		*torrentName != "" || *joinScripts || *checksum != "" ||
+		*destinations != "" || *maxParts > 0 || *parts > 0 || *quickVerify || *verifyParts ||
+		*dryRun || *analyze || *strategyName != pack.DefaultStrategy ||
+		*groupByDir || *groupBy != "" || *groupsName != "" ||
+		*splitLarge || *jobs > 1 ||
+		*noZip64 || *recompress ||
+		*dedupe != dedupeKeepAll || *dirs == dirsWithChildren ||
+		*dirs == dirsRecreate || *symlinks == symlinksFollow || *balance) {
+		fatal(errors.New(
+			"Streaming only writes zip parts, without options needing all entries."))
+	}
+
+	if *checkpointName != "" && (!*streaming || isRemote(*nameTemplate)) {
+		fatal(errors.New("Checkpoints need -streaming and local parts."))
+	}
+
+	// Standard input can't be read again to resume from, and gives the
+	// scripts no name to rebuild the source as.
+	if *sourceArchive == stdinName && (*checkpointName != "" || *joinScripts) {
+		fatal(errors.New("A source from standard input cannot be " +
+			"combined with -checkpoint or -join-scripts."))
+	}
+	defer closeStdin()
+
+	if *spannedOutput {
+		if !isFlagSet("out") {
+			*nameTemplate = "out.zip"
+		}
+		if *format != formatZip || isRemote(*nameTemplate) || *streaming ||
+			*manifestName != "" || *sealKeyName != "" || *torrentName != "" ||
+			*joinScripts || *checksum != "" || *destinations != "" || *maxParts > 0 ||
+			*parts > 0 || *maxFiles > 0 || *quickVerify || *verifyParts ||
+			*dryRun || *analyze || *splitLarge || *groupByDir || *groupBy != "" ||
+			*groupsName != "" ||
+			*exactSize || *jobs > 1 || *dirs == dirsWithChildren ||
+			*dirs == dirsRecreate || *strategyName != pack.DefaultStrategy ||
+			*forceZip64 || *noZip64 || *balance {
+			fatal(errors.New(
+				"Spanned archives are written as local zip volumes, " +
+					"without options for separate parts."))
+		}
+	}
+
+	if *splitLarge && (*format != formatZip || *exactSize ||
+		*sealKeyName != "") {
+		fatal(errors.New(
+			"Large files can only be cut into zip parts, without " +
+				"-exact-size or -seal."))
+	}
+
+	switch *measure {
+	case measureCompressed:
+	case measureUncompressed:
+		if *exactSize || *splitLarge || *spannedOutput ||
+			*destinations != "" {
+			fatal(errors.New("Parts measured by their uncompressed size " +
+				"cannot be combined with -exact-size, -split-large-files, " +
+				"-spanned or -destinations."))
+		}
+	default:
+		fatalf("Invalid measure %q.", *measure)
+	}
+
+	if *groupDepth < 1 {
+		fatal(errors.New("The group depth must be at least 1."))
+	}
+	if !*groupByDir {
+		*groupDepth = 0
+	}
+
+	if *recurseDepth < 1 {
+		fatal(errors.New("The recurse depth must be at least 1."))
+	}
+	if !*recurseArchives {
+		*recurseDepth = 0
+	}
+
+	if (*quickVerify || *verifyParts) && *format != formatZip {
+		fatal(errors.New("Only zip parts can be verified."))
+	}
+
+	if (*digests || *sealKeyName != "") && *hashName == "" {
+		*hashName = hashSHA256
+	}
+	if *sealKeyName != "" && (*hashName != hashSHA256 || *format != formatZip) {
+		fatal(errors.New("Sealing needs zip parts and -hash sha256."))
+	}
+	if *hashName != "" {
+		if _, err := newHash(*hashName); err != nil {
+			fatal(err)
+		}
+	}
+
+	if *preserveOrder {
+		if isFlagSet("strategy") || *parts > 0 || *destinations != "" ||
+			*groupByDir || *groupBy != "" || *groupsName != "" ||
+			*dirs == dirsWithChildren {
+			fatal(errors.New("-preserve-order packs the entries in the " +
+				"order of the source, without -strategy, -parts, " +
+				"-destinations, -group-by-dir, -group-by, -groups or " +
+				"-dirs with-children."))
+		}
+		*strategyName = "original-order"
+	}
+	strategy, ok := pack.Strategies[*strategyName]
+	if !ok {
+		fatalf("Invalid packing strategy %q.", *strategyName)
+	}
+
+	switch *xattrs {
+	case xattrKeep, xattrStrip:
+	default:
+		fatalf("Invalid extended attribute policy %q.", *xattrs)
+	}
+
+	switch *onCorrupt {
+	case corruptAbort, corruptSkip, corruptCopyAnyway:
+	default:
+		fatalf("Invalid damaged entry policy %q.", *onCorrupt)
+	}
+
+	switch *dedupe {
+	case dedupeKeepFirst, dedupeKeepLast, dedupeKeepAll:
+	default:
+		fatalf("Invalid duplicate name policy %q.", *dedupe)
+	}
+
+	switch *volumeNames {
+	case volumeNamesZip, volumeNames7Zip:
+	default:
+		fatalf("Invalid volume naming %q.", *volumeNames)
+	}
+	if isFlagSet("volume-names") && !*spannedOutput {
+		fatal(errors.New("-volume-names only applies to -spanned."))
+	}
+
+	switch *dirs {
+	case dirsKeep, dirsDrop, dirsWithChildren, dirsRecreate:
+	default:
+		fatalf("Invalid directory entry policy %q.", *dirs)
+	}
+
+	switch *symlinks {
+	case symlinksPreserve, symlinksSkip, symlinksFollow:
+	default:
+		fatalf("Invalid symbolic link policy %q.", *symlinks)
+	}
+
+	switch *encoding {
+	case encodingKeep, encodingAuto, encodingCP437, encodingShiftJIS,
+		encodingGBK:
+	default:
+		fatalf("Invalid name encoding %q.", *encoding)
+	}
+	if *dirs == dirsRecreate && (*format != formatZip || *exactSize) {
+		fatal(errors.New("Directory entries are only recreated in zip " +
+			"parts, without -exact-size."))
+	}
+
+	overhead := split.Overhead{
+		Local:   *overheadLocal,
+		Central: *overheadCentral,
+		Extra:   *overheadExtra}
+
+	config := Config{
+		sourceArchive: *sourceArchive,
+		nameTemplate:  *nameTemplate,
+		manifestName:  *manifestName,
+		onCorrupt:     *onCorrupt,
+		dedupe:        *dedupe,
+		dirs:          *dirs,
+		volumeNames:   *volumeNames,
+		symlinks:      *symlinks,
+		encoding:      *encoding,
+		measure:       *measure,
+		align:         *align,
+		format:        *format,
+		retries:       *retries,
+		quickVerify:   *quickVerify,
+		verify:        *verifyParts,
+		unsafePaths:   *unsafePaths,
+		sanitize:      *sanitize,
+		include:       include,
+		exclude:       exclude,
+		bombRatio:     *bombRatio,
+		maxExpansion:  *maxExpansion,
+		abortOnBomb:   *abortOnBomb,
+		exactSize:     *exactSize,
+		comment:       *comment,
+		appendComment: *appendComment,
+		hash:          *hashName,
+		recurseDepth:  *recurseDepth,
+		torrentName:   *torrentName,
+		announce:      *announce,
+		joinScripts:   *joinScripts,
+		checksum:      *checksum,
+		checksumFiles: *checksumFiles,
+		startTime:     time.Now(),
+		partSink:      partSinkFor(*nameTemplate),
+		overhead:      overhead,
+		strategy:      strategy,
+		preserveOrder: *strategyName == "original-order",
+		balance:       *balance,
+		groupDepth:    *groupDepth,
+		groupBy:       *groupBy,
+		groups:        groups,
+		splitLarge:    *splitLarge,
+		maxFiles:      *maxFiles,
+		password:      *password,
+		recompress:    *recompress,
+		stripMetadata: *stripMetadataFlag,
+		reproducible:  *reproducible,
+		overwrite:     *force,
+		skipExisting:  *skipExisting,
+		resume:        *resume,
+		method:        method,
+		level:         *level,
+		forceZip64:    *forceZip64,
+		noZip64:       *noZip64,
+		jobs:          *jobs,
+		xattrs:        *xattrs,
+		checkpoint:    *checkpointName,
+		verbose:       logLevel >= levelVerbose}
+
+	if config.splitSize, err = humanToNumber(*splitSizeString); err != nil {
+		fatal(err)
+	}
+
+	// The time of reproducible parts stands in for the current one in
+	// what is written, so a {date} in the comment stays the same as
+	// well. The journal still gets the time the run started.
+	config.entryTime = config.startTime
+	if config.reproducible {
+		if config.entryTime, err = reproducibleTime(); err != nil {
+			fatal(err)
+		}
+	}
+
+	if fileSink, ok := config.partSink.(sink.File); ok {
+		fileSink.Overwrite = config.overwrite || config.resume
+		config.partSink = fileSink
+	}
+	handleInterrupts(config, *format == formatZip && !*encrypt &&
+		!*streaming && !*spannedOutput && !isRemote(*nameTemplate) &&
+		*destinations == "" && !toStdout)
+	if toStdout {
+		tarSink := sink.NewTar(os.Stdout)
+		tarSink.ModTime = config.entryTime
+		config.partSink = tarSink
+	}
+
+	// The margin of a preset makes way for one asked for.
+	var margin uint64
+	if *presetName != "" {
+		if isFlagSet("s") {
+			fatal(errors.New("A preset sets the split size, leave out -s."))
+		}
+		p, err := lookupPreset(*presetName)
+		if err != nil {
+			fatal(err)
+		}
+		config.splitSize, margin = p.limit, p.margin
+	}
+	if *marginString != "" {
+		if margin, err = parseMargin(*marginString, config.splitSize); err != nil {
+			fatal(err)
+		}
+	}
+	if margin >= config.splitSize {
+		fatal(errors.New("The margin leaves no room for entries."))
+	}
+	config.splitSize -= margin
+	if *maxMemoryString != "" {
+		if config.maxMemory, err = humanToNumber(*maxMemoryString); err != nil {
+			fatal(err)
+		}
+		if jobs := jobsWithinMemory(config); jobs < config.jobs {
+			debugf("Limiting -jobs to %d to stay within %s.", jobs,
+				numberToHuman(config.maxMemory))
+			config.jobs = jobs
+		}
+	}
+
+	if *fileSystem != "" {
+		var clusterSize uint64
+		if *clusterSizeString != "" {
+			if clusterSize, err = humanToNumber(*clusterSizeString); err != nil {
+				fatal(err)
+			}
+		}
+		if err := applyFilesystem(*fileSystem, clusterSize, &config); err != nil {
+			fatal(err)
+		}
+	} else if *clusterSizeString != "" {
+		fatal(errors.New("A cluster size needs a filesystem."))
+	}
+
+	if config.noZip64 {
+		limitToZip32(&config)
+	}
+
+	if *sealKeyName != "" {
+		if config.sealKey, err = loadSealKey(*sealKeyName); err != nil {
+			fatal(err)
+		}
+		if config.setID, err = newSetID(); err != nil {
+			fatal(err)
+		}
+	}
+
+	if *encrypt {
+		if config.outPassword, err = partsPassword(*outPassword); err != nil {
+			fatal(err)
+		}
+	}
+
+	if config.align == 0 && !*encrypt && isAlignedArchive(config.sourceArchive) {
+		config.align = 4
+	}
+
+	applyMemoryLimit(config)
+
+	stopProfiling, err := startProfiling(*cpuProfile, *memProfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer stopProfiling()
+
+	if *streaming {
+		exitStatus = exitWrite
+		startMeter(*progressFormat, 0)
+		parts, err := streamSplit(config)
+		if err == nil {
+			err = closeSink(config)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		meter.finish()
+		saveJournal(*journal, config)
+		emitEvent(event{Event: "finished", Parts: parts})
+		return
+	}
+
+	var contents *sourceContents
+	var writePart func(bucket *Bucket) error
+	var openRaw func(file *zip.FileHeader) (io.ReadCloser, error)
+
+	exitStatus = exitSource
+	if sourceDir {
+		var paths dirSource
+		if contents, paths, err = scanDir(config); err != nil {
+			fatal(err)
+		}
+		writePart = func(bucket *Bucket) error {
+			return bucket.writePart(config, func(dst io.Writer) error {
+				return bucket.copyFilesDir(paths, dst, config)
+			})
+		}
+		openRaw = func(file *zip.FileHeader) (io.ReadCloser, error) {
+			return compressDirEntry(file, paths[file])
+		}
+	} else {
+		sourceReader, sourceCloser, err := openSource(config)
+		var lost []manifest.Damaged
+		if err != nil && *recoverEntries {
+			warnings.Printf("%v, trying to recover entries.", err)
+			sourceReader, sourceCloser, lost, err = recoverSource(config)
+		}
+		if err != nil {
+			fatal(err)
+		}
+		defer sourceCloser.Close()
+
+		// Rebuilding the source loses its comment, so hold on to it.
+		if !config.stripMetadata {
+			config.sourceComment = sourceReader.Comment
+		}
+
+		// Whether the source is rebuilt in a temporary archive.
+		rebuilt := false
+		if hasEncrypted(sourceReader) {
+			password, err := sourcePassword(config.password)
+			if err != nil {
+				fatal(err)
+			}
+			if password == "" {
+				warnings.Printf("The source has encrypted entries, " +
+					"they are copied as they are.")
+			} else {
+				decryptedReader, decryptedCloser, err := decryptArchive(
+					sourceReader, password)
+				if err != nil {
+					fatal(err)
+				}
+				defer decryptedCloser.Close()
+				sourceReader, rebuilt = decryptedReader, true
+			}
+		}
+
+		if config.recompress {
+			recompressedReader, recompressedCloser, err := recompressArchive(
+				sourceReader, config.method, config.level, config.jobs)
+			if err != nil {
+				fatal(err)
+			}
+			defer recompressedCloser.Close()
+			sourceReader, rebuilt = recompressedReader, true
+		}
+
+		if err := checkPlanningMemory(sourceReader.File, config); err != nil {
+			fatal(err)
+		}
+
+		if err := checkBomb(sourceReader, config); err != nil {
+			fatal(err)
+		}
+
+		if config.recurseDepth > 0 {
+			expandedReader, expandedCloser, err := expandArchives(sourceReader,
+				config.recurseDepth, config)
+			if err != nil {
+				fatal(err)
+			}
+			defer expandedCloser.Close()
+			sourceReader = expandedReader
+		}
+
+		contents, err = getZipContents(sourceReader, config)
+		if err != nil {
+			fatal(err)
+		}
+		contents.damaged = append(lost, contents.damaged...)
+
+		// Offsets into a rebuilt archive mean nothing next to the
+		// source.
+		if _, temporary := sourceCloser.(tempFile); temporary || rebuilt ||
+			config.recurseDepth > 0 {
+			contents.offsets = nil
+		}
+
+		source := split.NewSource(sourceReader)
+		for fh, target := range contents.links {
+			source.Alias(fh, target)
+		}
+		writePart = func(bucket *Bucket) error {
+			return bucket.makeZip(source, config)
+		}
+		openRaw = func(file *zip.FileHeader) (io.ReadCloser, error) {
+			f, _ := source.Find(file)
+			r, err := f.OpenRaw()
+			return io.NopCloser(r), err
+		}
+	}
+
+	exitStatus = exitFailure
+	files, err := checkPaths(contents.files, config)
+	if err != nil {
+		fatal(err)
+	}
+	checkXattrs(files, config)
+	if config.stripMetadata {
+		stripMetadata(files)
+	}
+	if config.reproducible {
+		normalizeEntries(files, config.entryTime)
+	}
+	files = dropDirs(files, config)
+	split.EmptyDirectories(files)
+	if config.format == formatCpio {
+		for _, file := range files {
+			if err := checkCpioSize(file); err != nil {
+				fatal(withStatus(exitTooLarge, err))
+			}
+		}
+	}
+
+	if *spannedOutput {
+		var total uint64
+		for _, file := range files {
+			total += entrySize(file, config)
+		}
+		startMeter(*progressFormat, total)
+
+		exitStatus = exitWrite
+		volumes, err := writeSpanned(files, openRaw, config)
+		if err != nil {
+			fatal(err)
+		}
+		meter.finish()
+		saveJournal(*journal, config)
+		emitEvent(event{Event: "finished", Parts: volumes})
+		return
+	}
+
+	debugSizes(files, config)
+
+	// The analysis counts the parts of the entries which fit, rather
+	// than failing on those which don't.
+	all := files
+	var never []*zip.FileHeader
+	if *analyze && dests == nil && *parts == 0 && *maxParts == 0 {
+		files, never = fittingEntries(files, config)
+	}
+
+	var buckets []*Bucket
+	if dests != nil {
+		buckets, err = fitDestinations(files, dests, config)
+	} else if *parts > 0 {
+		var splitSize uint64
+		buckets, splitSize, err = fitParts(files, *parts, config)
+		if err == nil {
+			if config.verbose {
+				fmt.Fprintf(progress, "Using a split size of %s to make %d parts.\n",
+					numberToHuman(splitSize), len(buckets))
+			}
+			config.splitSize = splitSize
+		}
+	} else if *maxParts > 0 {
+		var splitSize uint64
+		buckets, splitSize, err = fitWithin(files, *maxParts, config)
+		if err == nil && splitSize != config.splitSize {
+			fmt.Fprintf(progress, "Growing the split size to %s to make %d parts.\n",
+				numberToHuman(splitSize), len(buckets))
+			config.splitSize = splitSize
+		}
+	} else {
+		buckets, err = fit(files, config)
+	}
+	if err == nil {
+		recreateDirs(buckets, config)
+	}
+	if err == nil && config.noZip64 {
+		err = checkZip32(buckets)
+	}
+	if err != nil {
+		fatal(err)
+	}
+
+	nameParts(buckets, config)
+	if *analyze {
+		printAnalysis(all, buckets, never, config)
+		return
+	}
+	checkSignedJar(files, buckets, config)
+	if *dryRun {
+		printLayout(buckets, config)
+		return
+	}
+
+	setComments(buckets, config)
+	if config.sealKey != nil {
+		sealBuckets(buckets, contents, config)
+	}
+
+	if config.verbose {
+		fmt.Fprintf(progress, "Splitting takes %d files.\n", len(buckets))
+		printAssignments(buckets, config)
+	}
+	emitEvent(event{
+		Event:   "plan",
+		Parts:   len(buckets),
+		Entries: len(files),
+		Size:    config.splitSize})
+
+	exitStatus = exitWrite
+	toWrite, err := partsToWrite(buckets, config)
+	if err != nil {
+		fatal(err)
+	}
+
+	var total uint64
+	for _, bucket := range toWrite {
+		total += bucket.Size
+	}
+	startMeter(*progressFormat, total)
+
+	if err := writeParts(toWrite, writePart, config.jobs); err != nil {
+		fatal(err)
+	}
+	if err := closeSink(config); err != nil {
+		fatal(err)
+	}
+	meter.finish()
+
+	exitStatus = exitVerify
+	if config.quickVerify {
+		for _, bucket := range buckets {
+			if err := bucket.quickVerify(config); err != nil {
+				fatal(err)
+			}
+		}
+		if config.verbose {
+			fmt.Fprintln(progress, "All parts match the source.")
+		}
+	}
+
+	if config.verify {
+		for _, bucket := range buckets {
+			if config.verbose {
+				fmt.Fprintf(progress, "Verifying %s..", bucket.Name)
+			}
+			if err := bucket.verify(config); err != nil {
+				fatal(err)
+			}
+			if config.verbose {
+				fmt.Fprintln(progress, "done.")
+			}
+		}
+	}
+
+	exitStatus = exitWrite
+	if config.torrentName != "" {
+		if err := makeTorrent(buckets, config); err != nil {
+			fatal(err)
+		}
+	}
+
+	if config.joinScripts {
+		if err := makeJoinScripts(buckets, config); err != nil {
+			fatal(err)
+		}
+	}
+
+	if config.checksum != "" {
+		if err := makeChecksums(buckets, config); err != nil {
+			fatal(err)
+		}
+	}
+
+	if config.manifestName != "" {
+		err := makeManifest(buckets, contents, config).Save(config.manifestName)
+		if err != nil {
+			fatal(err)
+		}
+	}
+	saveJournal(*journal, config)
+	emitEvent(event{Event: "finished", Parts: len(buckets)})
+
+	if *reportFormat == reportJSON {
+		if err := printReport(buckets, config); err != nil {
+			fatal(err)
 		}
 	}
 }
@@ -0,0 +1,116 @@
+package zstd
+
+import "math/bits"
+
+// The predefined distributions of the literal length, match length and
+// offset codes, in which -1 stands for less than one.
+var (
+	literalLengthTable = newTable(6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1})
+
+	matchLengthTable = newTable(6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1})
+
+	offsetTable = newTable(5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1})
+)
+
+// An FSE table as the decoder builds it, along with what it takes to
+// encode with it.
+type fseTable struct {
+	log uint
+
+	// The symbol of every state, and the bits read and the state they
+	// are added to for the next one.
+	symbol   []uint8
+	nbBits   []uint8
+	baseline []uint16
+
+	// The state to go to for a symbol, by the state the decoder goes
+	// to after it, and a state for every symbol to start from.
+	next  [][]uint16
+	first []uint16
+}
+
+func newTable(log uint, distribution []int16) *fseTable {
+	size := 1 << log
+	t := &fseTable{
+		log:      log,
+		symbol:   make([]uint8, size),
+		nbBits:   make([]uint8, size),
+		baseline: make([]uint16, size),
+		next:     make([][]uint16, len(distribution)),
+		first:    make([]uint16, len(distribution))}
+
+	// Symbols of less than one get a state each at the end, the others
+	// are spread over the rest.
+	high := size - 1
+	total := 0
+	for s, count := range distribution {
+		if count == -1 {
+			t.symbol[high] = uint8(s)
+			high--
+			total++
+		}
+	}
+
+	pos := 0
+	step := size>>1 + size>>3 + 3
+	for s, count := range distribution {
+		for range max(count, 0) {
+			t.symbol[pos] = uint8(s)
+			total++
+			for {
+				pos = (pos + step) & (size - 1)
+				if pos <= high {
+					break
+				}
+			}
+		}
+	}
+	if total != size || pos != 0 {
+		panic("zstd: invalid distribution")
+	}
+
+	counts := make([]int, len(distribution))
+	for s, count := range distribution {
+		counts[s] = max(int(count), 1)
+		t.next[s] = make([]uint16, size)
+	}
+	seen := make([]bool, len(distribution))
+	for state := range size {
+		s := t.symbol[state]
+		x := counts[s]
+		counts[s]++
+
+		nb := log - uint(bits.Len(uint(x))-1)
+		t.nbBits[state] = uint8(nb)
+		t.baseline[state] = uint16(x<<nb - size)
+
+		for i := range 1 << nb {
+			t.next[s][int(t.baseline[state])+i] = uint16(state)
+		}
+		if !seen[s] {
+			t.first[s] = uint16(state)
+			seen[s] = true
+		}
+	}
+
+	return t
+}
+
+// Encode a symbol before the one whose state is given, writing the
+// bits which take the decoder from its state to the given one, and
+// return its state.
+func (t *fseTable) encode(bw *bitWriter, symbol uint8, state uint16) uint16 {
+	prev := t.next[symbol][state]
+	bw.add(uint32(state-t.baseline[prev]), uint(t.nbBits[prev]))
+
+	return prev
+}
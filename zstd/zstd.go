@@ -0,0 +1,368 @@
+// Package zstd writes Zstandard frames, as described in RFC 8878, the
+// way zip archives hold them as compression method 93.
+//
+// Matches are found with a single hash table and coded with the
+// predefined FSE tables, while the literals are left as they are. That
+// keeps it small and fast, at the cost of compressing less than the
+// reference encoder does.
+package zstd
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+// Method is the zip compression method of Zstandard data.
+const Method = 93
+
+const (
+	magic = 0xfd2fb528
+
+	// Matches reach back at most a window, and blocks are as large
+	// as they can be.
+	windowLog  = 20
+	windowSize = 1 << windowLog
+	blockSize  = 128 << 10
+
+	minMatch = 4
+	hashLog  = 16
+
+	// Block types.
+	blockRaw        = 0
+	blockCompressed = 2
+)
+
+var ErrClosed = errors.New("zstd: write after close")
+
+// A match and the literals before it.
+type sequence struct {
+	litLen   uint32
+	matchLen uint32
+	offset   uint32
+}
+
+// Writer compresses what is written to it into a single frame, which
+// Close finishes.
+type Writer struct {
+	w   io.Writer
+	err error
+
+	// The window followed by the block being filled, which starts at
+	// start.
+	hist  []byte
+	start int
+
+	// Where in hist the four bytes with a hash were last seen, plus
+	// one so that zero is none.
+	table [1 << hashLog]int32
+
+	wroteHeader bool
+	closed      bool
+
+	// Reused for every block.
+	seqs []sequence
+	lits []byte
+	out  []byte
+}
+
+// NewWriter returns a Writer compressing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write compresses p, writing out every block that fills up.
+func (zw *Writer) Write(p []byte) (int, error) {
+	if zw.closed {
+		return 0, ErrClosed
+	}
+
+	written := 0
+	for len(p) > 0 && zw.err == nil {
+		// A full block is only written when more follows, so the
+		// last one can be marked as such on Close.
+		if len(zw.hist)-zw.start == blockSize {
+			zw.writeBlock(false)
+			continue
+		}
+
+		n := min(len(p), blockSize-(len(zw.hist)-zw.start))
+		zw.hist = append(zw.hist, p[:n]...)
+		p = p[n:]
+		written += n
+	}
+
+	return written, zw.err
+}
+
+// Close writes the last block, the underlying writer is not closed.
+func (zw *Writer) Close() error {
+	if zw.closed {
+		return zw.err
+	}
+
+	zw.closed = true
+	if zw.err == nil {
+		zw.writeBlock(true)
+	}
+
+	return zw.err
+}
+
+// Write the frame header: no content size, checksum or dictionary,
+// and the window size.
+func (zw *Writer) writeHeader() {
+	var header [6]byte
+	binary.LittleEndian.PutUint32(header[:], magic)
+	header[5] = (windowLog - 10) << 3
+	_, zw.err = zw.w.Write(header[:])
+	zw.wroteHeader = true
+}
+
+// Compress the block being filled and write it, or write it as it is
+// when that doesn't make it smaller.
+func (zw *Writer) writeBlock(last bool) {
+	if !zw.wroteHeader {
+		if zw.writeHeader(); zw.err != nil {
+			return
+		}
+	}
+
+	block := zw.hist[zw.start:]
+	zw.findSequences()
+	zw.out = zw.out[:0]
+	zw.out = append(zw.out, 0, 0, 0)
+	zw.encodeLiterals()
+	zw.encodeSequences()
+
+	blockType := blockCompressed
+	size := len(zw.out) - 3
+	if size >= len(block) {
+		blockType = blockRaw
+		size = len(block)
+		zw.out = append(zw.out[:3], block...)
+	}
+
+	header := uint32(size)<<3 | uint32(blockType)<<1
+	if last {
+		header |= 1
+	}
+	zw.out[0] = byte(header)
+	zw.out[1] = byte(header >> 8)
+	zw.out[2] = byte(header >> 16)
+
+	if _, zw.err = zw.w.Write(zw.out); zw.err != nil {
+		return
+	}
+
+	zw.start = len(zw.hist)
+	zw.slide()
+}
+
+// Keep no more of what was written than a window.
+func (zw *Writer) slide() {
+	if zw.start <= windowSize {
+		return
+	}
+
+	shift := zw.start - windowSize
+	zw.hist = zw.hist[:copy(zw.hist, zw.hist[shift:])]
+	zw.start -= shift
+
+	for i, pos := range zw.table {
+		zw.table[i] = max(pos-int32(shift), 0)
+	}
+}
+
+func hash(v uint32) uint32 {
+	return v * 2654435761 >> (32 - hashLog)
+}
+
+// Cut the block being filled into matches and the literals between
+// them, leaving the literals after the last match in zw.lits as well.
+func (zw *Writer) findSequences() {
+	zw.seqs = zw.seqs[:0]
+	zw.lits = zw.lits[:0]
+
+	hist := zw.hist
+	end := len(hist)
+	anchor := zw.start
+
+	for i := zw.start; i+minMatch <= end; {
+		v := binary.LittleEndian.Uint32(hist[i:])
+		h := hash(v)
+		candidate := int(zw.table[h]) - 1
+		zw.table[h] = int32(i + 1)
+
+		if candidate < 0 || i-candidate > windowSize ||
+			binary.LittleEndian.Uint32(hist[candidate:]) != v {
+			// Skip ahead faster the longer nothing matches.
+			i += 1 + (i-anchor)>>6
+			continue
+		}
+
+		n := minMatch
+		for i+n < end && hist[candidate+n] == hist[i+n] {
+			n++
+		}
+
+		zw.lits = append(zw.lits, hist[anchor:i]...)
+		zw.seqs = append(zw.seqs, sequence{
+			litLen:   uint32(i - anchor),
+			matchLen: uint32(n),
+			offset:   uint32(i - candidate)})
+
+		i += n
+		anchor = i
+	}
+
+	zw.lits = append(zw.lits, hist[anchor:end]...)
+}
+
+// Write the literals section, with the literals as they are.
+func (zw *Writer) encodeLiterals() {
+	size := len(zw.lits)
+	switch {
+	case size < 1<<5:
+		zw.out = append(zw.out, byte(size<<3))
+	case size < 1<<12:
+		zw.out = append(zw.out, byte(1<<2|size<<4), byte(size>>4))
+	default:
+		zw.out = append(zw.out, byte(3<<2|size<<4), byte(size>>4),
+			byte(size>>12))
+	}
+
+	zw.out = append(zw.out, zw.lits...)
+}
+
+// Write the sequences section, coded with the predefined tables. The
+// bitstream is read backwards, so the last sequence goes in first.
+func (zw *Writer) encodeSequences() {
+	n := len(zw.seqs)
+	switch {
+	case n < 128:
+		zw.out = append(zw.out, byte(n))
+	case n < 0x7f00:
+		zw.out = append(zw.out, byte(n>>8+128), byte(n))
+	default:
+		zw.out = append(zw.out, 0xff, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if n == 0 {
+		return
+	}
+
+	// All three use the predefined mode.
+	zw.out = append(zw.out, 0)
+
+	bw := bitWriter{out: zw.out}
+	var llState, mlState, ofState uint16
+	for i := n - 1; i >= 0; i-- {
+		seq := zw.seqs[i]
+		ll, llBits, llExtra := literalLengthCode(seq.litLen)
+		ml, mlBits, mlExtra := matchLengthCode(seq.matchLen)
+		of, ofBits, ofExtra := offsetCode(seq.offset)
+
+		if i == n-1 {
+			llState = literalLengthTable.first[ll]
+			mlState = matchLengthTable.first[ml]
+			ofState = offsetTable.first[of]
+		} else {
+			ofState = offsetTable.encode(&bw, of, ofState)
+			mlState = matchLengthTable.encode(&bw, ml, mlState)
+			llState = literalLengthTable.encode(&bw, ll, llState)
+		}
+
+		bw.add(llExtra, llBits)
+		bw.add(mlExtra, mlBits)
+		bw.add(ofExtra, ofBits)
+	}
+
+	bw.add(uint32(mlState), matchLengthTable.log)
+	bw.add(uint32(ofState), offsetTable.log)
+	bw.add(uint32(llState), literalLengthTable.log)
+	zw.out = bw.close()
+}
+
+// Return the code of a literal length, and the extra bits that go
+// with it.
+func literalLengthCode(length uint32) (uint8, uint, uint32) {
+	if length < 16 {
+		return uint8(length), 0, 0
+	}
+
+	code := len(literalLengthBase) - 1
+	for literalLengthBase[code] > length {
+		code--
+	}
+
+	return uint8(16 + code), literalLengthExtra[code],
+		length - literalLengthBase[code]
+}
+
+// Return the code of a match length, and the extra bits that go with
+// it.
+func matchLengthCode(length uint32) (uint8, uint, uint32) {
+	if length < 35 {
+		return uint8(length - 3), 0, 0
+	}
+
+	code := len(matchLengthBase) - 1
+	for matchLengthBase[code] > length {
+		code--
+	}
+
+	return uint8(32 + code), matchLengthExtra[code],
+		length - matchLengthBase[code]
+}
+
+// Return the code of an offset, and the extra bits that go with it.
+// Offsets are given plus three, as the values below that stand for
+// repeated offsets, which aren't used.
+func offsetCode(offset uint32) (uint8, uint, uint32) {
+	value := offset + 3
+	code := uint(bits.Len32(value)) - 1
+
+	return uint8(code), code, value - 1<<code
+}
+
+// Where the literal and match lengths of the codes after the ones
+// standing for themselves start, and how many extra bits they take.
+var (
+	literalLengthBase = []uint32{16, 18, 20, 22, 24, 28, 32, 40, 48, 64,
+		128, 256, 512, 1024, 2048, 4096, 8192, 16384, 32768, 65536}
+	literalLengthExtra = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 6,
+		7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	matchLengthBase = []uint32{35, 37, 39, 41, 43, 47, 51, 59, 67, 83,
+		99, 131, 259, 515, 1027, 2051, 4099, 8195, 16387, 32771, 65539}
+	matchLengthExtra = []uint{1, 1, 1, 1, 2, 2, 3, 3, 4, 4,
+		5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+)
+
+// Writes bits from the least significant end up, ending with a set bit
+// so the reader knows where they start.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	count uint
+}
+
+func (bw *bitWriter) add(value uint32, n uint) {
+	bw.acc |= uint64(value&(1<<n-1)) << bw.count
+	bw.count += n
+	for bw.count >= 8 {
+		bw.out = append(bw.out, byte(bw.acc))
+		bw.acc >>= 8
+		bw.count -= 8
+	}
+}
+
+func (bw *bitWriter) close() []byte {
+	bw.add(1, 1)
+	if bw.count > 0 {
+		bw.out = append(bw.out, byte(bw.acc))
+	}
+
+	return bw.out
+}